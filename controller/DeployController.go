@@ -6,10 +6,9 @@ import (
 	"github.com/zhenorzz/goploy/model"
 	"github.com/zhenorzz/goploy/service"
 	"github.com/zhenorzz/goploy/utils"
+	"path"
 	"strconv"
 	"strings"
-
-	"github.com/google/uuid"
 )
 
 // Deploy struct
@@ -101,6 +100,7 @@ func (deploy Deploy) GetCommitList(gp *core.Goploy) *core.Response {
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	defer service.LockRepository(project.ID)()
 	srcPath := core.RepositoryPath + project.Name
 	git := utils.GIT{Dir: srcPath}
 	if err := git.Clean([]string{"-f"}); err != nil {
@@ -129,42 +129,236 @@ func (deploy Deploy) Publish(gp *core.Goploy) *core.Response {
 	type ReqData struct {
 		ProjectID int64  `json:"projectId" validate:"gt=0"`
 		Commit    string `json:"commit"`
+		// Ref is the branch, the tag or the commit to deploy, empty means the project branch
+		Ref string `json:"ref" validate:"max=255"`
+		// Justification is required when the admin deploys during the freeze
+		Justification string `json:"justification" validate:"max=255"`
 	}
 	var reqData ReqData
 	if err := verify(gp.Body, &reqData); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
 
+	if err := service.CheckRef(reqData.Ref); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
 	project, err := model.Project{ID: reqData.ProjectID}.GetData()
 
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
 
-	if project.DeployState == model.ProjectDeploying {
-		return &core.Response{Code: core.Deny, Message: "Project is being build by other"}
+	freezeWindow, frozen, err := service.GetActiveFreeze(project)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	freezeOverride := uint8(model.Disable)
+	if frozen {
+		if gp.Namespace.Role != core.RoleAdmin || len(reqData.Justification) == 0 {
+			return &core.Response{Code: core.Deny, Message: service.FreezeMessage(freezeWindow) + ", only the admin can deploy with a justification"}
+		}
+		freezeOverride = model.Enable
 	}
 
-	projectServers, err := model.ProjectServer{ProjectID: reqData.ProjectID}.GetBindServerListByProjectID()
+	deployQueue, err := service.Enqueue(model.DeployQueue{
+		ProjectID:      project.ID,
+		ProjectName:    project.Name,
+		CommitID:       reqData.Commit,
+		Branch:         project.Branch,
+		Ref:            reqData.Ref,
+		Source:         model.QueueSourceManual,
+		PublisherID:    gp.UserInfo.ID,
+		PublisherName:  gp.UserInfo.Name,
+		FreezeOverride: freezeOverride,
+	})
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if frozen {
+		_, err := model.FreezeOverride{
+			ProjectID:     project.ID,
+			QueueID:       deployQueue.ID,
+			WindowID:      freezeWindow.ID,
+			UserID:        gp.UserInfo.ID,
+			UserName:      gp.UserInfo.Name,
+			Justification: reqData.Justification,
+		}.AddRow()
+		if err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+	}
+
+	if deployQueue.State == model.QueueApproving {
+		return &core.Response{Message: "The deploy is waiting for approval"}
+	}
+
+	dispatchedID, err := service.DispatchQueue(project.ID)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if dispatchedID != deployQueue.ID {
+		return &core.Response{Message: "Project is being build by other, the deploy is queued"}
+	}
+	return &core.Response{Message: "deploying"}
+}
+
+// GetReleaseList list the releases retained on each server
+func (deploy Deploy) GetReleaseList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		ServerReleases []service.ServerRelease `json:"list"`
+	}
+	id, err := strconv.ParseInt(gp.URLQuery.Get("id"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if _, err := (model.Project{ID: id, UserID: gp.UserInfo.ID}).GetUserProjectData(); err != nil {
+		return &core.Response{Code: core.Deny, Message: "no permission"}
+	}
+	project, err := model.Project{ID: id}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if len(project.SymlinkPath) == 0 {
+		return &core.Response{Code: core.Deny, Message: "Project does not use symlink deploy"}
+	}
+	projectServers, err := model.ProjectServer{ProjectID: id}.GetBindServerListByProjectID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{ServerReleases: service.GetReleaseList(project, projectServers)}}
+}
+
+// SwitchRelease point the project path to a retained release
+func (deploy Deploy) SwitchRelease(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectID int64  `json:"projectId" validate:"gt=0"`
+		Token     string `json:"token" validate:"uuid"`
+		// Justification is required when the admin switches during the freeze
+		Justification string `json:"justification" validate:"max=255"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	project, err := model.Project{ID: reqData.ProjectID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
 
+	if len(project.SymlinkPath) == 0 {
+		return &core.Response{Code: core.Deny, Message: "Project does not use symlink deploy"}
+	}
+
+	freezeWindow, frozen, err := service.GetActiveFreeze(project)
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
-	project.PublisherID = gp.UserInfo.ID
-	project.PublisherName = gp.UserInfo.Name
-	project.DeployState = model.ProjectDeploying
-	project.LastPublishToken = uuid.New().String()
-	err = project.Publish()
+	if frozen && (gp.Namespace.Role != core.RoleAdmin || len(reqData.Justification) == 0) {
+		return &core.Response{Code: core.Deny, Message: service.FreezeMessage(freezeWindow) + ", only the admin can switch the release with a justification"}
+	}
+
+	projectServers, err := model.ProjectServer{ProjectID: reqData.ProjectID}.GetBindServerListByProjectID()
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	if claimed, err := service.ClaimProject(&project, gp.UserInfo); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	} else if !claimed {
+		return &core.Response{Code: core.Deny, Message: "Project is being build by other"}
+	}
+	if frozen {
+		_, err := model.FreezeOverride{
+			ProjectID:     project.ID,
+			WindowID:      freezeWindow.ID,
+			UserID:        gp.UserInfo.ID,
+			UserName:      gp.UserInfo.Name,
+			Justification: reqData.Justification,
+		}.AddRow()
+		if err != nil {
+			core.Log(core.ERROR, "projectID:"+strconv.FormatInt(project.ID, 10)+" record the freeze override fail, "+err.Error())
+		}
+	}
 	go service.Sync{
 		UserInfo:       gp.UserInfo,
 		Project:        project,
 		ProjectServers: projectServers,
-		CommitID:       reqData.Commit,
-	}.Exec()
-	return &core.Response{Message: "deploying"}
+	}.SwitchRelease(reqData.Token)
+	return &core.Response{Message: "switching"}
+}
+
+// PruneRelease remove the expired releases by the retention policy
+func (deploy Deploy) PruneRelease(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectID int64 `json:"projectId" validate:"gt=0"`
+	}
+	type RespData struct {
+		ServerPrunes []service.ServerPrune `json:"list"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	project, err := model.Project{ID: reqData.ProjectID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if len(project.SymlinkPath) == 0 {
+		return &core.Response{Code: core.Deny, Message: "Project does not use symlink deploy"}
+	}
+
+	projectServers, err := model.ProjectServer{ProjectID: reqData.ProjectID}.GetBindServerListByProjectID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	serverPrunes, claimed, err := service.Sync{
+		UserInfo:       gp.UserInfo,
+		Project:        project,
+		ProjectServers: projectServers,
+	}.PruneReleases()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	} else if !claimed {
+		return &core.Response{Code: core.Deny, Message: "Project is being build by other"}
+	}
+	return &core.Response{Data: RespData{ServerPrunes: serverPrunes}}
+}
+
+// Cancel stop the running deploy
+func (deploy Deploy) Cancel(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectID int64 `json:"projectId" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CancelDeploy(reqData.ProjectID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Message: "cancelling"}
+}
+
+// CanaryConfirm continue or abort the deploy after the canary server
+func (deploy Deploy) CanaryConfirm(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectID int64 `json:"projectId" validate:"gt=0"`
+		Pass      bool  `json:"pass"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.ConfirmCanary(reqData.ProjectID, reqData.Pass); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
 }
 
 // Webhook connect
@@ -175,7 +369,8 @@ func (deploy Deploy) Webhook(gp *core.Goploy) *core.Response {
 	}
 	// other event is blocked in deployMiddleware
 	type ReqData struct {
-		Ref string `json:"ref" validate:"required"`
+		Ref   string `json:"ref" validate:"required"`
+		After string `json:"after"`
 	}
 	var reqData ReqData
 	if err := verify(gp.Body, &reqData); err != nil {
@@ -195,36 +390,176 @@ func (deploy Deploy) Webhook(gp *core.Goploy) *core.Response {
 		return &core.Response{Code: core.Deny, Message: "Webhook auto deploy turn off, go to project setting turn on"}
 	}
 
-	if branch := strings.Split(reqData.Ref, "/")[2]; project.Branch != branch {
+	// the push of the tag deploys the tag, the push of the branch deploys the project branch
+	var ref string
+	if strings.HasPrefix(reqData.Ref, "refs/tags/") {
+		tag := strings.TrimPrefix(reqData.Ref, "refs/tags/")
+		if len(reqData.After) != 0 && strings.Trim(reqData.After, "0") == "" {
+			return &core.Response{Code: core.Deny, Message: "Receive tag:" + tag + " delete event"}
+		}
+		if matched, err := path.Match(project.TagPattern, tag); len(project.TagPattern) == 0 || err != nil || !matched {
+			return &core.Response{Code: core.Deny, Message: "Receive tag:" + tag + " push event, not match the tag pattern"}
+		}
+		if err := service.CheckRef(tag); err != nil {
+			return &core.Response{Code: core.Deny, Message: err.Error()}
+		}
+		ref = tag
+	} else if branch := strings.TrimPrefix(reqData.Ref, "refs/heads/"); project.Branch != branch {
 		return &core.Response{Code: core.Deny, Message: "Receive branch:" + branch + " push event, not equal to current branch"}
 	}
 
-	if project.DeployState == model.ProjectDeploying {
-		return &core.Response{Code: core.Deny, Message: "Project is being build by other"}
+	gp.UserInfo, err = model.User{ID: 1}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
 
-	gp.UserInfo, err = model.User{ID: 1}.GetData()
+	// the push during deploying is queued, the pushes of the same branch collapse into one
+	if _, err := service.Enqueue(model.DeployQueue{
+		ProjectID:     project.ID,
+		ProjectName:   project.Name,
+		Branch:        project.Branch,
+		Ref:           ref,
+		Source:        model.QueueSourceWebhook,
+		PublisherID:   gp.UserInfo.ID,
+		PublisherName: gp.UserInfo.Name,
+	}); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if _, err := service.DispatchQueue(project.ID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if freezeWindow, frozen, err := service.GetActiveFreeze(project); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	} else if frozen {
+		return &core.Response{Message: service.FreezeMessage(freezeWindow) + ", the push is queued until the freeze ends"}
+	}
+	return &core.Response{Message: "receive push signal"}
+}
+
+// GetQueueList the waiting deploys
+func (deploy Deploy) GetQueueList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		DeployQueues model.DeployQueues `json:"list"`
+	}
+	projectID, err := strconv.ParseInt(gp.URLQuery.Get("projectId"), 10, 64)
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	if _, err := (model.Project{ID: projectID, UserID: gp.UserInfo.ID}).GetUserProjectData(); err != nil {
+		return &core.Response{Code: core.Deny, Message: "no permission"}
+	}
+	deployQueues, err := model.DeployQueue{ProjectID: projectID}.GetWaitingList()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{DeployQueues: deployQueues}}
+}
 
-	projectServers, err := model.ProjectServer{ProjectID: project.ID}.GetBindServerListByProjectID()
+// CancelQueue remove the waiting deploy from the queue
+func (deploy Deploy) CancelQueue(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	// check the publish auth against the project of the queued deploy
+	deployQueue, err := model.DeployQueue{ID: reqData.ID}.GetData()
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
-	project.PublisherID = gp.UserInfo.ID
-	project.PublisherName = gp.UserInfo.Name
-	project.DeployState = model.ProjectDeploying
-	project.LastPublishToken = uuid.New().String()
-	err = project.Publish()
+	if _, err := (model.Project{ID: deployQueue.ProjectID, UserID: gp.UserInfo.ID}).GetUserProjectData(); err != nil {
+		return &core.Response{Code: core.Deny, Message: "no permission"}
+	}
+	canceled, err := model.DeployQueue{ID: reqData.ID, State: model.QueueCanceled}.ChangeWaitingState()
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
-	go service.Sync{
-		UserInfo:       gp.UserInfo,
-		Project:        project,
-		ProjectServers: projectServers,
-		CommitID:       "",
-	}.Exec()
-	return &core.Response{Message: "receive push signal"}
+	if !canceled {
+		// the deploy may be waiting for approval
+		if canceled, err = (model.DeployQueue{ID: reqData.ID, State: model.QueueCanceled}).ChangeApprovingState(); err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+	}
+	if !canceled {
+		return &core.Response{Code: core.Deny, Message: "The deploy is not waiting in the queue"}
+	}
+	return &core.Response{}
+}
+
+// GetApprovingList the deploys waiting for approval
+func (deploy Deploy) GetApprovingList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		DeployQueues model.DeployQueues `json:"list"`
+	}
+	projectID, err := strconv.ParseInt(gp.URLQuery.Get("projectId"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if project, err := (model.Project{ID: projectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+	deployQueues, err := model.DeployQueue{ProjectID: projectID}.GetApprovingList()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{DeployQueues: deployQueues}}
+}
+
+// GetApprovalList who decided on the deploy and when
+func (deploy Deploy) GetApprovalList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		DeployApprovals model.DeployApprovals `json:"list"`
+	}
+	id, err := strconv.ParseInt(gp.URLQuery.Get("id"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	deployQueue, err := model.DeployQueue{ID: id}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if project, err := (model.Project{ID: deployQueue.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "The deploy is not in the namespace"}
+	}
+	deployApprovals, err := model.DeployApproval{QueueID: id}.GetListByQueueID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{DeployApprovals: deployApprovals}}
+}
+
+// Approve the deploy waiting for approval, it is dispatched once it gets enough approvals
+func (deploy Deploy) Approve(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID      int64  `json:"id" validate:"gt=0"`
+		Comment string `json:"comment" validate:"max=255"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if err := service.DecideDeploy(reqData.ID, gp.UserInfo, gp.Namespace, model.ApprovalApprove, reqData.Comment); err != nil {
+		return &core.Response{Code: core.Deny, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// Reject the deploy waiting for approval
+func (deploy Deploy) Reject(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID      int64  `json:"id" validate:"gt=0"`
+		Comment string `json:"comment" validate:"max=255"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if err := service.DecideDeploy(reqData.ID, gp.UserInfo, gp.Namespace, model.ApprovalReject, reqData.Comment); err != nil {
+		return &core.Response{Code: core.Deny, Message: err.Error()}
+	}
+	return &core.Response{}
 }
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/service"
+)
+
+// Freeze struct
+type Freeze Controller
+
+// GetList freeze window list, filter by projectId when it is set
+func (freeze Freeze) GetList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		FreezeWindows model.FreezeWindows `json:"list"`
+	}
+	var projectID int64
+	if len(gp.URLQuery.Get("projectId")) != 0 {
+		var err error
+		if projectID, err = strconv.ParseInt(gp.URLQuery.Get("projectId"), 10, 64); err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+	}
+	freezeWindows, err := model.FreezeWindow{NamespaceID: gp.Namespace.ID, ProjectID: projectID}.GetList()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{FreezeWindows: freezeWindows}}
+}
+
+// Add one freeze window, projectId 0 means the window freezes the namespace
+func (freeze Freeze) Add(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectID int64  `json:"projectId" validate:"min=0"`
+		Type      uint8  `json:"type" validate:"min=1,max=2"`
+		Cron      string `json:"cron" validate:"max=255"`
+		StartTime string `json:"startTime"`
+		EndTime   string `json:"endTime"`
+		Reason    string `json:"reason" validate:"max=255"`
+	}
+	type RespData struct {
+		ID int64 `json:"id"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckFreezeWindow(reqData.Type, reqData.Cron, reqData.StartTime, reqData.EndTime); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if reqData.ProjectID != 0 {
+		if project, err := (model.Project{ID: reqData.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+			return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+		}
+	}
+
+	freezeWindow := model.FreezeWindow{
+		NamespaceID: gp.Namespace.ID,
+		ProjectID:   reqData.ProjectID,
+		Type:        reqData.Type,
+		Reason:      reqData.Reason,
+		CreatorID:   gp.UserInfo.ID,
+		Creator:     gp.UserInfo.Name,
+	}
+	if reqData.Type == model.FreezeRecurring {
+		freezeWindow.Cron = reqData.Cron
+	} else {
+		freezeWindow.StartTime, freezeWindow.EndTime = reqData.StartTime, reqData.EndTime
+	}
+	id, err := freezeWindow.AddRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{ID: id}}
+}
+
+// Edit one freeze window
+func (freeze Freeze) Edit(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID        int64  `json:"id" validate:"gt=0"`
+		Type      uint8  `json:"type" validate:"min=1,max=2"`
+		Cron      string `json:"cron" validate:"max=255"`
+		StartTime string `json:"startTime"`
+		EndTime   string `json:"endTime"`
+		Reason    string `json:"reason" validate:"max=255"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckFreezeWindow(reqData.Type, reqData.Cron, reqData.StartTime, reqData.EndTime); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	freezeWindow := model.FreezeWindow{
+		ID:          reqData.ID,
+		NamespaceID: gp.Namespace.ID,
+		Type:        reqData.Type,
+		Reason:      reqData.Reason,
+		EditorID:    gp.UserInfo.ID,
+		Editor:      gp.UserInfo.Name,
+	}
+	if reqData.Type == model.FreezeRecurring {
+		freezeWindow.Cron = reqData.Cron
+	} else {
+		freezeWindow.StartTime, freezeWindow.EndTime = reqData.StartTime, reqData.EndTime
+	}
+
+	if err := freezeWindow.EditRow(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// Remove one freeze window
+func (freeze Freeze) Remove(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := (model.FreezeWindow{ID: reqData.ID, NamespaceID: gp.Namespace.ID}).DeleteRow(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// GetOverrideList the deploys made by the admin during the freeze
+func (freeze Freeze) GetOverrideList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		FreezeOverrides model.FreezeOverrides `json:"list"`
+	}
+	projectID, err := strconv.ParseInt(gp.URLQuery.Get("projectId"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	freezeOverrides, err := model.FreezeOverride{ProjectID: projectID}.GetListByProjectID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{FreezeOverrides: freezeOverrides}}
+}
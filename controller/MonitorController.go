@@ -3,7 +3,7 @@ package controller
 import (
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
-	"net"
+	"github.com/zhenorzz/goploy/utils"
 	"strconv"
 	"time"
 )
@@ -49,8 +49,7 @@ func (monitor Monitor) Check(gp *core.Goploy) *core.Response {
 	if err := verify(gp.Body, &reqData); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
-	_, err := net.DialTimeout("tcp", reqData.Domain+":"+strconv.Itoa(reqData.Port), 5*time.Second)
-	if err != nil {
+	if err := utils.DialTCP(reqData.Domain+":"+strconv.Itoa(reqData.Port), 5*time.Second); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
 	return &core.Response{Message: "Connected"}
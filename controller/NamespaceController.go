@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"database/sql"
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
 	"strconv"
@@ -88,6 +89,8 @@ func (namespace Namespace) Add(gp *core.Goploy) *core.Response {
 	if err := (model.NamespaceUser{NamespaceID: id}).AddAdminByNamespaceID(); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	// the super managers join the new namespace
+	core.DeleteNamespaceCache()
 
 	return &core.Response{Data: RespData{ID: id}}
 }
@@ -110,6 +113,7 @@ func (namespace Namespace) Edit(gp *core.Goploy) *core.Response {
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	core.DeleteNamespaceCache()
 	return &core.Response{}
 }
 
@@ -125,6 +129,17 @@ func (namespace Namespace) AddUser(gp *core.Goploy) *core.Response {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
 
+	// the users bound before with another role must login again
+	var roleChangedUserIDs []int64
+	for _, userID := range reqData.UserIDs {
+		namespaceUser, err := model.NamespaceUser{NamespaceID: reqData.NamespaceID, UserID: userID}.GetDataByUserID()
+		if err == nil && namespaceUser.Role != reqData.Role {
+			roleChangedUserIDs = append(roleChangedUserIDs, userID)
+		} else if err != nil && err != sql.ErrNoRows {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+	}
+
 	namespaceUsersModel := model.NamespaceUsers{}
 	for _, userID := range reqData.UserIDs {
 		namespaceUserModel := model.NamespaceUser{
@@ -138,6 +153,12 @@ func (namespace Namespace) AddUser(gp *core.Goploy) *core.Response {
 	if err := namespaceUsersModel.AddMany(); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	core.DeleteNamespaceCache(reqData.UserIDs...)
+	for _, userID := range roleChangedUserIDs {
+		if err := core.RevokeSessions(userID, ""); err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+	}
 
 	if reqData.Role == core.RoleManager {
 		err := model.ProjectUser{}.AddNamespaceProjectInUserID(reqData.NamespaceID, reqData.UserIDs)
@@ -159,8 +180,89 @@ func (namespace Namespace) RemoveUser(gp *core.Goploy) *core.Response {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
 
+	namespaceUser, err := model.NamespaceUser{ID: reqData.NamespaceUserID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
 	if err := (model.NamespaceUser{ID: reqData.NamespaceUserID}).DeleteRow(); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	core.DeleteNamespaceCache(namespaceUser.UserID)
+	// the user loses the role in the namespace, the sessions must not keep it
+	if err := core.RevokeSessions(namespaceUser.UserID, ""); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// GetEnvironmentApprovalList the approval policy of each environment in the namespace
+func (namespace Namespace) GetEnvironmentApprovalList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		EnvironmentApprovals model.EnvironmentApprovals `json:"list"`
+	}
+	environmentApprovals, err := model.EnvironmentApproval{NamespaceID: gp.Namespace.ID}.GetListByNamespaceID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{EnvironmentApprovals: environmentApprovals}}
+}
+
+// EditEnvironmentApproval set the approval policy of the environment,
+// it applies to the projects which do not set their own policy
+func (namespace Namespace) EditEnvironmentApproval(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		Environment   string `json:"environment" validate:"required,max=255"`
+		ApprovalCount uint16 `json:"approvalCount" validate:"min=0,max=10"`
+		ApprovalRole  string `json:"approvalRole" validate:"omitempty,role"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	err := model.EnvironmentApproval{
+		NamespaceID:   gp.Namespace.ID,
+		Environment:   reqData.Environment,
+		ApprovalCount: reqData.ApprovalCount,
+		ApprovalRole:  reqData.ApprovalRole,
+	}.AddOrUpdate()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// RemoveEnvironmentApproval the environment no longer needs approval
+func (namespace Namespace) RemoveEnvironmentApproval(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := (model.EnvironmentApproval{ID: reqData.ID, NamespaceID: gp.Namespace.ID}).DeleteRow(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// EditTwoFactorRole require the two-factor authentication for the role and above in the namespace,
+// empty means not required
+func (namespace Namespace) EditTwoFactorRole(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		TwoFactorRole string `json:"twoFactorRole" validate:"omitempty,role"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	err := model.Namespace{ID: gp.Namespace.ID, TwoFactorRole: reqData.TwoFactorRole}.EditTwoFactorRole()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	core.DeleteNamespaceCache()
 	return &core.Response{}
 }
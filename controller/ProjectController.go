@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/service"
 	"github.com/zhenorzz/goploy/utils"
 	"os"
 	"os/exec"
@@ -76,6 +77,31 @@ func (project Project) GetRemoteBranchList(gp *core.Goploy) *core.Response {
 	return &core.Response{Data: RespData{Branch: branch}}
 }
 
+// GetRemoteTagList -
+func (project Project) GetRemoteTagList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		Tag []string `json:"tag"`
+	}
+
+	url := gp.URLQuery.Get("url")
+	cmd := exec.Command("git", "ls-remote", "-t", "--refs", url)
+	var cmdOutbuf, cmdErrbuf bytes.Buffer
+	cmd.Stdout = &cmdOutbuf
+	cmd.Stderr = &cmdErrbuf
+	if err := cmd.Run(); err != nil {
+		return &core.Response{Code: core.Error, Message: cmdErrbuf.String()}
+	}
+	var tag []string
+	for _, tagWithSha := range strings.Split(cmdOutbuf.String(), "\n") {
+		if len(tagWithSha) != 0 {
+			tagWithShaSlice := strings.Fields(tagWithSha)
+			tag = append(tag, strings.TrimPrefix(tagWithShaSlice[len(tagWithShaSlice)-1], "refs/tags/"))
+		}
+	}
+
+	return &core.Response{Data: RespData{Tag: tag}}
+}
+
 // GetBindServerList -
 func (project Project) GetBindServerList(gp *core.Goploy) *core.Response {
 	type RespData struct {
@@ -111,21 +137,42 @@ func (project Project) GetBindUserList(gp *core.Goploy) *core.Response {
 // Add project
 func (project Project) Add(gp *core.Goploy) *core.Response {
 	type ReqData struct {
-		Name                  string  `json:"name" validate:"required"`
-		URL                   string  `json:"url" validate:"required"`
-		Path                  string  `json:"path" validate:"required"`
-		Environment           string  `json:"Environment" validate:"required"`
-		Branch                string  `json:"branch" validate:"required"`
-		SymlinkPath           string  `json:"symlinkPath"`
-		AfterPullScriptMode   string  `json:"afterPullScriptMode"`
-		AfterPullScript       string  `json:"afterPullScript"`
-		AfterDeployScriptMode string  `json:"afterDeployScriptMode"`
-		AfterDeployScript     string  `json:"afterDeployScript"`
-		RsyncOption           string  `json:"rsyncOption"`
-		ServerIDs             []int64 `json:"serverIds"`
-		UserIDs               []int64 `json:"userIds"`
-		NotifyType            uint8   `json:"notifyType"`
-		NotifyTarget          string  `json:"notifyTarget"`
+		Name                   string  `json:"name" validate:"required"`
+		URL                    string  `json:"url" validate:"required"`
+		Path                   string  `json:"path" validate:"required"`
+		Environment            string  `json:"Environment" validate:"required"`
+		Branch                 string  `json:"branch" validate:"required"`
+		SymlinkPath            string  `json:"symlinkPath"`
+		BeforePullScriptMode   string  `json:"beforePullScriptMode"`
+		BeforePullScript       string  `json:"beforePullScript"`
+		AfterPullScriptMode    string  `json:"afterPullScriptMode"`
+		AfterPullScript        string  `json:"afterPullScript"`
+		BeforeDeployScriptMode string  `json:"beforeDeployScriptMode"`
+		BeforeDeployScript     string  `json:"beforeDeployScript"`
+		AfterDeployScriptMode  string  `json:"afterDeployScriptMode"`
+		AfterDeployScript      string  `json:"afterDeployScript"`
+		RsyncOption            string  `json:"rsyncOption"`
+		Variables              string  `json:"variables"`
+		Pipeline               string  `json:"pipeline"`
+		StageTimeout           string  `json:"stageTimeout"`
+		TransferMode           uint8   `json:"transferMode" validate:"min=0,max=1"`
+		ArtifactMode           uint8   `json:"artifactMode" validate:"min=0,max=1"`
+		ArtifactPath           string  `json:"artifactPath"`
+		DeployStrategy         uint8   `json:"deployStrategy" validate:"min=0,max=2"`
+		BatchSize              uint16  `json:"batchSize"`
+		CanaryConfirm          uint8   `json:"canaryConfirm" validate:"min=0,max=1"`
+		AutoRollback           uint8   `json:"autoRollback" validate:"min=0,max=1"`
+		RetainCount            uint16  `json:"retainCount"`
+		RetainDays             uint16  `json:"retainDays"`
+		PinnedReleases         string  `json:"pinnedReleases"`
+		RequeueInterrupted     uint8   `json:"requeueInterrupted" validate:"min=0,max=1"`
+		ApprovalCount          uint16  `json:"approvalCount" validate:"min=0,max=10"`
+		ApprovalRole           string  `json:"approvalRole" validate:"omitempty,role"`
+		TagPattern             string  `json:"tagPattern" validate:"max=255"`
+		ServerIDs              []int64 `json:"serverIds"`
+		UserIDs                []int64 `json:"userIds"`
+		NotifyType             uint8   `json:"notifyType"`
+		NotifyTarget           string  `json:"notifyTarget"`
 	}
 	var reqData ReqData
 	if err := verify(gp.Body, &reqData); err != nil {
@@ -136,26 +183,67 @@ func (project Project) Add(gp *core.Goploy) *core.Response {
 		return &core.Response{Code: core.Error, Message: "Invalid rsync option format"}
 	}
 
+	if err := service.CheckPipeline(reqData.Pipeline); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckStageTimeout(reqData.StageTimeout); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckArtifact(reqData.ArtifactMode, reqData.ArtifactPath, reqData.Pipeline); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckVariables(reqData.Variables); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckTagPattern(reqData.TagPattern); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
 	_, err := model.Project{Name: reqData.Name}.GetDataByName()
 	if err != sql.ErrNoRows {
 		return &core.Response{Code: core.Error, Message: "The project name is already exist"}
 	}
 
 	projectID, err := model.Project{
-		NamespaceID:           gp.Namespace.ID,
-		Name:                  reqData.Name,
-		URL:                   reqData.URL,
-		Path:                  reqData.Path,
-		SymlinkPath:           reqData.SymlinkPath,
-		Environment:           reqData.Environment,
-		Branch:                reqData.Branch,
-		AfterPullScriptMode:   reqData.AfterPullScriptMode,
-		AfterPullScript:       reqData.AfterPullScript,
-		AfterDeployScriptMode: reqData.AfterDeployScriptMode,
-		AfterDeployScript:     reqData.AfterDeployScript,
-		RsyncOption:           reqData.RsyncOption,
-		NotifyType:            reqData.NotifyType,
-		NotifyTarget:          reqData.NotifyTarget,
+		NamespaceID:            gp.Namespace.ID,
+		Name:                   reqData.Name,
+		URL:                    reqData.URL,
+		Path:                   reqData.Path,
+		SymlinkPath:            reqData.SymlinkPath,
+		Environment:            reqData.Environment,
+		Branch:                 reqData.Branch,
+		BeforePullScriptMode:   reqData.BeforePullScriptMode,
+		BeforePullScript:       reqData.BeforePullScript,
+		AfterPullScriptMode:    reqData.AfterPullScriptMode,
+		AfterPullScript:        reqData.AfterPullScript,
+		BeforeDeployScriptMode: reqData.BeforeDeployScriptMode,
+		BeforeDeployScript:     reqData.BeforeDeployScript,
+		AfterDeployScriptMode:  reqData.AfterDeployScriptMode,
+		AfterDeployScript:      reqData.AfterDeployScript,
+		RsyncOption:            reqData.RsyncOption,
+		Variables:              reqData.Variables,
+		Pipeline:               reqData.Pipeline,
+		StageTimeout:           reqData.StageTimeout,
+		TransferMode:           reqData.TransferMode,
+		ArtifactMode:           reqData.ArtifactMode,
+		ArtifactPath:           reqData.ArtifactPath,
+		DeployStrategy:         reqData.DeployStrategy,
+		BatchSize:              reqData.BatchSize,
+		CanaryConfirm:          reqData.CanaryConfirm,
+		AutoRollback:           reqData.AutoRollback,
+		RetainCount:            reqData.RetainCount,
+		RetainDays:             reqData.RetainDays,
+		PinnedReleases:         reqData.PinnedReleases,
+		RequeueInterrupted:     reqData.RequeueInterrupted,
+		ApprovalCount:          reqData.ApprovalCount,
+		ApprovalRole:           reqData.ApprovalRole,
+		TagPattern:             reqData.TagPattern,
+		NotifyType:             reqData.NotifyType,
+		NotifyTarget:           reqData.NotifyTarget,
 	}.AddRow()
 
 	if err != nil {
@@ -205,20 +293,41 @@ func (project Project) Add(gp *core.Goploy) *core.Response {
 // Edit project
 func (project Project) Edit(gp *core.Goploy) *core.Response {
 	type ReqData struct {
-		ID                    int64  `json:"id" validate:"gt=0"`
-		Name                  string `json:"name"`
-		URL                   string `json:"url"`
-		Path                  string `json:"path"`
-		SymlinkPath           string `json:"symlinkPath"`
-		Environment           string `json:"Environment"`
-		Branch                string `json:"branch"`
-		AfterPullScriptMode   string `json:"afterPullScriptMode"`
-		AfterPullScript       string `json:"afterPullScript"`
-		AfterDeployScriptMode string `json:"afterDeployScriptMode"`
-		AfterDeployScript     string `json:"afterDeployScript"`
-		RsyncOption           string `json:"rsyncOption"`
-		NotifyType            uint8  `json:"notifyType"`
-		NotifyTarget          string `json:"notifyTarget"`
+		ID                     int64  `json:"id" validate:"gt=0"`
+		Name                   string `json:"name"`
+		URL                    string `json:"url"`
+		Path                   string `json:"path"`
+		SymlinkPath            string `json:"symlinkPath"`
+		Environment            string `json:"Environment"`
+		Branch                 string `json:"branch"`
+		BeforePullScriptMode   string `json:"beforePullScriptMode"`
+		BeforePullScript       string `json:"beforePullScript"`
+		AfterPullScriptMode    string `json:"afterPullScriptMode"`
+		AfterPullScript        string `json:"afterPullScript"`
+		BeforeDeployScriptMode string `json:"beforeDeployScriptMode"`
+		BeforeDeployScript     string `json:"beforeDeployScript"`
+		AfterDeployScriptMode  string `json:"afterDeployScriptMode"`
+		AfterDeployScript      string `json:"afterDeployScript"`
+		RsyncOption            string `json:"rsyncOption"`
+		Variables              string `json:"variables"`
+		Pipeline               string `json:"pipeline"`
+		StageTimeout           string `json:"stageTimeout"`
+		TransferMode           uint8  `json:"transferMode" validate:"min=0,max=1"`
+		ArtifactMode           uint8  `json:"artifactMode" validate:"min=0,max=1"`
+		ArtifactPath           string `json:"artifactPath"`
+		DeployStrategy         uint8  `json:"deployStrategy" validate:"min=0,max=2"`
+		BatchSize              uint16 `json:"batchSize"`
+		CanaryConfirm          uint8  `json:"canaryConfirm" validate:"min=0,max=1"`
+		AutoRollback           uint8  `json:"autoRollback" validate:"min=0,max=1"`
+		RetainCount            uint16 `json:"retainCount"`
+		RetainDays             uint16 `json:"retainDays"`
+		PinnedReleases         string `json:"pinnedReleases"`
+		RequeueInterrupted     uint8  `json:"requeueInterrupted" validate:"min=0,max=1"`
+		ApprovalCount          uint16 `json:"approvalCount" validate:"min=0,max=10"`
+		ApprovalRole           string `json:"approvalRole" validate:"omitempty,role"`
+		TagPattern             string `json:"tagPattern" validate:"max=255"`
+		NotifyType             uint8  `json:"notifyType"`
+		NotifyTarget           string `json:"notifyTarget"`
 	}
 	var reqData ReqData
 	if err := verify(gp.Body, &reqData); err != nil {
@@ -229,6 +338,26 @@ func (project Project) Edit(gp *core.Goploy) *core.Response {
 		return &core.Response{Code: core.Error, Message: "Invalid rsync option format"}
 	}
 
+	if err := service.CheckPipeline(reqData.Pipeline); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckStageTimeout(reqData.StageTimeout); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckArtifact(reqData.ArtifactMode, reqData.ArtifactPath, reqData.Pipeline); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckVariables(reqData.Variables); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckTagPattern(reqData.TagPattern); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
 	projectList, err := model.Project{NamespaceID: gp.Namespace.ID, Name: reqData.Name}.GetAllByName()
 	if err != nil {
 		if err != sql.ErrNoRows {
@@ -248,20 +377,41 @@ func (project Project) Edit(gp *core.Goploy) *core.Response {
 	}
 
 	err = model.Project{
-		ID:                    reqData.ID,
-		Name:                  reqData.Name,
-		URL:                   reqData.URL,
-		Path:                  reqData.Path,
-		SymlinkPath:           reqData.SymlinkPath,
-		Environment:           reqData.Environment,
-		Branch:                reqData.Branch,
-		AfterPullScriptMode:   reqData.AfterPullScriptMode,
-		AfterPullScript:       reqData.AfterPullScript,
-		AfterDeployScriptMode: reqData.AfterDeployScriptMode,
-		AfterDeployScript:     reqData.AfterDeployScript,
-		RsyncOption:           reqData.RsyncOption,
-		NotifyType:            reqData.NotifyType,
-		NotifyTarget:          reqData.NotifyTarget,
+		ID:                     reqData.ID,
+		Name:                   reqData.Name,
+		URL:                    reqData.URL,
+		Path:                   reqData.Path,
+		SymlinkPath:            reqData.SymlinkPath,
+		Environment:            reqData.Environment,
+		Branch:                 reqData.Branch,
+		BeforePullScriptMode:   reqData.BeforePullScriptMode,
+		BeforePullScript:       reqData.BeforePullScript,
+		AfterPullScriptMode:    reqData.AfterPullScriptMode,
+		AfterPullScript:        reqData.AfterPullScript,
+		BeforeDeployScriptMode: reqData.BeforeDeployScriptMode,
+		BeforeDeployScript:     reqData.BeforeDeployScript,
+		AfterDeployScriptMode:  reqData.AfterDeployScriptMode,
+		AfterDeployScript:      reqData.AfterDeployScript,
+		RsyncOption:            reqData.RsyncOption,
+		Variables:              reqData.Variables,
+		Pipeline:               reqData.Pipeline,
+		StageTimeout:           reqData.StageTimeout,
+		TransferMode:           reqData.TransferMode,
+		ArtifactMode:           reqData.ArtifactMode,
+		ArtifactPath:           reqData.ArtifactPath,
+		DeployStrategy:         reqData.DeployStrategy,
+		BatchSize:              reqData.BatchSize,
+		CanaryConfirm:          reqData.CanaryConfirm,
+		AutoRollback:           reqData.AutoRollback,
+		RetainCount:            reqData.RetainCount,
+		RetainDays:             reqData.RetainDays,
+		PinnedReleases:         reqData.PinnedReleases,
+		RequeueInterrupted:     reqData.RequeueInterrupted,
+		ApprovalCount:          reqData.ApprovalCount,
+		ApprovalRole:           reqData.ApprovalRole,
+		TagPattern:             reqData.TagPattern,
+		NotifyType:             reqData.NotifyType,
+		NotifyTarget:           reqData.NotifyTarget,
 	}.EditRow()
 
 	if err != nil {
@@ -329,6 +479,60 @@ func (project Project) SetAutoDeploy(gp *core.Goploy) *core.Response {
 	return &core.Response{}
 }
 
+// GetWebhookInfo the webhook url and the secret to configure in github, gitlab or gitee
+func (project Project) GetWebhookInfo(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		URL    string `json:"url"`
+		Secret string `json:"secret"`
+	}
+	id, err := strconv.ParseInt(gp.URLQuery.Get("id"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	projectData, err := model.Project{ID: id}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if projectData.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+	secret, err := model.Project{ID: id}.GetWebhookSecret()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{URL: service.WebhookURL(gp.Request, id), Secret: secret}}
+}
+
+// GenerateWebhookSecret generate or rotate the webhook secret, the old one stops working at once
+func (project Project) GenerateWebhookSecret(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	type RespData struct {
+		URL    string `json:"url"`
+		Secret string `json:"secret"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	projectData, err := model.Project{ID: reqData.ID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if projectData.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+	secret, err := service.GenerateWebhookSecret()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if err := (model.Project{ID: reqData.ID, WebhookSecret: secret}).EditWebhookSecret(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{URL: service.WebhookURL(gp.Request, reqData.ID), Secret: secret}}
+}
+
 // RemoveRow Project
 func (project Project) Remove(gp *core.Goploy) *core.Response {
 	type ReqData struct {
@@ -384,6 +588,39 @@ func (project Project) AddServer(gp *core.Goploy) *core.Response {
 	return &core.Response{}
 }
 
+// EditServerVariables of the server bound to project
+func (project Project) EditServerVariables(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectServerID int64  `json:"projectServerId" validate:"gt=0"`
+		Variables       string `json:"variables"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	projectServer, err := model.ProjectServer{ID: reqData.ProjectServerID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	projectData, err := model.Project{ID: projectServer.ProjectID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if projectData.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+
+	if err := service.CheckVariables(reqData.Variables); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := (model.ProjectServer{ID: reqData.ProjectServerID, Variables: reqData.Variables}).EditVariables(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
 // AddUser to project
 func (project Project) AddUser(gp *core.Goploy) *core.Response {
 	type ReqData struct {
@@ -541,6 +778,299 @@ func (project Project) RemoveTask(gp *core.Goploy) *core.Response {
 	return &core.Response{}
 }
 
+// GetConfigTemplateList -
+func (project Project) GetConfigTemplateList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		ProjectConfigTemplates model.ProjectConfigTemplates `json:"list"`
+	}
+	id, err := strconv.ParseInt(gp.URLQuery.Get("id"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if project, err := (model.Project{ID: id}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+	projectConfigTemplates, err := model.ProjectConfigTemplate{ProjectID: id}.GetListByProjectID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{ProjectConfigTemplates: projectConfigTemplates}}
+}
+
+// AddConfigTemplate to project
+func (project Project) AddConfigTemplate(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectID int64  `json:"projectId" validate:"gt=0"`
+		Path      string `json:"path" validate:"required,max=255"`
+		Content   string `json:"content"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if project, err := (model.Project{ID: reqData.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+
+	if err := service.CheckConfigTemplate(reqData.Path, reqData.Content); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	id, err := model.ProjectConfigTemplate{
+		ProjectID: reqData.ProjectID,
+		Path:      reqData.Path,
+		Content:   reqData.Content,
+		Creator:   gp.UserInfo.Name,
+		CreatorID: gp.UserInfo.ID,
+	}.AddRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	type RespData struct {
+		ID int64 `json:"id"`
+	}
+	return &core.Response{Data: RespData{ID: id}}
+}
+
+// EditConfigTemplate from project
+func (project Project) EditConfigTemplate(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID      int64  `json:"id" validate:"gt=0"`
+		Path    string `json:"path" validate:"required,max=255"`
+		Content string `json:"content"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	configTemplate, err := model.ProjectConfigTemplate{ID: reqData.ID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if project, err := (model.Project{ID: configTemplate.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+
+	if err := service.CheckConfigTemplate(reqData.Path, reqData.Content); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	err = model.ProjectConfigTemplate{
+		ID:       reqData.ID,
+		Path:     reqData.Path,
+		Content:  reqData.Content,
+		Editor:   gp.UserInfo.Name,
+		EditorID: gp.UserInfo.ID,
+	}.EditRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// RemoveConfigTemplate from project
+func (project Project) RemoveConfigTemplate(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	configTemplate, err := model.ProjectConfigTemplate{ID: reqData.ID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if project, err := (model.Project{ID: configTemplate.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+
+	if err := (model.ProjectConfigTemplate{ID: reqData.ID}).DeleteRow(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// PreviewConfigTemplate render the config template for the project server, the secrets are redacted
+func (project Project) PreviewConfigTemplate(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		Content string `json:"content"`
+	}
+	id, err := strconv.ParseInt(gp.URLQuery.Get("id"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	projectServerID, err := strconv.ParseInt(gp.URLQuery.Get("projectServerId"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	configTemplate, err := model.ProjectConfigTemplate{ID: id}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	projectData, err := model.Project{ID: configTemplate.ProjectID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if projectData.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+	projectServers, err := model.ProjectServer{ProjectID: configTemplate.ProjectID}.GetBindServerListByProjectID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	for _, projectServer := range projectServers {
+		if projectServer.ID != projectServerID {
+			continue
+		}
+		content, err := service.PreviewConfigTemplate(gp.UserInfo, projectData, projectServer, configTemplate)
+		if err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+		return &core.Response{Data: RespData{Content: content}}
+	}
+	return &core.Response{Code: core.Error, Message: "The server is not bound to the project"}
+}
+
+// GetHealthCheckList -
+func (project Project) GetHealthCheckList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		ProjectHealthChecks model.ProjectHealthChecks `json:"list"`
+	}
+	id, err := strconv.ParseInt(gp.URLQuery.Get("id"), 10, 64)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if project, err := (model.Project{ID: id}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+	projectHealthChecks, err := model.ProjectHealthCheck{ProjectID: id}.GetListByProjectID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{ProjectHealthChecks: projectHealthChecks}}
+}
+
+// AddHealthCheck to project
+func (project Project) AddHealthCheck(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectID    int64  `json:"projectId" validate:"gt=0"`
+		Type         uint8  `json:"type" validate:"min=1,max=2"`
+		Target       string `json:"target" validate:"required,max=255"`
+		ExpectStatus int    `json:"expectStatus" validate:"min=0,max=599"`
+		ExpectBody   string `json:"expectBody" validate:"max=255"`
+		Retries      uint16 `json:"retries" validate:"max=100"`
+		Interval     uint16 `json:"interval" validate:"max=600"`
+		Timeout      uint16 `json:"timeout" validate:"max=600"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if project, err := (model.Project{ID: reqData.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+
+	if err := service.CheckHealthCheck(reqData.Type, reqData.Target); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	id, err := model.ProjectHealthCheck{
+		ProjectID:    reqData.ProjectID,
+		Type:         reqData.Type,
+		Target:       reqData.Target,
+		ExpectStatus: reqData.ExpectStatus,
+		ExpectBody:   reqData.ExpectBody,
+		Retries:      reqData.Retries,
+		Interval:     reqData.Interval,
+		Timeout:      reqData.Timeout,
+	}.AddRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	type RespData struct {
+		ID int64 `json:"id"`
+	}
+	return &core.Response{Data: RespData{ID: id}}
+}
+
+// EditHealthCheck from project
+func (project Project) EditHealthCheck(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID           int64  `json:"id" validate:"gt=0"`
+		Type         uint8  `json:"type" validate:"min=1,max=2"`
+		Target       string `json:"target" validate:"required,max=255"`
+		ExpectStatus int    `json:"expectStatus" validate:"min=0,max=599"`
+		ExpectBody   string `json:"expectBody" validate:"max=255"`
+		Retries      uint16 `json:"retries" validate:"max=100"`
+		Interval     uint16 `json:"interval" validate:"max=600"`
+		Timeout      uint16 `json:"timeout" validate:"max=600"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	healthCheck, err := model.ProjectHealthCheck{ID: reqData.ID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if project, err := (model.Project{ID: healthCheck.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+
+	if err := service.CheckHealthCheck(reqData.Type, reqData.Target); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	err = model.ProjectHealthCheck{
+		ID:           reqData.ID,
+		Type:         reqData.Type,
+		Target:       reqData.Target,
+		ExpectStatus: reqData.ExpectStatus,
+		ExpectBody:   reqData.ExpectBody,
+		Retries:      reqData.Retries,
+		Interval:     reqData.Interval,
+		Timeout:      reqData.Timeout,
+	}.EditRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// RemoveHealthCheck from project
+func (project Project) RemoveHealthCheck(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	healthCheck, err := model.ProjectHealthCheck{ID: reqData.ID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if project, err := (model.Project{ID: healthCheck.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+	}
+
+	if err := (model.ProjectHealthCheck{ID: reqData.ID}).DeleteRow(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
 // repoCreate -
 func repoCreate(projectID int64) {
 	project, err := model.Project{ID: projectID}.GetData()
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/service"
+)
+
+// Secret struct
+type Secret Controller
+
+// GetList secret list without the value, filter by projectId when it is set
+func (secret Secret) GetList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		Secrets model.Secrets `json:"list"`
+	}
+	var projectID int64
+	if len(gp.URLQuery.Get("projectId")) != 0 {
+		var err error
+		if projectID, err = strconv.ParseInt(gp.URLQuery.Get("projectId"), 10, 64); err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+	}
+	secretList, err := model.Secret{NamespaceID: gp.Namespace.ID, ProjectID: projectID}.GetList()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{Secrets: secretList}}
+}
+
+// Add one secret, projectId 0 means the secret is shared by the namespace
+func (secret Secret) Add(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ProjectID   int64  `json:"projectId" validate:"min=0"`
+		Name        string `json:"name" validate:"required,max=255"`
+		Value       string `json:"value" validate:"required"`
+		Description string `json:"description" validate:"max=255"`
+	}
+	type RespData struct {
+		ID int64 `json:"id"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckSecretName(reqData.Name); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := service.CheckSecretValue(reqData.Value); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if reqData.ProjectID != 0 {
+		if project, err := (model.Project{ID: reqData.ProjectID}).GetData(); err != nil || project.NamespaceID != gp.Namespace.ID {
+			return &core.Response{Code: core.Deny, Message: "Project is not in the namespace"}
+		}
+	}
+
+	value, err := service.EncryptSecret(reqData.Value)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	id, err := model.Secret{
+		NamespaceID: gp.Namespace.ID,
+		ProjectID:   reqData.ProjectID,
+		Name:        reqData.Name,
+		Value:       value,
+		Description: reqData.Description,
+		CreatorID:   gp.UserInfo.ID,
+		Creator:     gp.UserInfo.Name,
+	}.AddRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{ID: id}}
+}
+
+// Edit one secret, the value is kept when it is empty
+func (secret Secret) Edit(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID          int64  `json:"id" validate:"gt=0"`
+		Value       string `json:"value"`
+		Description string `json:"description" validate:"max=255"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	var value string
+	if len(reqData.Value) != 0 {
+		if err := service.CheckSecretValue(reqData.Value); err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+		var err error
+		if value, err = service.EncryptSecret(reqData.Value); err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+	}
+
+	err := model.Secret{
+		ID:          reqData.ID,
+		NamespaceID: gp.Namespace.ID,
+		Value:       value,
+		Description: reqData.Description,
+		EditorID:    gp.UserInfo.ID,
+		Editor:      gp.UserInfo.Name,
+	}.EditRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// Remove one secret
+func (secret Secret) Remove(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := (model.Secret{ID: reqData.ID, NamespaceID: gp.Namespace.ID}).DeleteRow(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
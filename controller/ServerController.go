@@ -3,6 +3,7 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"gopkg.in/go-playground/validator.v9"
 	"os/exec"
 	"strconv"
@@ -10,10 +11,12 @@ import (
 
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/service"
 	"github.com/zhenorzz/goploy/utils"
 	"github.com/zhenorzz/goploy/ws"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
 )
 
 // Server struct
@@ -192,8 +195,9 @@ func (server Server) Remove(gp *core.Goploy) *core.Response {
 // Install Server Environment
 func (server Server) Install(gp *core.Goploy) *core.Response {
 	type ReqData struct {
-		ServerID   int64 `json:"serverId" validate:"gt=0"`
-		TemplateID int64 `json:"templateId" validate:"gt=0"`
+		ServerID    int64    `json:"serverId" validate:"gt=0"`
+		TemplateID  int64    `json:"templateId" validate:"gt=0"`
+		SecretNames []string `json:"secretNames"`
 	}
 	var reqData ReqData
 	if err := verify(gp.Body, &reqData); err != nil {
@@ -207,6 +211,10 @@ func (server Server) Install(gp *core.Goploy) *core.Response {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
 
+	if serverInfo.NamespaceID != gp.Namespace.ID {
+		return &core.Response{Code: core.Deny, Message: "Server is not in the namespace"}
+	}
+
 	templateInfo, err := model.Template{
 		ID: reqData.TemplateID,
 	}.GetData()
@@ -217,13 +225,13 @@ func (server Server) Install(gp *core.Goploy) *core.Response {
 	serverInfo.LastInstallToken = uuid.New().String()
 	serverInfo.Install()
 
-	go remoteInstall(gp.UserInfo, serverInfo, templateInfo)
+	go remoteInstall(gp.UserInfo, serverInfo, templateInfo, reqData.SecretNames)
 
 	return &core.Response{Message: "Installing"}
 }
 
 // remoteInstall -
-func remoteInstall(userInfo model.User, server model.Server, template model.Template) {
+func remoteInstall(userInfo model.User, server model.Server, template model.Template, secretNames []string) {
 	installTraceModel := model.InstallTrace{
 		Token:        server.LastInstallToken,
 		ServerID:     server.ID,
@@ -301,7 +309,13 @@ func remoteInstall(userInfo model.User, server model.Server, template model.Temp
 	}
 
 	var scriptError error
-	session, connectError := utils.ConnectSSH(server.Owner, "", server.IP, server.Port)
+	// the session has no pty, the pty never passes the eof of the stdin carrying the secrets
+	var session *ssh.Session
+	client, connectError := utils.DialSSH(server.Owner, "", server.IP, server.Port)
+	if connectError == nil {
+		defer client.Close()
+		session, connectError = client.NewSession()
+	}
 	ext, _ := json.Marshal(struct {
 		SSH string `json:"ssh"`
 	}{"ssh -p" + strconv.Itoa(server.Port) + " " + server.Owner + "@" + server.IP})
@@ -343,13 +357,22 @@ func remoteInstall(userInfo model.User, server model.Server, template model.Temp
 	var sshOutbuf, sshErrbuf bytes.Buffer
 	session.Stdout = &sshOutbuf
 	session.Stderr = &sshErrbuf
-	templateInstallScript := "echo '" + template.Script + "' > /tmp/goploy/template-install.sh;bash /tmp/goploy/template-install.sh"
 	ext, _ = json.Marshal(struct {
 		Script string `json:"script"`
 	}{template.Script})
 	installTraceModel.Ext = string(ext)
 	installTraceModel.Type = model.Script
-	if scriptError = session.Run(templateInstallScript); scriptError != nil {
+	// the namespace secrets picked by the installer are exported to the template script
+	templateInstallScript, stdin, secretError := service.SecretExportCommand(server.NamespaceID, secretNames, "echo '"+template.Script+"' > /tmp/goploy/template-install.sh;bash /tmp/goploy/template-install.sh")
+	if secretError == nil {
+		if len(stdin) != 0 {
+			session.Stdin = strings.NewReader(stdin)
+		}
+		scriptError = session.Run(templateInstallScript)
+	} else {
+		scriptError = errors.New("load secrets fail, " + secretError.Error())
+	}
+	if scriptError != nil {
 		installTraceModel.State = model.Fail
 		installTraceModel.Detail = scriptError.Error()
 		installTraceModel.AddRow()
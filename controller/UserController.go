@@ -4,11 +4,15 @@ import (
 	"database/sql"
 	"github.com/patrickmn/go-cache"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/service"
 )
 
 // User struct
@@ -17,8 +21,8 @@ type User Controller
 // Login -
 func (user User) Login(gp *core.Goploy) *core.Response {
 	type ReqData struct {
-		Account  string `json:"account" validate:"min=5,max=12"`
-		Password string `json:"password" validate:"password"`
+		Account  string `json:"account" validate:"min=1,max=30"`
+		Password string `json:"password" validate:"required"`
 	}
 	type RespData struct {
 		Token         string           `json:"token"`
@@ -28,28 +32,225 @@ func (user User) Login(gp *core.Goploy) *core.Response {
 	if err := verify(gp.Body, &reqData); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
-	userData, err := model.User{Account: reqData.Account}.GetDataByAccount()
+	userData, err := service.Authenticate(reqData.Account, reqData.Password)
+	if err != nil {
+		return &core.Response{Code: core.Deny, Message: err.Error()}
+	}
+
+	if response := user.twoFactorChallenge(userData); response != nil {
+		return response
+	}
+
+	token, namespaceList, response := user.signIn(gp, userData)
+	if response != nil {
+		return response
+	}
+	return &core.Response{Data: RespData{Token: token, NamespaceList: namespaceList}}
+}
+
+// OIDCLogin redirect to the login page of the OIDC provider
+func (user User) OIDCLogin(gp *core.Goploy) *core.Response {
+	authenticator, err := service.GetOIDCAuthenticator()
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	authCodeURL, err := authenticator.AuthCodeURL()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	http.Redirect(gp.ResponseWriter, gp.Request, authCodeURL, http.StatusFound)
+	return nil
+}
 
-	if err := userData.Validate(reqData.Password); err != nil {
+// OIDCCallback verify the id token, issue the same login cookie as the password login and redirect to the home page
+func (user User) OIDCCallback(gp *core.Goploy) *core.Response {
+	if errMsg := gp.URLQuery.Get("error"); errMsg != "" {
+		return &core.Response{Code: core.Deny, Message: errMsg + " " + gp.URLQuery.Get("error_description")}
+	}
+	authenticator, err := service.GetOIDCAuthenticator()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	userData, err := authenticator.Callback(gp.Request.Context(), gp.URLQuery.Get("state"), gp.URLQuery.Get("code"))
+	if err != nil {
 		return &core.Response{Code: core.Deny, Message: err.Error()}
 	}
 
+	redirect := os.Getenv("OIDC_LOGIN_REDIRECT")
+	if redirect == "" {
+		redirect = "/"
+	}
+
+	// the login page finishes the two-factor authentication with the pre-auth token
+	if response := user.twoFactorChallenge(userData); response != nil {
+		if response.Code != core.TwoFactorRequired {
+			return response
+		}
+		query := url.Values{}
+		query.Set("preAuthToken", response.Data.(preAuthData).PreAuthToken)
+		query.Set("enrolled", strconv.FormatBool(response.Data.(preAuthData).Enrolled))
+		if strings.Contains(redirect, "?") {
+			redirect += "&" + query.Encode()
+		} else {
+			redirect += "?" + query.Encode()
+		}
+		http.Redirect(gp.ResponseWriter, gp.Request, redirect, http.StatusFound)
+		return nil
+	}
+
+	_, namespaceList, response := user.signIn(gp, userData)
+	if response != nil {
+		return response
+	}
+
+	// the namespace cookie is kept if the user is still in the namespace
+	namespaceID := namespaceList[len(namespaceList)-1].ID
+	if namespaceCookie, err := gp.Request.Cookie(core.NamespaceCookieName); err == nil {
+		for _, namespace := range namespaceList {
+			if strconv.FormatInt(namespace.ID, 10) == namespaceCookie.Value {
+				namespaceID = namespace.ID
+			}
+		}
+	}
+	cookie := http.Cookie{Name: core.NamespaceCookieName, Value: strconv.FormatInt(namespaceID, 10), Path: "/", MaxAge: 86400 * 365}
+	http.SetCookie(gp.ResponseWriter, &cookie)
+
+	http.Redirect(gp.ResponseWriter, gp.Request, redirect, http.StatusFound)
+	return nil
+}
+
+// preAuthData the login waits for the two-factor code
+type preAuthData struct {
+	PreAuthToken string `json:"preAuthToken"`
+	// Enrolled false means the user must enroll before entering the code
+	Enrolled bool `json:"enrolled"`
+}
+
+// twoFactorChallenge return the pre-auth token if the user enabled the two-factor authentication
+// or any namespace of the user requires it
+func (user User) twoFactorChallenge(userData model.User) *core.Response {
 	if userData.State == model.Disable {
-		return &core.Response{Code: core.AccountDisabled, Message: "Account is disabled"}
+		return nil
+	}
+	enabled, err := service.TwoFactorEnabled(userData.ID)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if !enabled {
+		required, err := service.TwoFactorRequired(userData.ID)
+		if err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		} else if !required {
+			return nil
+		}
+	}
+	preAuthToken, err := service.CreatePreAuthToken(userData.ID)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{
+		Code:    core.TwoFactorRequired,
+		Message: "Two-factor authentication is required",
+		Data:    preAuthData{PreAuthToken: preAuthToken, Enrolled: enabled},
 	}
+}
 
-	namespaceList, err := core.GetNamespace(userData.ID)
+// LoginEnroll generate the TOTP secret for the user who must enroll during the login
+func (user User) LoginEnroll(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		PreAuthToken string `json:"preAuthToken" validate:"required"`
+	}
+	type RespData struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	preAuth, err := service.GetPreAuth(reqData.PreAuthToken)
 	if err != nil {
-		return &core.Response{Code: core.Error, Message: "尚未分配空间，请联系管理员"}
+		return &core.Response{Code: core.LoginExpired, Message: err.Error()}
 	}
+	userData, err := model.User{ID: preAuth.UserID}.GetData()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	secret, uri, err := service.EnrollTwoFactor(userData)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{Secret: secret, URI: uri}}
+}
 
-	token, err := userData.CreateToken()
+// LoginVerify check the two-factor code of the pre-auth token and finish the login,
+// the first code of the enrolling user enables the two-factor authentication
+func (user User) LoginVerify(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		PreAuthToken string `json:"preAuthToken" validate:"required"`
+		Code         string `json:"code" validate:"required"`
+	}
+	type RespData struct {
+		Token         string           `json:"token"`
+		NamespaceList model.Namespaces `json:"namespaceList"`
+		RecoveryCodes []string         `json:"recoveryCodes,omitempty"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	preAuth, err := service.GetPreAuth(reqData.PreAuthToken)
+	if err != nil {
+		return &core.Response{Code: core.LoginExpired, Message: err.Error()}
+	}
+
+	enabled, err := service.TwoFactorEnabled(preAuth.UserID)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	var recoveryCodes []string
+	if enabled {
+		err = service.VerifyTwoFactor(preAuth.UserID, reqData.Code)
+	} else {
+		recoveryCodes, err = service.EnableTwoFactor(preAuth.UserID, reqData.Code)
+	}
+	if err != nil {
+		service.FailPreAuth(reqData.PreAuthToken)
+		return &core.Response{Code: core.Deny, Message: err.Error()}
+	}
+	service.DeletePreAuthToken(reqData.PreAuthToken)
+
+	userData, err := model.User{ID: preAuth.UserID}.GetData()
 	if err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	token, namespaceList, response := user.signIn(gp, userData)
+	if response != nil {
+		return response
+	}
+	return &core.Response{Data: RespData{Token: token, NamespaceList: namespaceList, RecoveryCodes: recoveryCodes}}
+}
+
+// signIn check the authenticated user, cache the user information and set the login cookie
+func (user User) signIn(gp *core.Goploy, userData model.User) (string, model.Namespaces, *core.Response) {
+	if userData.State == model.Disable {
+		return "", nil, &core.Response{Code: core.AccountDisabled, Message: "Account is disabled"}
+	}
+
+	namespaceList, err := core.GetNamespace(userData.ID)
+	if err != nil || len(namespaceList) == 0 {
+		return "", nil, &core.Response{Code: core.Error, Message: "尚未分配空间，请联系管理员"}
+	}
+
+	sessionID, err := core.CreateSession(userData.ID, gp.Request)
+	if err != nil {
+		return "", nil, &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	token, err := userData.CreateToken(sessionID)
+	if err != nil {
+		return "", nil, &core.Response{Code: core.Error, Message: err.Error()}
+	}
 
 	model.User{ID: userData.ID, LastLoginTime: time.Now().Format("20060102150405")}.UpdateLastLoginTime()
 
@@ -57,13 +258,13 @@ func (user User) Login(gp *core.Goploy) *core.Response {
 
 	namespaceList, err = model.Namespace{UserID: userData.ID}.GetAllByUserID()
 	if err != nil {
-		return &core.Response{Code: core.Error, Message: err.Error()}
+		return "", nil, &core.Response{Code: core.Error, Message: err.Error()}
 	}
 	core.Cache.Set("namespace:"+strconv.Itoa(int(userData.ID)), &namespaceList, cache.DefaultExpiration)
 
 	cookie := http.Cookie{Name: core.LoginCookieName, Value: token, Path: "/", MaxAge: 86400, HttpOnly: true}
 	http.SetCookie(gp.ResponseWriter, &cookie)
-	return &core.Response{Data: RespData{Token: token, NamespaceList: namespaceList}}
+	return token, namespaceList, nil
 }
 
 // Info -
@@ -215,6 +416,18 @@ func (user User) Edit(gp *core.Goploy) *core.Response {
 			return &core.Response{Code: core.Error, Message: err.Error()}
 		}
 	}
+	core.DeleteUserCache(reqData.ID)
+
+	// the password is reset or the super manager is granted or revoked by the admin, the user must login again
+	if reqData.Password != "" || userInfo.SuperManager != reqData.SuperManager {
+		exceptSessionID := ""
+		if reqData.ID == gp.UserInfo.ID {
+			exceptSessionID = gp.SessionID
+		}
+		if err := core.RevokeSessions(reqData.ID, exceptSessionID); err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+	}
 
 	return &core.Response{}
 }
@@ -234,6 +447,10 @@ func (user User) Remove(gp *core.Goploy) *core.Response {
 	if err := (model.User{ID: reqData.ID}).RemoveRow(); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	core.DeleteUserCache(reqData.ID)
+	if err := core.RevokeSessions(reqData.ID, ""); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
 	return &core.Response{}
 }
 
@@ -259,5 +476,303 @@ func (user User) ChangePassword(gp *core.Goploy) *core.Response {
 	if err := (model.User{ID: gp.UserInfo.ID, Password: reqData.NewPassword}).UpdatePassword(); err != nil {
 		return &core.Response{Code: core.Error, Message: err.Error()}
 	}
+	// keep the current session and end the others
+	if err := core.RevokeSessions(gp.UserInfo.ID, gp.SessionID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// GetTokenList the api tokens of the user in the namespace
+func (user User) GetTokenList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		UserTokens model.UserTokens `json:"list"`
+	}
+	userTokens, err := model.UserToken{UserID: gp.UserInfo.ID, NamespaceID: gp.Namespace.ID}.GetListByUserID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{UserTokens: userTokens}}
+}
+
+// AddToken create an api token in the namespace, the token is only returned here
+func (user User) AddToken(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		Name       string  `json:"name" validate:"required,max=255"`
+		Scope      uint8   `json:"scope" validate:"min=1,max=2"`
+		ProjectIDs []int64 `json:"projectIds"`
+		ExpireTime string  `json:"expireTime"`
+	}
+	type RespData struct {
+		ID    int64  `json:"id"`
+		Token string `json:"token"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	projectIDs, err := service.CheckUserToken(gp.Namespace.ID, reqData.ProjectIDs, reqData.ExpireTime)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	token, tokenHash, err := core.GenerateAPIToken()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	id, err := model.UserToken{
+		UserID:      gp.UserInfo.ID,
+		NamespaceID: gp.Namespace.ID,
+		Name:        reqData.Name,
+		TokenHash:   tokenHash,
+		TokenPrefix: token[:len(core.APITokenPrefix)+6],
+		Scope:       reqData.Scope,
+		ProjectIDs:  projectIDs,
+		ExpireTime:  reqData.ExpireTime,
+	}.AddRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{ID: id, Token: token}}
+}
+
+// EditToken change the scope, the projects and the expire time of the api token
+func (user User) EditToken(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID         int64   `json:"id" validate:"gt=0"`
+		Name       string  `json:"name" validate:"required,max=255"`
+		Scope      uint8   `json:"scope" validate:"min=1,max=2"`
+		ProjectIDs []int64 `json:"projectIds"`
+		ExpireTime string  `json:"expireTime"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	projectIDs, err := service.CheckUserToken(gp.Namespace.ID, reqData.ProjectIDs, reqData.ExpireTime)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	err = model.UserToken{
+		ID:         reqData.ID,
+		UserID:     gp.UserInfo.ID,
+		Name:       reqData.Name,
+		Scope:      reqData.Scope,
+		ProjectIDs: projectIDs,
+		ExpireTime: reqData.ExpireTime,
+	}.EditRow()
+
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// RemoveToken revoke the api token
+func (user User) RemoveToken(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+
+	if err := (model.UserToken{ID: reqData.ID, UserID: gp.UserInfo.ID}).DeleteRow(); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// GetTwoFactor the two-factor state of the current user
+func (user User) GetTwoFactor(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		Enabled           bool  `json:"enabled"`
+		Required          bool  `json:"required"`
+		RecoveryCodeCount int64 `json:"recoveryCodeCount"`
+	}
+	enabled, err := service.TwoFactorEnabled(gp.UserInfo.ID)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	required, err := service.TwoFactorRequired(gp.UserInfo.ID)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	recoveryCodeCount, err := model.UserRecoveryCode{UserID: gp.UserInfo.ID}.CountUnused()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{Enabled: enabled, Required: required, RecoveryCodeCount: recoveryCodeCount}}
+}
+
+// EnrollTwoFactor generate the TOTP secret and the otpauth uri for the QR code
+func (user User) EnrollTwoFactor(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	secret, uri, err := service.EnrollTwoFactor(gp.UserInfo)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{Secret: secret, URI: uri}}
+}
+
+// EnableTwoFactor verify the first code of the enrolled secret, the recovery codes are only returned here
+func (user User) EnableTwoFactor(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		Code string `json:"code" validate:"len=6,numeric"`
+	}
+	type RespData struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	recoveryCodes, err := service.EnableTwoFactor(gp.UserInfo.ID, reqData.Code)
+	if err != nil {
+		return &core.Response{Code: core.Deny, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{RecoveryCodes: recoveryCodes}}
+}
+
+// DisableTwoFactor turn off the two-factor authentication which is not required by the namespace
+func (user User) DisableTwoFactor(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		Code string `json:"code" validate:"required"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if required, err := service.TwoFactorRequired(gp.UserInfo.ID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	} else if required {
+		return &core.Response{Code: core.Deny, Message: "Two-factor authentication is required by the namespace"}
+	}
+	if err := service.VerifyTwoFactor(gp.UserInfo.ID, reqData.Code); err != nil {
+		return &core.Response{Code: core.Deny, Message: err.Error()}
+	}
+	if err := service.ResetTwoFactor(gp.UserInfo.ID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// GenerateRecoveryCodes replace the recovery codes, the old codes are invalid
+func (user User) GenerateRecoveryCodes(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		Code string `json:"code" validate:"len=6,numeric"`
+	}
+	type RespData struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if err := service.VerifyTwoFactor(gp.UserInfo.ID, reqData.Code); err != nil {
+		return &core.Response{Code: core.Deny, Message: err.Error()}
+	}
+	recoveryCodes, err := service.GenerateRecoveryCodes(gp.UserInfo.ID)
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{Data: RespData{RecoveryCodes: recoveryCodes}}
+}
+
+// ResetTwoFactor remove the two-factor authentication of the user who lost the device and the recovery codes
+func (user User) ResetTwoFactor(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		UserID int64 `json:"userId" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	// the admin of the namespace only resets the members of the namespace, the super manager resets everyone
+	if gp.UserInfo.SuperManager != model.SuperManager {
+		userInfo, err := model.User{ID: reqData.UserID}.GetData()
+		if err != nil {
+			return &core.Response{Code: core.Error, Message: err.Error()}
+		}
+		if userInfo.SuperManager == model.SuperManager {
+			return &core.Response{Code: core.Deny, Message: "Only the super manager can reset the two-factor authentication of the super manager"}
+		}
+		if _, err := (model.NamespaceUser{NamespaceID: gp.Namespace.ID, UserID: reqData.UserID}).GetDataByUserID(); err != nil {
+			return &core.Response{Code: core.Deny, Message: "User is not in the namespace"}
+		}
+	}
+	if err := service.ResetTwoFactor(reqData.UserID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	core.Log(core.WARNING, gp.UserInfo.Name+" reset the two-factor authentication of user "+strconv.FormatInt(reqData.UserID, 10))
+
+	// the sessions passed the old two-factor authentication, the user must login again
+	exceptSessionID := ""
+	if reqData.UserID == gp.UserInfo.ID {
+		exceptSessionID = gp.SessionID
+	}
+	core.DeleteUserCache(reqData.UserID)
+	if err := core.RevokeSessions(reqData.UserID, exceptSessionID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// Logout end the current session and clear the login cookie
+func (user User) Logout(gp *core.Goploy) *core.Response {
+	if err := core.EndSession(gp.SessionID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	cookie := http.Cookie{Name: core.LoginCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true}
+	http.SetCookie(gp.ResponseWriter, &cookie)
+	return &core.Response{}
+}
+
+// GetSessionList the login sessions of the current user
+func (user User) GetSessionList(gp *core.Goploy) *core.Response {
+	type RespData struct {
+		UserSessions model.UserSessions `json:"list"`
+	}
+	userSessions, err := model.UserSession{UserID: gp.UserInfo.ID}.GetListByUserID()
+	if err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	for i := range userSessions {
+		userSessions[i].Current = userSessions[i].SessionID == gp.SessionID
+	}
+	return &core.Response{Data: RespData{UserSessions: userSessions}}
+}
+
+// RemoveSession end one session of the current user, e.g. the browser on a lost device
+func (user User) RemoveSession(gp *core.Goploy) *core.Response {
+	type ReqData struct {
+		ID int64 `json:"id" validate:"gt=0"`
+	}
+	var reqData ReqData
+	if err := verify(gp.Body, &reqData); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	if err := core.RevokeSession(gp.UserInfo.ID, reqData.ID); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	return &core.Response{}
+}
+
+// RemoveAllSessions end all sessions of the current user including the current one
+func (user User) RemoveAllSessions(gp *core.Goploy) *core.Response {
+	if err := core.RevokeSessions(gp.UserInfo.ID, ""); err != nil {
+		return &core.Response{Code: core.Error, Message: err.Error()}
+	}
+	cookie := http.Cookie{Name: core.LoginCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true}
+	http.SetCookie(gp.ResponseWriter, &cookie)
 	return &core.Response{}
 }
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zhenorzz/goploy/model"
+)
+
+// APITokenPrefix the prefix of the personal api token
+const APITokenPrefix = "gpt_"
+
+// apiTokenPublishRoutes the non-GET routes the publish scope can call,
+// the approval and the release management are left to the users
+var apiTokenPublishRoutes = map[string]struct{}{
+	"/deploy/publish":       {},
+	"/deploy/cancel":        {},
+	"/deploy/canaryConfirm": {},
+}
+
+// GenerateAPIToken return the token shown to the user once and its hash to store
+func GenerateAPIToken() (string, string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := APITokenPrefix + hex.EncodeToString(b)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken -
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkAPIToken authenticate the Authorization: Bearer header,
+// the read scope only allows GET, the publish scope allows the publish routes as well
+func checkAPIToken(r *http.Request, token string) (model.User, model.Namespace, model.UserToken, *Response) {
+	var namespace model.Namespace
+	userToken, err := model.UserToken{TokenHash: HashAPIToken(token)}.GetDataByHash()
+	if err == sql.ErrNoRows {
+		return model.User{}, namespace, userToken, &Response{Code: LoginExpired, Message: "Invalid api token"}
+	} else if err != nil {
+		return model.User{}, namespace, userToken, &Response{Code: Deny, Message: "Get api token error"}
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/user/token") {
+		return model.User{}, namespace, userToken, &Response{Code: Deny, Message: "Api token can not manage the tokens"}
+	}
+	if r.Method != GET {
+		if _, ok := apiTokenPublishRoutes[r.URL.Path]; !ok || userToken.Scope != model.UserTokenPublish {
+			return model.User{}, namespace, userToken, &Response{Code: Deny, Message: "Api token scope no permission"}
+		}
+	}
+
+	// the user may have left the namespace after the token was created
+	namespaceList, err := GetNamespace(userToken.UserID)
+	if err != nil {
+		return model.User{}, namespace, userToken, &Response{Code: Deny, Message: "Get namespace list error"}
+	}
+	for _, ns := range namespaceList {
+		if ns.ID == userToken.NamespaceID {
+			namespace = ns
+		}
+	}
+	if namespace == (model.Namespace{}) {
+		return model.User{}, namespace, userToken, &Response{Code: Deny, Message: "Namespace no permission"}
+	}
+
+	userInfo, err := GetUserInfo(userToken.UserID)
+	if err != nil {
+		return model.User{}, namespace, userToken, &Response{Code: Deny, Message: "Get user information error"}
+	}
+	if userInfo.State == model.Disable {
+		return model.User{}, namespace, userToken, &Response{Code: AccountDisabled, Message: "Account is disabled"}
+	}
+
+	userToken.LastUsedIP = clientIP(r)
+	go func() {
+		if err := userToken.Touch(); err != nil {
+			Log(ERROR, "api token "+userToken.TokenPrefix+" touch fail, "+err.Error())
+		}
+	}()
+	return userInfo, namespace, userToken, nil
+}
+
+// the meaning of the id parameter in the api token routes
+const (
+	apiTokenIDProject = iota + 1
+	apiTokenIDQueue
+	apiTokenIDConfigTemplate
+)
+
+// apiTokenIDRoutes the routes keyed by the bare id, the project of the id is checked as well
+var apiTokenIDRoutes = map[string]int{
+	"/deploy/getCommitList":          apiTokenIDProject,
+	"/deploy/getReleaseList":         apiTokenIDProject,
+	"/project/getBindServerList":     apiTokenIDProject,
+	"/project/getBindUserList":       apiTokenIDProject,
+	"/project/getTaskList":           apiTokenIDProject,
+	"/project/getConfigTemplateList": apiTokenIDProject,
+	"/project/getHealthCheckList":    apiTokenIDProject,
+	"/project/getWebhookInfo":        apiTokenIDProject,
+	"/project/previewConfigTemplate": apiTokenIDConfigTemplate,
+	"/deploy/queue/approvals":        apiTokenIDQueue,
+	"/deploy/queue/cancel":           apiTokenIDQueue,
+	"/deploy/approve":                apiTokenIDQueue,
+	"/deploy/reject":                 apiTokenIDQueue,
+}
+
+// checkAPITokenProject deny the project which is not in the allow-list of the token,
+// the token restricted to some projects can only call the route whose project is known
+func checkAPITokenProject(userToken model.UserToken, path string, urlQuery url.Values, body []byte) *Response {
+	var reqData struct {
+		ID        int64 `json:"id"`
+		ProjectID int64 `json:"projectId"`
+	}
+	_ = json.Unmarshal(body, &reqData)
+
+	var projectIDs []int64
+	for _, value := range []string{urlQuery.Get("projectId"), urlQuery.Get("project_id")} {
+		if len(value) == 0 {
+			continue
+		}
+		projectID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return &Response{Code: Deny, Message: "Api token project no permission"}
+		}
+		projectIDs = append(projectIDs, projectID)
+	}
+	if reqData.ProjectID != 0 {
+		projectIDs = append(projectIDs, reqData.ProjectID)
+	}
+
+	if idType, ok := apiTokenIDRoutes[path]; ok {
+		id := reqData.ID
+		if value := urlQuery.Get("id"); len(value) != 0 {
+			var err error
+			if id, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return &Response{Code: Deny, Message: "Api token project no permission"}
+			}
+		}
+		switch idType {
+		case apiTokenIDProject:
+			projectIDs = append(projectIDs, id)
+		case apiTokenIDQueue:
+			deployQueue, err := model.DeployQueue{ID: id}.GetData()
+			if err != nil {
+				return &Response{Code: Deny, Message: "Api token project no permission"}
+			}
+			projectIDs = append(projectIDs, deployQueue.ProjectID)
+		case apiTokenIDConfigTemplate:
+			configTemplate, err := model.ProjectConfigTemplate{ID: id}.GetData()
+			if err != nil {
+				return &Response{Code: Deny, Message: "Api token project no permission"}
+			}
+			projectIDs = append(projectIDs, configTemplate.ProjectID)
+		}
+	}
+
+	if len(projectIDs) == 0 && len(userToken.ProjectIDs) != 0 {
+		return &Response{Code: Deny, Message: "Api token is restricted to the projects, the route has no project"}
+	}
+	for _, projectID := range projectIDs {
+		if !userToken.AllowProject(projectID) {
+			return &Response{Code: Deny, Message: "Api token project no permission"}
+		}
+	}
+	return nil
+}
+
+// clientIP the first address of X-Forwarded-For, or the remote address
+func clientIP(r *http.Request) string {
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); len(forwardedFor) != 0 {
+		return strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/zhenorzz/goploy/model"
@@ -45,3 +46,26 @@ func GetNamespace(userID int64) (model.Namespaces, error) {
 	}
 	return namespaceList, nil
 }
+
+// DeleteUserCache drop the cached user information and namespace list,
+// the next request of the user reloads them
+func DeleteUserCache(userID int64) {
+	Cache.Delete("userInfo:" + strconv.Itoa(int(userID)))
+	Cache.Delete("namespace:" + strconv.Itoa(int(userID)))
+}
+
+// DeleteNamespaceCache drop the cached namespace list of the users,
+// no user id means the change affects everyone, e.g. the namespace is renamed
+func DeleteNamespaceCache(userIDs ...int64) {
+	if len(userIDs) == 0 {
+		for key := range Cache.Items() {
+			if strings.HasPrefix(key, "namespace:") {
+				Cache.Delete(key)
+			}
+		}
+		return
+	}
+	for _, userID := range userIDs {
+		Cache.Delete("namespace:" + strconv.Itoa(int(userID)))
+	}
+}
@@ -1,6 +1,11 @@
 package core
 
-import "github.com/zhenorzz/goploy/utils"
+import (
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/zhenorzz/goploy/utils"
+)
 
 // GlobalPath current path end with /
 var GlobalPath = utils.GetCurrentPath()
@@ -11,6 +16,15 @@ var RepositoryPath = GlobalPath + "repository/"
 // PackagePath template path end with /
 var PackagePath = RepositoryPath + "template-package/"
 
+// Hostname the host of the running goploy
+var Hostname, _ = os.Hostname()
+
+// ArtifactPath artifact store path end with /
+var ArtifactPath = GlobalPath + "artifact/"
+
+// InstanceID identify the running goploy, it changes after restart
+var InstanceID = Hostname + "-" + uuid.New().String()
+
 //role
 const (
 	RoleAdmin        = "admin"
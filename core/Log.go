@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/zhenorzz/goploy/utils"
 )
 
 // LogLevel is log level
@@ -19,7 +21,7 @@ const (
 	ERROR   LogLevel = "ERROR: "
 )
 
-// Log information to file with logged day
+// Log information to file with logged day, the secrets are masked
 func Log(lv LogLevel, content string) {
 	logPath, err := filepath.Abs(os.Getenv("LOG_PATH"))
 	if err != nil {
@@ -37,5 +39,5 @@ func Log(lv LogLevel, content string) {
 		fmt.Println(err.Error())
 	}
 	logger := log.New(logFile, string(lv), log.LstdFlags|log.Llongfile)
-	logger.Output(2, content)
+	logger.Output(2, utils.Mask(content))
 }
@@ -30,6 +30,7 @@ const (
 type Goploy struct {
 	UserInfo       model.User
 	Namespace      model.Namespace
+	SessionID      string
 	Request        *http.Request
 	ResponseWriter http.ResponseWriter
 	URLQuery       url.Values
@@ -134,7 +135,16 @@ func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (rt *Router) checkLogin(w http.ResponseWriter, r *http.Request) (*Goploy, *Response) {
 	var userInfo model.User
 	var namespace model.Namespace
-	if _, ok := rt.whiteList[r.URL.Path]; !ok {
+	var userToken model.UserToken
+	var sessionID string
+	_, whiteListed := rt.whiteList[r.URL.Path]
+	if authorization := r.Header.Get("Authorization"); !whiteListed && strings.HasPrefix(authorization, "Bearer ") {
+		// the personal api token of the ci pipelines and the scripts
+		var response *Response
+		if userInfo, namespace, userToken, response = checkAPIToken(r, strings.TrimPrefix(authorization, "Bearer ")); response != nil {
+			return nil, response
+		}
+	} else if !whiteListed {
 		// check token
 		goployTokenCookie, err := r.Cookie(LoginCookieName)
 		if err != nil {
@@ -150,6 +160,12 @@ func (rt *Router) checkLogin(w http.ResponseWriter, r *http.Request) (*Goploy, *
 			return nil, &Response{Code: LoginExpired, Message: "Login expired"}
 		}
 
+		// the session may be revoked before the jwt expires
+		sessionID, _ = claims["sid"].(string)
+		if err := checkSession(r, sessionID, int64(claims["id"].(float64))); err != nil {
+			return nil, &Response{Code: LoginExpired, Message: err.Error()}
+		}
+
 		namespaceCookie, err := r.Cookie(NamespaceCookieName)
 		if err != nil {
 			return nil, &Response{Code: IllegalRequest, Message: "Illegal namespace"}
@@ -180,7 +196,11 @@ func (rt *Router) checkLogin(w http.ResponseWriter, r *http.Request) (*Goploy, *
 			return nil, &Response{Code: Deny, Message: "Get user information error"}
 		}
 
-		goployTokenStr, err := model.User{ID: int64(claims["id"].(float64)), Name: claims["name"].(string)}.CreateToken()
+		if userInfo.State == model.Disable {
+			return nil, &Response{Code: AccountDisabled, Message: "Account is disabled"}
+		}
+
+		goployTokenStr, err := model.User{ID: int64(claims["id"].(float64)), Name: claims["name"].(string)}.CreateToken(sessionID)
 		if err == nil {
 			// update jwt time
 			cookie := http.Cookie{Name: LoginCookieName, Value: goployTokenStr, Path: "/", MaxAge: 86400, HttpOnly: true}
@@ -194,9 +214,15 @@ func (rt *Router) checkLogin(w http.ResponseWriter, r *http.Request) (*Goploy, *
 	if hasContentType(r, "application/json") {
 		body, _ = ioutil.ReadAll(r.Body)
 	}
+	if userToken.ID != 0 {
+		if response := checkAPITokenProject(userToken, r.URL.Path, r.URL.Query(), body); response != nil {
+			return nil, response
+		}
+	}
 	gp := &Goploy{
 		UserInfo:       userInfo,
 		Namespace:      namespace,
+		SessionID:      sessionID,
 		Request:        r,
 		ResponseWriter: w,
 		URLQuery:       r.URL.Query(),
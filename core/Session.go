@@ -0,0 +1,117 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/zhenorzz/goploy/model"
+)
+
+const (
+	// sessionExpire the session ends after being inactive for the time
+	sessionExpire = 24 * time.Hour
+	// sessionTouchInterval avoid writing the last active time on every request
+	sessionTouchInterval = time.Minute
+)
+
+// session the cached user session, the cache is dropped when the session is revoked
+type session struct {
+	UserID    int64
+	TouchTime time.Time
+}
+
+// CreateSession save the login session of the request, return the session id for the jwt
+func CreateSession(userID int64, r *http.Request) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	sessionID := hex.EncodeToString(b)
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	_, err := model.UserSession{
+		UserID:     userID,
+		SessionID:  sessionID,
+		IP:         clientIP(r),
+		UserAgent:  userAgent,
+		ExpireTime: time.Now().Add(sessionExpire).Format("2006-01-02 15:04:05"),
+	}.AddRow()
+	if err != nil {
+		return "", err
+	}
+	Cache.Set("session:"+sessionID, &session{UserID: userID, TouchTime: time.Now()}, sessionExpire)
+	return sessionID, nil
+}
+
+// checkSession check the session of the jwt is not revoked or expired, and extend it
+func checkSession(r *http.Request, sessionID string, userID int64) error {
+	if sessionID == "" {
+		return errors.New("Login expired")
+	}
+	var s *session
+	if x, found := Cache.Get("session:" + sessionID); found {
+		s = x.(*session)
+	} else {
+		userSession, err := model.UserSession{SessionID: sessionID}.GetDataBySessionID()
+		if err != nil {
+			return errors.New("Login expired")
+		}
+		s = &session{UserID: userSession.UserID}
+	}
+	if s.UserID != userID {
+		return errors.New("Login expired")
+	}
+
+	if time.Since(s.TouchTime) >= sessionTouchInterval {
+		s.TouchTime = time.Now()
+		err := model.UserSession{
+			SessionID:  sessionID,
+			IP:         clientIP(r),
+			ExpireTime: s.TouchTime.Add(sessionExpire).Format("2006-01-02 15:04:05"),
+		}.Touch()
+		if err != nil {
+			Log(ERROR, "session touch fail, "+err.Error())
+		}
+		Cache.Set("session:"+sessionID, s, sessionExpire)
+	}
+	return nil
+}
+
+// EndSession end the session of the session id
+func EndSession(sessionID string) error {
+	Cache.Delete("session:" + sessionID)
+	return model.UserSession{SessionID: sessionID}.DeleteBySessionID()
+}
+
+// RevokeSession end one session of the user
+func RevokeSession(userID, id int64) error {
+	userSessions, err := model.UserSession{UserID: userID}.GetListByUserID()
+	if err != nil {
+		return err
+	}
+	for _, userSession := range userSessions {
+		if userSession.ID == id {
+			Cache.Delete("session:" + userSession.SessionID)
+		}
+	}
+	return model.UserSession{ID: id, UserID: userID}.DeleteRow()
+}
+
+// RevokeSessions end all sessions of the user except the session id, the empty session id ends all of them
+func RevokeSessions(userID int64, exceptSessionID string) error {
+	userSessions, err := model.UserSession{UserID: userID}.GetListByUserID()
+	if err != nil {
+		return err
+	}
+	for _, userSession := range userSessions {
+		if userSession.SessionID != exceptSessionID {
+			Cache.Delete("session:" + userSession.SessionID)
+		}
+	}
+	return model.UserSession{UserID: userID, SessionID: exceptSessionID}.DeleteByUserID()
+}
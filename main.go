@@ -8,6 +8,7 @@ import (
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
 	"github.com/zhenorzz/goploy/route"
+	"github.com/zhenorzz/goploy/service"
 	"github.com/zhenorzz/goploy/task"
 	"github.com/zhenorzz/goploy/utils"
 	"github.com/zhenorzz/goploy/ws"
@@ -35,6 +36,8 @@ func main() {
 	model.Init()
 	ws.Init()
 	route.Init()
+	// fail the deploys interrupted by the last shutdown before the queue is dispatched
+	service.ReconcileDeploys()
 	task.Init()
 	err := http.ListenAndServe(":"+os.Getenv("PORT"), nil)
 	if err != nil {
@@ -1,12 +1,27 @@
 package middleware
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
 )
 
+// webhookReplayWindow the gitee request whose timestamp is out of the window is rejected
+const webhookReplayWindow = 5 * time.Minute
+
 // HasPublishAuth check the user has publish auth
 func HasPublishAuth(gp *core.Goploy) error {
 	type ReqData struct {
@@ -28,11 +43,85 @@ func HasPublishAuth(gp *core.Goploy) error {
 func FilterEvent(gp *core.Goploy) error {
 	if XGitHubEvent := gp.Request.Header.Get("X-GitHub-Event"); len(XGitHubEvent) != 0 && XGitHubEvent == "push" {
 		return nil
-	} else if XGitLabEvent := gp.Request.Header.Get("X-Gitlab-Event"); len(XGitLabEvent) != 0 && XGitLabEvent == "Push Hook" {
+	} else if XGitLabEvent := gp.Request.Header.Get("X-Gitlab-Event"); len(XGitLabEvent) != 0 && (XGitLabEvent == "Push Hook" || XGitLabEvent == "Tag Push Hook") {
 		return nil
-	} else if XGiteeEvent := gp.Request.Header.Get("X-Gitee-Event"); len(XGiteeEvent) != 0 && XGiteeEvent == "Push Hook" {
+	} else if XGiteeEvent := gp.Request.Header.Get("X-Gitee-Event"); len(XGiteeEvent) != 0 && (XGiteeEvent == "Push Hook" || XGiteeEvent == "Tag Push Hook") {
 		return nil
 	} else {
-		return errors.New("only receive push event")
+		return errors.New("only receive push and tag push event")
+	}
+}
+
+// VerifyWebhook check the signature of the webhook with the project webhook secret,
+// the unsigned request and the replayed request are rejected
+func VerifyWebhook(gp *core.Goploy) error {
+	projectID, err := strconv.ParseInt(gp.URLQuery.Get("project_id"), 10, 64)
+	if err != nil {
+		return err
+	}
+	secret, err := model.Project{ID: projectID}.GetWebhookSecret()
+	if err != nil {
+		return errors.New("project not found")
 	}
+	if len(secret) == 0 {
+		return errors.New("webhook secret is not set, generate it in the project setting")
+	}
+	return verifyWebhookSignature(gp.Request.Header, gp.Body, secret)
+}
+
+// verifyWebhookSignature check the signature of github, gitlab or gitee
+func verifyWebhookSignature(header http.Header, body []byte, secret string) error {
+	if len(header.Get("X-GitHub-Event")) != 0 {
+		// sha256=hex(hmac_sha256(secret, body))
+		signature := header.Get("X-Hub-Signature-256")
+		if !strings.HasPrefix(signature, "sha256=") {
+			return errors.New("missing X-Hub-Signature-256")
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if !hmac.Equal([]byte(strings.TrimPrefix(signature, "sha256=")), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			return errors.New("invalid signature")
+		}
+		return checkWebhookReplay("github:" + header.Get("X-GitHub-Delivery"))
+	} else if len(header.Get("X-Gitlab-Event")) != 0 {
+		// gitlab sends the secret token as it is
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return errors.New("invalid X-Gitlab-Token")
+		}
+		// the token is static, only the event uuid tells the replayed delivery
+		return checkWebhookReplay("gitlab:" + header.Get("X-Gitlab-Event-UUID"))
+	} else if len(header.Get("X-Gitee-Event")) != 0 {
+		// base64(hmac_sha256(secret, timestamp + "\n" + secret))
+		timestamp := header.Get("X-Gitee-Timestamp")
+		milliseconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return errors.New("missing X-Gitee-Timestamp")
+		}
+		if signedAt := time.Unix(0, milliseconds*int64(time.Millisecond)); time.Since(signedAt) > webhookReplayWindow || time.Until(signedAt) > webhookReplayWindow {
+			return errors.New("X-Gitee-Timestamp is out of time")
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "\n" + secret))
+		token := header.Get("X-Gitee-Token")
+		// the sign may be url encoded
+		if unescaped, err := url.PathUnescape(token); err == nil {
+			token = unescaped
+		}
+		if !hmac.Equal([]byte(token), []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))) {
+			return errors.New("invalid X-Gitee-Token, use the signature key instead of the password")
+		}
+		return checkWebhookReplay("gitee:" + token)
+	}
+	return errors.New("unsigned webhook")
+}
+
+// checkWebhookReplay reject the delivery which has been received
+func checkWebhookReplay(delivery string) error {
+	if strings.HasSuffix(delivery, ":") {
+		return errors.New("missing delivery id")
+	}
+	if err := core.Cache.Add("webhook:"+delivery, struct{}{}, cache.DefaultExpiration); err != nil {
+		return errors.New("replayed webhook")
+	}
+	return nil
 }
@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+func githubSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func giteeToken(secret string, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func giteeTimestamp(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	now := giteeTimestamp(time.Now())
+	earlier := giteeTimestamp(time.Now().Add(-time.Second))
+	expired := giteeTimestamp(time.Now().Add(-webhookReplayWindow - time.Minute))
+	future := giteeTimestamp(time.Now().Add(webhookReplayWindow + time.Minute))
+	tests := []struct {
+		name    string
+		header  map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "unsigned",
+			header:  map[string]string{},
+			wantErr: true,
+		},
+		{
+			name: "github",
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-GitHub-Delivery":   "github-1",
+				"X-Hub-Signature-256": githubSignature(testWebhookSecret, body),
+			},
+		},
+		{
+			name: "github wrong secret",
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-GitHub-Delivery":   "github-2",
+				"X-Hub-Signature-256": githubSignature("other", body),
+			},
+			wantErr: true,
+		},
+		{
+			name: "github sha1 signature",
+			header: map[string]string{
+				"X-GitHub-Event":    "push",
+				"X-GitHub-Delivery": "github-3",
+				"X-Hub-Signature":   "sha1=0123456789abcdef",
+			},
+			wantErr: true,
+		},
+		{
+			name: "github missing delivery",
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-Hub-Signature-256": githubSignature(testWebhookSecret, body),
+			},
+			wantErr: true,
+		},
+		{
+			name: "gitlab",
+			header: map[string]string{
+				"X-Gitlab-Event":      "Push Hook",
+				"X-Gitlab-Token":      testWebhookSecret,
+				"X-Gitlab-Event-UUID": "gitlab-1",
+			},
+		},
+		{
+			name: "gitlab without event uuid",
+			header: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": testWebhookSecret,
+			},
+			wantErr: true,
+		},
+		{
+			name: "gitlab wrong token",
+			header: map[string]string{
+				"X-Gitlab-Event":      "Push Hook",
+				"X-Gitlab-Token":      "other",
+				"X-Gitlab-Event-UUID": "gitlab-2",
+			},
+			wantErr: true,
+		},
+		{
+			name: "gitee",
+			header: map[string]string{
+				"X-Gitee-Event":     "Push Hook",
+				"X-Gitee-Timestamp": now,
+				"X-Gitee-Token":     giteeToken(testWebhookSecret, now),
+			},
+		},
+		{
+			name: "gitee url encoded token",
+			header: map[string]string{
+				"X-Gitee-Event":     "Push Hook",
+				"X-Gitee-Timestamp": earlier,
+				"X-Gitee-Token":     url.QueryEscape(giteeToken(testWebhookSecret, earlier)),
+			},
+		},
+		{
+			name: "gitee password instead of the signature",
+			header: map[string]string{
+				"X-Gitee-Event":     "Push Hook",
+				"X-Gitee-Timestamp": now,
+				"X-Gitee-Token":     testWebhookSecret,
+			},
+			wantErr: true,
+		},
+		{
+			name: "gitee missing timestamp",
+			header: map[string]string{
+				"X-Gitee-Event": "Push Hook",
+				"X-Gitee-Token": giteeToken(testWebhookSecret, ""),
+			},
+			wantErr: true,
+		},
+		{
+			name: "gitee expired timestamp",
+			header: map[string]string{
+				"X-Gitee-Event":     "Push Hook",
+				"X-Gitee-Timestamp": expired,
+				"X-Gitee-Token":     giteeToken(testWebhookSecret, expired),
+			},
+			wantErr: true,
+		},
+		{
+			name: "gitee future timestamp",
+			header: map[string]string{
+				"X-Gitee-Event":     "Push Hook",
+				"X-Gitee-Timestamp": future,
+				"X-Gitee-Token":     giteeToken(testWebhookSecret, future),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for key, value := range tt.header {
+			header.Set(key, value)
+		}
+		if err := verifyWebhookSignature(header, body, testWebhookSecret); (err != nil) != tt.wantErr {
+			t.Errorf("%s: verifyWebhookSignature() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestVerifyWebhookSignatureReplay(t *testing.T) {
+	body := []byte(`{"ref":"refs/tags/v1.0.0"}`)
+	now := giteeTimestamp(time.Now().Add(-2 * time.Second))
+	tests := []struct {
+		name   string
+		header map[string]string
+	}{
+		{
+			name: "github",
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-GitHub-Delivery":   "github-replay",
+				"X-Hub-Signature-256": githubSignature(testWebhookSecret, body),
+			},
+		},
+		{
+			name: "gitlab",
+			header: map[string]string{
+				"X-Gitlab-Event":      "Tag Push Hook",
+				"X-Gitlab-Token":      testWebhookSecret,
+				"X-Gitlab-Event-UUID": "gitlab-replay",
+			},
+		},
+		{
+			name: "gitee",
+			header: map[string]string{
+				"X-Gitee-Event":     "Tag Push Hook",
+				"X-Gitee-Timestamp": now,
+				"X-Gitee-Token":     giteeToken(testWebhookSecret, now),
+			},
+		},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for key, value := range tt.header {
+			header.Set(key, value)
+		}
+		if err := verifyWebhookSignature(header, body, testWebhookSecret); err != nil {
+			t.Errorf("%s: first delivery error = %v", tt.name, err)
+		}
+		if err := verifyWebhookSignature(header, body, testWebhookSecret); err == nil {
+			t.Errorf("%s: replayed delivery is accepted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const deployApprovalTable = "`deploy_approval`"
+
+// deploy approval decision
+const (
+	ApprovalApprove = iota + 1
+	ApprovalReject
+)
+
+// DeployApproval the decision of one approver on the deploy queue
+type DeployApproval struct {
+	ID         int64  `json:"id"`
+	QueueID    int64  `json:"queueId"`
+	UserID     int64  `json:"userId"`
+	UserName   string `json:"userName"`
+	Decision   uint8  `json:"decision"`
+	Comment    string `json:"comment"`
+	InsertTime string `json:"insertTime"`
+}
+
+// DeployApprovals -
+type DeployApprovals []DeployApproval
+
+// GetListByQueueID -
+func (da DeployApproval) GetListByQueueID() (DeployApprovals, error) {
+	rows, err := sq.
+		Select("id, queue_id, user_id, user_name, decision, comment, insert_time").
+		From(deployApprovalTable).
+		Where(sq.Eq{"queue_id": da.QueueID}).
+		OrderBy("id ASC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	deployApprovals := DeployApprovals{}
+	for rows.Next() {
+		var deployApproval DeployApproval
+		if err := rows.Scan(
+			&deployApproval.ID,
+			&deployApproval.QueueID,
+			&deployApproval.UserID,
+			&deployApproval.UserName,
+			&deployApproval.Decision,
+			&deployApproval.Comment,
+			&deployApproval.InsertTime,
+		); err != nil {
+			return nil, err
+		}
+		deployApprovals = append(deployApprovals, deployApproval)
+	}
+	return deployApprovals, nil
+}
+
+// AddRow return false when the user has decided on the queue
+func (da DeployApproval) AddRow() (bool, error) {
+	result, err := sq.
+		Insert(deployApprovalTable).
+		Options("IGNORE").
+		Columns("queue_id", "user_id", "user_name", "decision", "comment").
+		Values(da.QueueID, da.UserID, da.UserName, da.Decision, da.Comment).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// CountApproved -
+func (da DeployApproval) CountApproved() (uint16, error) {
+	var count uint16
+	err := sq.
+		Select("COUNT(*)").
+		From(deployApprovalTable).
+		Where(sq.Eq{"queue_id": da.QueueID, "decision": ApprovalApprove}).
+		RunWith(DB).
+		QueryRow().
+		Scan(&count)
+	return count, err
+}
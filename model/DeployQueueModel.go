@@ -0,0 +1,327 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const deployQueueTable = "`deploy_queue`"
+
+// deploy queue source
+const (
+	QueueSourceManual = iota + 1
+	QueueSourceWebhook
+	QueueSourceTask
+)
+
+// deploy queue state
+const (
+	QueueWaiting = iota
+	QueueDispatched
+	QueueCanceled
+	QueueApproving
+	QueueRejected
+	QueueExpired
+)
+
+// DeployQueue -
+type DeployQueue struct {
+	ID          int64  `json:"id"`
+	ProjectID   int64  `json:"projectId"`
+	ProjectName string `json:"projectName"`
+	CommitID    string `json:"commitId"`
+	Branch      string `json:"branch"`
+	// Ref is the branch, the tag or the commit to deploy, empty means the project branch
+	Ref           string `json:"ref"`
+	Source        uint8  `json:"source"`
+	State         uint8  `json:"state"`
+	Token         string `json:"token"`
+	PublisherID   int64  `json:"publisherId"`
+	PublisherName string `json:"publisherName"`
+	ApprovalCount uint16 `json:"approvalCount"`
+	ApprovalRole  string `json:"approvalRole"`
+	// Summary is the commit and the changed files shown to the approvers
+	Summary    string `json:"summary"`
+	ExpireTime string `json:"expireTime"`
+	// FreezeOverride the admin deploys it during the freeze
+	FreezeOverride uint8  `json:"freezeOverride"`
+	InsertTime     string `json:"insertTime"`
+	UpdateTime     string `json:"updateTime"`
+}
+
+// DeployQueues -
+type DeployQueues []DeployQueue
+
+// AddRow return LastInsertId
+func (dq DeployQueue) AddRow() (int64, error) {
+	builder := sq.
+		Insert(deployQueueTable).
+		Columns("project_id", "project_name", "commit_id", "branch", "ref", "source", "state", "publisher_id", "publisher_name", "approval_count", "approval_role", "summary", "freeze_override")
+	if dq.State == QueueApproving {
+		builder = builder.
+			Columns("expire_time").
+			Values(dq.ProjectID, dq.ProjectName, dq.CommitID, dq.Branch, dq.Ref, dq.Source, dq.State, dq.PublisherID, dq.PublisherName, dq.ApprovalCount, dq.ApprovalRole, dq.Summary, dq.FreezeOverride, dq.ExpireTime)
+	} else {
+		builder = builder.
+			Values(dq.ProjectID, dq.ProjectName, dq.CommitID, dq.Branch, dq.Ref, dq.Source, dq.State, dq.PublisherID, dq.PublisherName, dq.ApprovalCount, dq.ApprovalRole, dq.Summary, dq.FreezeOverride)
+	}
+	result, err := builder.RunWith(DB).Exec()
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, err
+}
+
+// GetWaitingList the waiting queue, filter by project id when it is set
+func (dq DeployQueue) GetWaitingList() (DeployQueues, error) {
+	builder := sq.
+		Select("id, project_id, project_name, commit_id, branch, ref, source, state, publisher_id, publisher_name, freeze_override, insert_time, update_time").
+		From(deployQueueTable).
+		Where(sq.Eq{"state": QueueWaiting})
+	if dq.ProjectID != 0 {
+		builder = builder.Where(sq.Eq{"project_id": dq.ProjectID})
+	}
+	rows, err := builder.
+		OrderBy("id ASC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	deployQueues := DeployQueues{}
+	for rows.Next() {
+		var deployQueue DeployQueue
+		if err := rows.Scan(
+			&deployQueue.ID,
+			&deployQueue.ProjectID,
+			&deployQueue.ProjectName,
+			&deployQueue.CommitID,
+			&deployQueue.Branch,
+			&deployQueue.Ref,
+			&deployQueue.Source,
+			&deployQueue.State,
+			&deployQueue.PublisherID,
+			&deployQueue.PublisherName,
+			&deployQueue.FreezeOverride,
+			&deployQueue.InsertTime,
+			&deployQueue.UpdateTime,
+		); err != nil {
+			return nil, err
+		}
+		deployQueues = append(deployQueues, deployQueue)
+	}
+	return deployQueues, nil
+}
+
+// GetWaitingWebhook the waiting webhook queue of the same project and ref
+func (dq DeployQueue) GetWaitingWebhook() (DeployQueue, error) {
+	var deployQueue DeployQueue
+	err := sq.
+		Select("id, project_id, project_name, commit_id, branch, ref, source, state, publisher_id, publisher_name").
+		From(deployQueueTable).
+		Where(sq.Eq{
+			"project_id": dq.ProjectID,
+			"branch":     dq.Branch,
+			"ref":        dq.Ref,
+			"source":     QueueSourceWebhook,
+			"state":      QueueWaiting,
+		}).
+		OrderBy("id DESC").
+		Limit(1).
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&deployQueue.ID,
+			&deployQueue.ProjectID,
+			&deployQueue.ProjectName,
+			&deployQueue.CommitID,
+			&deployQueue.Branch,
+			&deployQueue.Ref,
+			&deployQueue.Source,
+			&deployQueue.State,
+			&deployQueue.PublisherID,
+			&deployQueue.PublisherName,
+		)
+	return deployQueue, err
+}
+
+// GetDataByToken the dispatched queue of the deploy token
+func (dq DeployQueue) GetDataByToken() (DeployQueue, error) {
+	var deployQueue DeployQueue
+	err := sq.
+		Select("id, project_id, project_name, commit_id, branch, ref, source, state, token, publisher_id, publisher_name").
+		From(deployQueueTable).
+		Where(sq.Eq{"token": dq.Token}).
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&deployQueue.ID,
+			&deployQueue.ProjectID,
+			&deployQueue.ProjectName,
+			&deployQueue.CommitID,
+			&deployQueue.Branch,
+			&deployQueue.Ref,
+			&deployQueue.Source,
+			&deployQueue.State,
+			&deployQueue.Token,
+			&deployQueue.PublisherID,
+			&deployQueue.PublisherName,
+		)
+	return deployQueue, err
+}
+
+// GetWaitingProjectIDs the projects which have waiting queue
+func (dq DeployQueue) GetWaitingProjectIDs() ([]int64, error) {
+	rows, err := sq.
+		Select("DISTINCT project_id").
+		From(deployQueueTable).
+		Where(sq.Eq{"state": QueueWaiting}).
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	var projectIDs []int64
+	for rows.Next() {
+		var projectID int64
+		if err := rows.Scan(&projectID); err != nil {
+			return nil, err
+		}
+		projectIDs = append(projectIDs, projectID)
+	}
+	return projectIDs, nil
+}
+
+// ChangeWaitingState change the state only when the queue is still waiting,
+// return false when the queue has been dispatched or canceled
+func (dq DeployQueue) ChangeWaitingState() (bool, error) {
+	result, err := sq.
+		Update(deployQueueTable).
+		SetMap(sq.Eq{
+			"state": dq.State,
+			"token": dq.Token,
+		}).
+		Where(sq.Eq{"id": dq.ID, "state": QueueWaiting}).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// ResetDispatchedState put the queue dispatched with the token back to waiting,
+// it is used when the project can not be claimed after the queue is dispatched
+func (dq DeployQueue) ResetDispatchedState() error {
+	_, err := sq.
+		Update(deployQueueTable).
+		SetMap(sq.Eq{
+			"state": QueueWaiting,
+			"token": "",
+		}).
+		Where(sq.Eq{"id": dq.ID, "state": QueueDispatched, "token": dq.Token}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// GetData -
+func (dq DeployQueue) GetData() (DeployQueue, error) {
+	var deployQueue DeployQueue
+	err := sq.
+		Select("id, project_id, project_name, commit_id, branch, ref, source, state, publisher_id, publisher_name, approval_count, approval_role, summary, IFNULL(expire_time, '')").
+		From(deployQueueTable).
+		Where(sq.Eq{"id": dq.ID}).
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&deployQueue.ID,
+			&deployQueue.ProjectID,
+			&deployQueue.ProjectName,
+			&deployQueue.CommitID,
+			&deployQueue.Branch,
+			&deployQueue.Ref,
+			&deployQueue.Source,
+			&deployQueue.State,
+			&deployQueue.PublisherID,
+			&deployQueue.PublisherName,
+			&deployQueue.ApprovalCount,
+			&deployQueue.ApprovalRole,
+			&deployQueue.Summary,
+			&deployQueue.ExpireTime,
+		)
+	return deployQueue, err
+}
+
+// GetApprovingList the deploys waiting for approval of the project
+func (dq DeployQueue) GetApprovingList() (DeployQueues, error) {
+	rows, err := sq.
+		Select("id, project_id, project_name, commit_id, branch, ref, source, state, publisher_id, publisher_name, approval_count, approval_role, summary, expire_time, insert_time, update_time").
+		From(deployQueueTable).
+		Where(sq.Eq{"project_id": dq.ProjectID, "state": QueueApproving}).
+		OrderBy("id ASC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	deployQueues := DeployQueues{}
+	for rows.Next() {
+		var deployQueue DeployQueue
+		if err := rows.Scan(
+			&deployQueue.ID,
+			&deployQueue.ProjectID,
+			&deployQueue.ProjectName,
+			&deployQueue.CommitID,
+			&deployQueue.Branch,
+			&deployQueue.Ref,
+			&deployQueue.Source,
+			&deployQueue.State,
+			&deployQueue.PublisherID,
+			&deployQueue.PublisherName,
+			&deployQueue.ApprovalCount,
+			&deployQueue.ApprovalRole,
+			&deployQueue.Summary,
+			&deployQueue.ExpireTime,
+			&deployQueue.InsertTime,
+			&deployQueue.UpdateTime,
+		); err != nil {
+			return nil, err
+		}
+		deployQueues = append(deployQueues, deployQueue)
+	}
+	return deployQueues, nil
+}
+
+// ChangeApprovingState change the state only when the queue is still approving and not expired,
+// return false when the queue has been decided, canceled or expired
+func (dq DeployQueue) ChangeApprovingState() (bool, error) {
+	result, err := sq.
+		Update(deployQueueTable).
+		Set("state", dq.State).
+		Where(sq.Eq{"id": dq.ID, "state": QueueApproving}).
+		Where("expire_time > NOW()").
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// ExpireApproving mark the approving queues which are out of time as expired
+func (dq DeployQueue) ExpireApproving() (int64, error) {
+	result, err := sq.
+		Update(deployQueueTable).
+		Set("state", QueueExpired).
+		Where(sq.Eq{"state": QueueApproving}).
+		Where("expire_time <= NOW()").
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
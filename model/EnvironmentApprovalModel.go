@@ -0,0 +1,94 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const environmentApprovalTable = "`environment_approval`"
+
+// EnvironmentApproval the approval policy of the projects which have the same environment in the namespace,
+// it applies when the project does not set its own policy
+type EnvironmentApproval struct {
+	ID            int64  `json:"id"`
+	NamespaceID   int64  `json:"namespaceId"`
+	Environment   string `json:"environment"`
+	ApprovalCount uint16 `json:"approvalCount"`
+	ApprovalRole  string `json:"approvalRole"`
+	InsertTime    string `json:"insertTime"`
+	UpdateTime    string `json:"updateTime"`
+}
+
+// EnvironmentApprovals -
+type EnvironmentApprovals []EnvironmentApproval
+
+// GetListByNamespaceID -
+func (ea EnvironmentApproval) GetListByNamespaceID() (EnvironmentApprovals, error) {
+	rows, err := sq.
+		Select("id, namespace_id, environment, approval_count, approval_role, insert_time, update_time").
+		From(environmentApprovalTable).
+		Where(sq.Eq{"namespace_id": ea.NamespaceID}).
+		OrderBy("environment ASC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	environmentApprovals := EnvironmentApprovals{}
+	for rows.Next() {
+		var environmentApproval EnvironmentApproval
+		if err := rows.Scan(
+			&environmentApproval.ID,
+			&environmentApproval.NamespaceID,
+			&environmentApproval.Environment,
+			&environmentApproval.ApprovalCount,
+			&environmentApproval.ApprovalRole,
+			&environmentApproval.InsertTime,
+			&environmentApproval.UpdateTime,
+		); err != nil {
+			return nil, err
+		}
+		environmentApprovals = append(environmentApprovals, environmentApproval)
+	}
+	return environmentApprovals, nil
+}
+
+// GetDataByEnvironment -
+func (ea EnvironmentApproval) GetDataByEnvironment() (EnvironmentApproval, error) {
+	var environmentApproval EnvironmentApproval
+	err := sq.
+		Select("id, namespace_id, environment, approval_count, approval_role").
+		From(environmentApprovalTable).
+		Where(sq.Eq{"namespace_id": ea.NamespaceID, "environment": ea.Environment}).
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&environmentApproval.ID,
+			&environmentApproval.NamespaceID,
+			&environmentApproval.Environment,
+			&environmentApproval.ApprovalCount,
+			&environmentApproval.ApprovalRole,
+		)
+	return environmentApproval, err
+}
+
+// AddOrUpdate the policy of the environment
+func (ea EnvironmentApproval) AddOrUpdate() error {
+	_, err := sq.
+		Insert(environmentApprovalTable).
+		Columns("namespace_id", "environment", "approval_count", "approval_role").
+		Values(ea.NamespaceID, ea.Environment, ea.ApprovalCount, ea.ApprovalRole).
+		Suffix("ON DUPLICATE KEY UPDATE approval_count = VALUES(approval_count), approval_role = VALUES(approval_role)").
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteRow -
+func (ea EnvironmentApproval) DeleteRow() error {
+	_, err := sq.
+		Delete(environmentApprovalTable).
+		Where(sq.Eq{"id": ea.ID, "namespace_id": ea.NamespaceID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
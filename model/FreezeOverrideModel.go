@@ -0,0 +1,69 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const freezeOverrideTable = "`freeze_override`"
+
+// FreezeOverride the deploy made by the admin during the freeze
+type FreezeOverride struct {
+	ID            int64  `json:"id"`
+	ProjectID     int64  `json:"projectId"`
+	QueueID       int64  `json:"queueId"`
+	WindowID      int64  `json:"windowId"`
+	UserID        int64  `json:"userId"`
+	UserName      string `json:"userName"`
+	Justification string `json:"justification"`
+	InsertTime    string `json:"insertTime"`
+}
+
+// FreezeOverrides -
+type FreezeOverrides []FreezeOverride
+
+// GetListByProjectID -
+func (fo FreezeOverride) GetListByProjectID() (FreezeOverrides, error) {
+	rows, err := sq.
+		Select("id, project_id, queue_id, window_id, user_id, user_name, justification, insert_time").
+		From(freezeOverrideTable).
+		Where(sq.Eq{"project_id": fo.ProjectID}).
+		OrderBy("id DESC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	freezeOverrides := FreezeOverrides{}
+	for rows.Next() {
+		var freezeOverride FreezeOverride
+		if err := rows.Scan(
+			&freezeOverride.ID,
+			&freezeOverride.ProjectID,
+			&freezeOverride.QueueID,
+			&freezeOverride.WindowID,
+			&freezeOverride.UserID,
+			&freezeOverride.UserName,
+			&freezeOverride.Justification,
+			&freezeOverride.InsertTime,
+		); err != nil {
+			return nil, err
+		}
+		freezeOverrides = append(freezeOverrides, freezeOverride)
+	}
+	return freezeOverrides, nil
+}
+
+// AddRow return LastInsertId
+func (fo FreezeOverride) AddRow() (int64, error) {
+	result, err := sq.
+		Insert(freezeOverrideTable).
+		Columns("project_id", "queue_id", "window_id", "user_id", "user_name", "justification").
+		Values(fo.ProjectID, fo.QueueID, fo.WindowID, fo.UserID, fo.UserName, fo.Justification).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, err
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const freezeWindowTable = "`freeze_window`"
+
+// freeze window type
+const (
+	FreezeRecurring = iota + 1
+	FreezeRange
+)
+
+// FreezeWindow blocks the deploys of the namespace or the project
+type FreezeWindow struct {
+	ID          int64 `json:"id"`
+	NamespaceID int64 `json:"namespaceId"`
+	// ProjectID 0 means the window applies to all projects in the namespace
+	ProjectID int64 `json:"projectId"`
+	Type      uint8 `json:"type"`
+	// Cron every minute matched is frozen, e.g. "* 18-23 * * 5" freezes friday evenings
+	Cron       string `json:"cron"`
+	StartTime  string `json:"startTime"`
+	EndTime    string `json:"endTime"`
+	Reason     string `json:"reason"`
+	Creator    string `json:"creator"`
+	CreatorID  int64  `json:"creatorId"`
+	Editor     string `json:"editor"`
+	EditorID   int64  `json:"editorId"`
+	InsertTime string `json:"insertTime"`
+	UpdateTime string `json:"updateTime"`
+}
+
+// FreezeWindows -
+type FreezeWindows []FreezeWindow
+
+// GetList the windows of the namespace, filter by project id when it is set
+func (fw FreezeWindow) GetList() (FreezeWindows, error) {
+	builder := sq.
+		Select("id, namespace_id, project_id, type, cron, IFNULL(start_time, ''), IFNULL(end_time, ''), reason, creator, creator_id, editor, editor_id, insert_time, update_time").
+		From(freezeWindowTable).
+		Where(sq.Eq{"namespace_id": fw.NamespaceID})
+	if fw.ProjectID != 0 {
+		builder = builder.Where(sq.Eq{"project_id": fw.ProjectID})
+	}
+	return fw.query(builder.OrderBy("id DESC"))
+}
+
+// GetScopeList the windows which apply to the project, include the namespace ones
+func (fw FreezeWindow) GetScopeList() (FreezeWindows, error) {
+	builder := sq.
+		Select("id, namespace_id, project_id, type, cron, IFNULL(start_time, ''), IFNULL(end_time, ''), reason, creator, creator_id, editor, editor_id, insert_time, update_time").
+		From(freezeWindowTable).
+		Where(sq.Eq{"namespace_id": fw.NamespaceID, "project_id": []int64{0, fw.ProjectID}})
+	return fw.query(builder.OrderBy("id ASC"))
+}
+
+func (fw FreezeWindow) query(builder sq.SelectBuilder) (FreezeWindows, error) {
+	rows, err := builder.RunWith(DB).Query()
+	if err != nil {
+		return nil, err
+	}
+	freezeWindows := FreezeWindows{}
+	for rows.Next() {
+		var freezeWindow FreezeWindow
+		if err := rows.Scan(
+			&freezeWindow.ID,
+			&freezeWindow.NamespaceID,
+			&freezeWindow.ProjectID,
+			&freezeWindow.Type,
+			&freezeWindow.Cron,
+			&freezeWindow.StartTime,
+			&freezeWindow.EndTime,
+			&freezeWindow.Reason,
+			&freezeWindow.Creator,
+			&freezeWindow.CreatorID,
+			&freezeWindow.Editor,
+			&freezeWindow.EditorID,
+			&freezeWindow.InsertTime,
+			&freezeWindow.UpdateTime,
+		); err != nil {
+			return nil, err
+		}
+		freezeWindows = append(freezeWindows, freezeWindow)
+	}
+	return freezeWindows, nil
+}
+
+// AddRow return LastInsertId
+func (fw FreezeWindow) AddRow() (int64, error) {
+	result, err := sq.
+		Insert(freezeWindowTable).
+		Columns("namespace_id", "project_id", "type", "cron", "start_time", "end_time", "reason", "creator", "creator_id").
+		Values(fw.NamespaceID, fw.ProjectID, fw.Type, fw.Cron, nullTime(fw.StartTime), nullTime(fw.EndTime), fw.Reason, fw.Creator, fw.CreatorID).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, err
+}
+
+// EditRow -
+func (fw FreezeWindow) EditRow() error {
+	_, err := sq.
+		Update(freezeWindowTable).
+		SetMap(sq.Eq{
+			"type":       fw.Type,
+			"cron":       fw.Cron,
+			"start_time": nullTime(fw.StartTime),
+			"end_time":   nullTime(fw.EndTime),
+			"reason":     fw.Reason,
+			"editor":     fw.Editor,
+			"editor_id":  fw.EditorID,
+		}).
+		Where(sq.Eq{"id": fw.ID, "namespace_id": fw.NamespaceID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteRow -
+func (fw FreezeWindow) DeleteRow() error {
+	_, err := sq.
+		Delete(freezeWindowTable).
+		Where(sq.Eq{"id": fw.ID, "namespace_id": fw.NamespaceID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// nullTime the empty datetime is stored as NULL
+func nullTime(t string) interface{} {
+	if len(t) == 0 {
+		return nil
+	}
+	return t
+}
@@ -1,6 +1,9 @@
 package model
 
-import sq "github.com/Masterminds/squirrel"
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/zhenorzz/goploy/utils"
+)
 
 const installTraceTable = "`install_trace`"
 
@@ -31,12 +34,12 @@ const (
 	Script = 3
 )
 
-// AddRow return LastInsertId
+// AddRow return LastInsertId, the secrets in detail and ext are masked
 func (it InstallTrace) AddRow() (int64, error) {
 	result, err := sq.
 		Insert(installTraceTable).
 		Columns("token", "server_id", "server_name", "detail", "state", "operator_id", "operator_name", "type", "ext").
-		Values(it.Token, it.ServerID, it.ServerName, it.Detail, it.State, it.OperatorID, it.OperatorName, it.Type, it.Ext).
+		Values(it.Token, it.ServerID, it.ServerName, utils.Mask(it.Detail), it.State, it.OperatorID, it.OperatorName, it.Type, utils.Mask(it.Ext)).
 		RunWith(DB).
 		Exec()
 
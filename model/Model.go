@@ -57,7 +57,7 @@ func PaginationFrom(param url.Values) (Pagination, error) {
 	return pagination, nil
 }
 
-const ddl string = "CREATE DATABASE IF NOT EXISTS `goploy`;  CREATE TABLE IF NOT EXISTS `goploy`.`log` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `type` tinyint(3) UNSIGNED NOT NULL DEFAULT 1 COMMENT '日志类型', `ip` int(10) UNSIGNED NOT NULL DEFAULT 0, `desc` varchar(30) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '备注', `user_id` int(10) UNSIGNED NOT NULL DEFAULT 0 COMMENT '用户ID', `create_time` int(10) UNSIGNED NOT NULL DEFAULT 0 COMMENT '创建时间', PRIMARY KEY USING BTREE (`id`), INDEX `idx_create_time` USING BTREE(`create_time`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`project` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '项目名称', `url` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '项目仓库地址', `path` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '项目部署路径', `symlink_path` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '软链源路径', `environment` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '生产环境' COMMENT '部署环境', `branch` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT 'master' COMMENT '分支', `after_pull_script_mode` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '脚本类型', `after_pull_script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '脚本路径', `after_deploy_script_mode` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '脚本类型', `after_deploy_script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '脚本路径', `rsync_option` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT 'rsync 参数', `auto_deploy` tinyint(4) UNSIGNED NOT NULL DEFAULT 1 COMMENT '0=>关闭 1=>Webhook', `state` tinyint(4) UNSIGNED NOT NULL DEFAULT 1 COMMENT '0=>失效 1=>生效', `deploy_state` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '0=>未构建 1=>构建中 2=>成功 3=>失败', `publisher_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `publisher_name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `last_publish_token` char(36) CHARACTER SET utf8mb4 NOT NULL DEFAULT '', `notify_type` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '1=企业微信 2=钉钉 3=飞书 255=自定义', `notify_target` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '推送目标，目前只支持webhook', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`project_server` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `server_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_project_server` USING BTREE (`project_id`, `server_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`project_user` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `user_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_project_user` USING BTREE (`project_id`, `user_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`project_task` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `commit_id` char(40) NOT NULL DEFAULT '', `date` datetime DEFAULT NULL, `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '1', `is_run` tinyint(4) UNSIGNED NOT NULL DEFAULT '0', `creator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `creator` varchar(255) NOT NULL DEFAULT '', `editor_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `editor` varchar(255) NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), KEY `index_project_update` USING BTREE (`project_id`, `update_time`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`publish_trace` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `token` char(36) CHARACTER SET utf8mb4 NOT NULL DEFAULT '', `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `project_group_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `project_name` varchar(255) NOT NULL DEFAULT '', `detail` longtext NOT NULL, `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '1', `publisher_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `publisher_name` varchar(255) NOT NULL DEFAULT '', `type` tinyint(3) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1拉代码前脚本，2.git获取代码，3拉代码后脚本，4部署前脚本，5部署日志，6部署后脚本', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `ext` longtext NOT NULL, PRIMARY KEY USING BTREE (`id`), KEY `idx_project_id` USING BTREE (`project_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4;  CREATE TABLE `monitor` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL, `domain` varchar(50) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL, `port` smallint(5) UNSIGNED NOT NULL DEFAULT '80', `second` int(10) UNSIGNED NOT NULL DEFAULT '1' COMMENT '间隔', `times` smallint(5) UNSIGNED NOT NULL DEFAULT '1' COMMENT '连续失败次数', `description` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `notify_type` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1=企业微信 2=钉钉 3=飞书 255=自定义', `notify_target` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '1' COMMENT '0=暂停  1=开启', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`server` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `ip` varchar(15) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `port` smallint(10) UNSIGNED NOT NULL DEFAULT 22, `owner` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `description` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `last_publish_token` char(36) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `state` tinyint(10) UNSIGNED NOT NULL DEFAULT 1 COMMENT '0=>失效 1=>生效', PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_namespace_ip` USING BTREE (`namespace_id`, `ip`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`crontab` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `command` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `command_md5` char(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT 'command md5 for replace', `creator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `creator` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `editor_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `editor` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_command_md5` USING BTREE (`namespace_id`, `command_md5`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`crontab_server` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `crontab_id` int(10) UNSIGNED NOT NULL, `server_id` int(10) UNSIGNED NOT NULL, `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `idx_crontab_server` USING BTREE (`crontab_id`, `server_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`template` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `package_id_str` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL, `script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL, `remark` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`package` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `size` int(10) UNSIGNED NOT NULL DEFAULT '0', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 3 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`install_trace` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `token` char(36) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `server_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `server_name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `detail` longtext NOT NULL, `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '1', `operator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `operator_name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `type` tinyint(3) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1rsync 2ssh 3script', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `ext` text NOT NULL, PRIMARY KEY USING BTREE (`id`), KEY `idx_project_id` USING BTREE (`server_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`user` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `account` varchar(30) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `password` varchar(60) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `name` varchar(30) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `mobile` varchar(15) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `state` tinyint(1) NOT NULL DEFAULT '1' COMMENT '0=被禁用  1=正常', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `last_login_time` datetime DEFAULT NULL, `super_manager` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '超级管理员', PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE `namespace` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_name` (`name`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE `namespace_user` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL, `user_id` int(10) UNSIGNED NOT NULL, `role` varchar(20) NOT NULL, `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_namespace_user` USING BTREE (`namespace_id`, `user_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;"
+const ddl string = "CREATE DATABASE IF NOT EXISTS `goploy`;  CREATE TABLE IF NOT EXISTS `goploy`.`log` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `type` tinyint(3) UNSIGNED NOT NULL DEFAULT 1 COMMENT '日志类型', `ip` int(10) UNSIGNED NOT NULL DEFAULT 0, `desc` varchar(30) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '备注', `user_id` int(10) UNSIGNED NOT NULL DEFAULT 0 COMMENT '用户ID', `create_time` int(10) UNSIGNED NOT NULL DEFAULT 0 COMMENT '创建时间', PRIMARY KEY USING BTREE (`id`), INDEX `idx_create_time` USING BTREE(`create_time`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`project` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '项目名称', `url` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '项目仓库地址', `path` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '项目部署路径', `symlink_path` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '软链源路径', `environment` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '生产环境' COMMENT '部署环境', `branch` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT 'master' COMMENT '分支', `before_pull_script_mode` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '脚本类型', `before_pull_script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '拉代码前脚本', `after_pull_script_mode` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '脚本类型', `after_pull_script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '脚本路径', `before_deploy_script_mode` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '脚本类型', `before_deploy_script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '部署前脚本', `after_deploy_script_mode` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '脚本类型', `after_deploy_script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '脚本路径', `rsync_option` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT 'rsync 参数', `variables` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '脚本变量，每行一个 KEY=VALUE', `pipeline` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '部署流程，逗号分隔', `stage_timeout` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '部署步骤超时秒数，格式 git:300,transfer:600', `transfer_mode` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '文件传输方式 0=>rsync 1=>ssh', `artifact_mode` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '0=>同步代码目录 1=>打包制品部署', `artifact_path` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '制品输出目录，相对代码目录，空=>整个代码目录', `deploy_strategy` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '0=>全量 1=>滚动 2=>金丝雀', `batch_size` smallint(5) UNSIGNED NOT NULL DEFAULT 0 COMMENT '每批服务器数量，0=>剩余全部', `canary_confirm` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '金丝雀成功后 0=>自动继续 1=>人工确认', `auto_rollback` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '部署失败时 0=>不回滚 1=>已切换的服务器回滚到上一版本', `retain_count` smallint(5) UNSIGNED NOT NULL DEFAULT 10 COMMENT '保留最新的版本数量，0=>不按数量保留', `retain_days` smallint(5) UNSIGNED NOT NULL DEFAULT 0 COMMENT '保留最近天数内的版本，0=>不按天数保留', `pinned_releases` varchar(2000) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '固定保留的版本token，逗号分隔', `requeue_interrupted` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '服务重启中断的部署 0=>标记失败 1=>重新排队', `approval_count` smallint(5) UNSIGNED NOT NULL DEFAULT 0 COMMENT '部署需要的审批人数 0=>使用环境配置', `approval_role` varchar(20) NOT NULL DEFAULT '' COMMENT '审批人最低角色，空=>manager', `tag_pattern` varchar(255) NOT NULL DEFAULT '' COMMENT 'webhook 推送tag时触发部署的匹配规则，空=>忽略tag', `webhook_secret` varchar(64) NOT NULL DEFAULT '' COMMENT 'webhook 签名密钥', `auto_deploy` tinyint(4) UNSIGNED NOT NULL DEFAULT 1 COMMENT '0=>关闭 1=>Webhook', `state` tinyint(4) UNSIGNED NOT NULL DEFAULT 1 COMMENT '0=>失效 1=>生效', `deploy_state` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '0=>未构建 1=>构建中 2=>成功 3=>失败', `publisher_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `publisher_name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `last_publish_token` char(36) CHARACTER SET utf8mb4 NOT NULL DEFAULT '', `deploy_owner` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '执行部署的实例', `deploy_heartbeat` int(10) UNSIGNED NOT NULL DEFAULT 0 COMMENT '部署心跳时间戳', `notify_type` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '1=企业微信 2=钉钉 3=飞书 255=自定义', `notify_target` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '推送目标，目前只支持webhook', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`project_server` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `server_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `variables` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '服务器脚本变量，每行一个 KEY=VALUE', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_project_server` USING BTREE (`project_id`, `server_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`project_user` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `user_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_project_user` USING BTREE (`project_id`, `user_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`project_task` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `commit_id` char(40) NOT NULL DEFAULT '', `date` datetime DEFAULT NULL, `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '1', `is_run` tinyint(4) UNSIGNED NOT NULL DEFAULT '0', `creator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `creator` varchar(255) NOT NULL DEFAULT '', `editor_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `editor` varchar(255) NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), KEY `index_project_update` USING BTREE (`project_id`, `update_time`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`deploy_queue` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `project_name` varchar(255) NOT NULL DEFAULT '', `commit_id` varchar(255) NOT NULL DEFAULT '', `branch` varchar(255) NOT NULL DEFAULT '', `ref` varchar(255) NOT NULL DEFAULT '' COMMENT '部署的分支、tag或commit，空=>项目分支', `source` tinyint(4) UNSIGNED NOT NULL DEFAULT '1' COMMENT '1手动，2webhook，3定时任务', `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '0等待，1已部署，2已取消，3待审批，4已驳回，5审批超时', `token` char(36) CHARACTER SET utf8mb4 NOT NULL DEFAULT '' COMMENT '出队后的部署token', `publisher_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `publisher_name` varchar(255) NOT NULL DEFAULT '', `approval_count` smallint(5) UNSIGNED NOT NULL DEFAULT '0' COMMENT '需要的审批人数', `approval_role` varchar(20) NOT NULL DEFAULT '' COMMENT '审批人最低角色', `summary` text COMMENT '提交及变更文件摘要', `expire_time` datetime DEFAULT NULL COMMENT '审批截止时间', `freeze_override` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1=>管理员强制在冻结期部署', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), KEY `index_project_state` USING BTREE (`project_id`, `state`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`project_config_template` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `path` varchar(255) NOT NULL DEFAULT '' COMMENT '相对发布目录的文件路径', `content` text NOT NULL COMMENT 'text/template 模板内容', `creator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `creator` varchar(255) NOT NULL DEFAULT '', `editor_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `editor` varchar(255) NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), KEY `idx_project_id` USING BTREE (`project_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`project_health_check` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `type` tinyint(4) UNSIGNED NOT NULL DEFAULT '1' COMMENT '1=>http 2=>tcp', `target` varchar(255) NOT NULL DEFAULT '' COMMENT 'url 或 host:port 模板', `expect_status` smallint(5) UNSIGNED NOT NULL DEFAULT '0' COMMENT '期望状态码，0=>任意2xx', `expect_body` varchar(255) NOT NULL DEFAULT '' COMMENT '响应内容需包含的字符串', `retries` smallint(5) UNSIGNED NOT NULL DEFAULT '3' COMMENT '失败重试次数', `interval` smallint(5) UNSIGNED NOT NULL DEFAULT '5' COMMENT '重试间隔秒数', `timeout` smallint(5) UNSIGNED NOT NULL DEFAULT '5' COMMENT '单次检查超时秒数', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), KEY `idx_project_id` USING BTREE (`project_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`environment_approval` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `environment` varchar(255) NOT NULL DEFAULT '' COMMENT '对应project.environment', `approval_count` smallint(5) UNSIGNED NOT NULL DEFAULT '0' COMMENT '部署需要的审批人数', `approval_role` varchar(20) NOT NULL DEFAULT '' COMMENT '审批人最低角色，空=>manager', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE KEY `uk_namespace_environment` USING BTREE (`namespace_id`, `environment`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`deploy_approval` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `queue_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `user_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `user_name` varchar(255) NOT NULL DEFAULT '', `decision` tinyint(4) UNSIGNED NOT NULL DEFAULT '1' COMMENT '1同意，2驳回', `comment` varchar(255) NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE KEY `uk_queue_user` USING BTREE (`queue_id`, `user_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`freeze_window` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0' COMMENT '0=>空间内所有项目', `type` tinyint(4) UNSIGNED NOT NULL DEFAULT '1' COMMENT '1=>周期规则 2=>时间段', `cron` varchar(255) NOT NULL DEFAULT '' COMMENT '分 时 日 月 周，匹配的每一分钟都冻结', `start_time` datetime DEFAULT NULL, `end_time` datetime DEFAULT NULL, `reason` varchar(255) NOT NULL DEFAULT '', `creator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `creator` varchar(255) NOT NULL DEFAULT '', `editor_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `editor` varchar(255) NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), KEY `idx_namespace_project` USING BTREE (`namespace_id`, `project_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`freeze_override` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `queue_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `window_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `user_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `user_name` varchar(255) NOT NULL DEFAULT '', `justification` varchar(255) NOT NULL DEFAULT '' COMMENT '冻结期强制部署的理由', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), KEY `idx_project_id` USING BTREE (`project_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`user_token` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `user_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `name` varchar(255) NOT NULL DEFAULT '', `token_hash` char(64) NOT NULL DEFAULT '' COMMENT 'token的sha256', `token_prefix` varchar(16) NOT NULL DEFAULT '' COMMENT '用于识别token的前缀', `scope` tinyint(4) UNSIGNED NOT NULL DEFAULT '1' COMMENT '1=>只读 2=>发布', `project_ids` varchar(1000) NOT NULL DEFAULT '' COMMENT '允许的项目id，逗号分隔，空=>空间内所有项目', `expire_time` datetime DEFAULT NULL COMMENT '空=>永不过期', `last_used_time` datetime DEFAULT NULL, `last_used_ip` varchar(64) NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE KEY `uk_token_hash` USING BTREE (`token_hash`), KEY `idx_user_id` USING BTREE (`user_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`user_two_factor` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `user_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `secret` varchar(255) NOT NULL DEFAULT '' COMMENT 'AES-GCM 加密后的TOTP密钥', `enabled` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '0=>待验证 1=>已启用', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE KEY `uk_user_id` USING BTREE (`user_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`user_recovery_code` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `user_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `code_hash` char(64) NOT NULL DEFAULT '' COMMENT '恢复码的sha256', `used_time` datetime DEFAULT NULL COMMENT '空=>未使用', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), KEY `idx_user_id` USING BTREE (`user_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`user_session` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `user_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `session_id` char(32) NOT NULL DEFAULT '' COMMENT 'jwt中的sid', `ip` varchar(64) NOT NULL DEFAULT '', `user_agent` varchar(255) NOT NULL DEFAULT '', `last_active_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `expire_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '超过该时间未活动=>失效', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE KEY `uk_session_id` USING BTREE (`session_id`), KEY `idx_user_id` USING BTREE (`user_id`), KEY `idx_expire_time` USING BTREE (`expire_time`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`secret` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0' COMMENT '0=>空间内所有项目', `name` varchar(255) NOT NULL DEFAULT '' COMMENT '注入脚本的变量名', `value` text NOT NULL COMMENT 'AES-GCM 加密后的值', `description` varchar(255) NOT NULL DEFAULT '', `creator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `creator` varchar(255) NOT NULL DEFAULT '', `editor_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `editor` varchar(255) NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_namespace_project_name` USING BTREE (`namespace_id`, `project_id`, `name`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci; CREATE TABLE IF NOT EXISTS `goploy`.`publish_trace` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `token` char(36) CHARACTER SET utf8mb4 NOT NULL DEFAULT '', `project_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `project_group_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `project_name` varchar(255) NOT NULL DEFAULT '', `detail` longtext NOT NULL, `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '1', `publisher_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `publisher_name` varchar(255) NOT NULL DEFAULT '', `type` tinyint(3) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1拉代码前脚本，2.git获取代码，3拉代码后脚本，4部署前脚本，5部署日志，6部署后脚本，7清理，8回滚，9切换版本，10打包制品，11健康检查', `batch` smallint(5) UNSIGNED NOT NULL DEFAULT '0' COMMENT '部署批次，0=>非服务器步骤', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `ext` longtext NOT NULL, PRIMARY KEY USING BTREE (`id`), KEY `idx_project_id` USING BTREE (`project_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4;  CREATE TABLE `monitor` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL, `domain` varchar(50) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL, `port` smallint(5) UNSIGNED NOT NULL DEFAULT '80', `second` int(10) UNSIGNED NOT NULL DEFAULT '1' COMMENT '间隔', `times` smallint(5) UNSIGNED NOT NULL DEFAULT '1' COMMENT '连续失败次数', `description` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `notify_type` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1=企业微信 2=钉钉 3=飞书 255=自定义', `notify_target` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '1' COMMENT '0=暂停  1=开启', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`server` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `ip` varchar(15) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `port` smallint(10) UNSIGNED NOT NULL DEFAULT 22, `owner` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `description` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `last_publish_token` char(36) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `state` tinyint(10) UNSIGNED NOT NULL DEFAULT 1 COMMENT '0=>失效 1=>生效', PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_namespace_ip` USING BTREE (`namespace_id`, `ip`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`crontab` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL DEFAULT 0, `command` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `command_md5` char(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT 'command md5 for replace', `creator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `creator` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `editor_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `editor` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_command_md5` USING BTREE (`namespace_id`, `command_md5`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`crontab_server` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `crontab_id` int(10) UNSIGNED NOT NULL, `server_id` int(10) UNSIGNED NOT NULL, `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `idx_crontab_server` USING BTREE (`crontab_id`, `server_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`template` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `package_id_str` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL, `script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL, `remark` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`package` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `size` int(10) UNSIGNED NOT NULL DEFAULT '0', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 3 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`install_trace` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `token` char(36) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `server_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `server_name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `detail` longtext NOT NULL, `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '1', `operator_id` int(10) UNSIGNED NOT NULL DEFAULT '0', `operator_name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `type` tinyint(3) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1rsync 2ssh 3script', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `ext` text NOT NULL, PRIMARY KEY USING BTREE (`id`), KEY `idx_project_id` USING BTREE (`server_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE IF NOT EXISTS `goploy`.`user` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `account` varchar(30) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `password` varchar(60) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `name` varchar(30) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `mobile` varchar(15) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `state` tinyint(1) NOT NULL DEFAULT '1' COMMENT '0=被禁用  1=正常', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, `last_login_time` datetime DEFAULT NULL, `super_manager` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '超级管理员', PRIMARY KEY USING BTREE (`id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE `namespace` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `name` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '', `two_factor_role` varchar(20) NOT NULL DEFAULT '' COMMENT '该角色及以上需要两步验证，空=>不需要', `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_name` (`name`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;  CREATE TABLE `namespace_user` ( `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT, `namespace_id` int(10) UNSIGNED NOT NULL, `user_id` int(10) UNSIGNED NOT NULL, `role` varchar(20) NOT NULL, `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY USING BTREE (`id`), UNIQUE `uk_namespace_user` USING BTREE (`namespace_id`, `user_id`) ) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARSET = utf8mb4 COLLATE utf8mb4_general_ci;"
 const dml string = "INSERT INTO `goploy`.`user`(`id`, `account`, `password`, `name`, `mobile`, `state`, `super_manager`) VALUES (1, 'admin', '$2a$10$89ZJ2xeJj35GOw11Qiucr.phaEZP4.kBX6aKTs7oWFp1xcGBBgijm', '超管', '', 1, 1); INSERT INTO `goploy`.`namespace`(`id`, `name`) VALUES (1, 'goploy'); INSERT INTO `goploy`.`namespace_user`(`id`, `namespace_id`, `user_id`, `role`, `insert_time`, `update_time`) VALUES (1, 1, 1, 'admin');"
 
 // ImportSQL -
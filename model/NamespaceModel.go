@@ -10,12 +10,13 @@ const namespaceTable = "`namespace`"
 
 // Namespace -
 type Namespace struct {
-	ID         int64  `json:"id"`
-	Name       string `json:"name"`
-	UserID     int64  `json:"-"`
-	Role       string `json:"role"`
-	InsertTime string `json:"insertTime,omitempty"`
-	UpdateTime string `json:"updateTime,omitempty"`
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	TwoFactorRole string `json:"twoFactorRole"`
+	UserID        int64  `json:"-"`
+	Role          string `json:"role"`
+	InsertTime    string `json:"insertTime,omitempty"`
+	UpdateTime    string `json:"updateTime,omitempty"`
 }
 
 // Namespaces -
@@ -47,10 +48,21 @@ func (ns Namespace) EditRow() error {
 	return err
 }
 
+// EditTwoFactorRole the role and above must pass the two-factor authentication, empty means not required
+func (ns Namespace) EditTwoFactorRole() error {
+	_, err := sq.
+		Update(namespaceTable).
+		Set("two_factor_role", ns.TwoFactorRole).
+		Where(sq.Eq{"id": ns.ID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
 // GetAllByUserID -
 func (ns Namespace) GetAllByUserID() (Namespaces, error) {
 	rows, err := sq.
-		Select("namespace.id, namespace.name, role").
+		Select("namespace.id, namespace.name, namespace.two_factor_role, role").
 		From(namespaceTable).
 		Join(namespaceUserTable + " ON namespace_user.namespace_id = namespace.id").
 		Where(sq.Eq{"user_id": ns.UserID}).
@@ -62,7 +74,7 @@ func (ns Namespace) GetAllByUserID() (Namespaces, error) {
 	namespaces := Namespaces{}
 	for rows.Next() {
 		var namespace Namespace
-		if err := rows.Scan(&namespace.ID, &namespace.Name, &namespace.Role); err != nil {
+		if err := rows.Scan(&namespace.ID, &namespace.Name, &namespace.TwoFactorRole, &namespace.Role); err != nil {
 			return nil, err
 		}
 		namespaces = append(namespaces, namespace)
@@ -73,7 +85,7 @@ func (ns Namespace) GetAllByUserID() (Namespaces, error) {
 // GetListByUserID -
 func (ns Namespace) GetListByUserID(pagination Pagination) (Namespaces, error) {
 	rows, err := sq.
-		Select("namespace.id, namespace.name, namespace.insert_time, namespace.update_time").
+		Select("namespace.id, namespace.name, namespace.two_factor_role, namespace.insert_time, namespace.update_time").
 		From(namespaceTable).
 		Join(namespaceUserTable + " ON namespace_user.namespace_id = namespace.id").
 		Where(sq.Eq{
@@ -93,7 +105,7 @@ func (ns Namespace) GetListByUserID(pagination Pagination) (Namespaces, error) {
 	for rows.Next() {
 		var namespace Namespace
 
-		if err := rows.Scan(&namespace.ID, &namespace.Name, &namespace.InsertTime, &namespace.UpdateTime); err != nil {
+		if err := rows.Scan(&namespace.ID, &namespace.Name, &namespace.TwoFactorRole, &namespace.InsertTime, &namespace.UpdateTime); err != nil {
 			return nil, err
 		}
 		namespaces = append(namespaces, namespace)
@@ -97,6 +97,35 @@ func (nu NamespaceUser) GetAllGteManagerByNamespaceID() (NamespaceUsers, error)
 	return namespaceUsers, nil
 }
 
+// GetData -
+func (nu NamespaceUser) GetData() (NamespaceUser, error) {
+	var namespaceUser NamespaceUser
+	err := sq.
+		Select("id, namespace_id, user_id, role").
+		From(namespaceUserTable).
+		Where(sq.Eq{"id": nu.ID}).
+		RunWith(DB).
+		QueryRow().
+		Scan(&namespaceUser.ID, &namespaceUser.NamespaceID, &namespaceUser.UserID, &namespaceUser.Role)
+	return namespaceUser, err
+}
+
+// GetDataByUserID the binding of the user in the namespace
+func (nu NamespaceUser) GetDataByUserID() (NamespaceUser, error) {
+	var namespaceUser NamespaceUser
+	err := sq.
+		Select("id, namespace_id, user_id, role").
+		From(namespaceUserTable).
+		Where(sq.Eq{
+			"namespace_id": nu.NamespaceID,
+			"user_id":      nu.UserID,
+		}).
+		RunWith(DB).
+		QueryRow().
+		Scan(&namespaceUser.ID, &namespaceUser.NamespaceID, &namespaceUser.UserID, &namespaceUser.Role)
+	return namespaceUser, err
+}
+
 // AddMany -
 func (nu NamespaceUsers) AddMany() error {
 	if len(nu) == 0 {
@@ -158,3 +187,13 @@ func (nu NamespaceUser) DeleteByUserID() error {
 		Exec()
 	return err
 }
+
+// DeleteInNamespaceIDs delete the user in the namespaces
+func (nu NamespaceUser) DeleteInNamespaceIDs(namespaceIDs []int64) error {
+	_, err := sq.
+		Delete(namespaceUserTable).
+		Where(sq.Eq{"user_id": nu.UserID, "namespace_id": namespaceIDs}).
+		RunWith(DB).
+		Exec()
+	return err
+}
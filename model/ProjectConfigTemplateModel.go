@@ -0,0 +1,117 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const projectConfigTemplateTable = "`project_config_template`"
+
+// ProjectConfigTemplate the file rendered for each server and uploaded into the release directory
+type ProjectConfigTemplate struct {
+	ID         int64  `json:"id"`
+	ProjectID  int64  `json:"projectId"`
+	Path       string `json:"path"`
+	Content    string `json:"content"`
+	Creator    string `json:"creator"`
+	CreatorID  int64  `json:"creatorId"`
+	Editor     string `json:"editor"`
+	EditorID   int64  `json:"editorId"`
+	InsertTime string `json:"insertTime"`
+	UpdateTime string `json:"updateTime"`
+}
+
+// ProjectConfigTemplates -
+type ProjectConfigTemplates []ProjectConfigTemplate
+
+// GetListByProjectID -
+func (pct ProjectConfigTemplate) GetListByProjectID() (ProjectConfigTemplates, error) {
+	rows, err := sq.
+		Select("id, project_id, path, content, creator, creator_id, editor, editor_id, insert_time, update_time").
+		From(projectConfigTemplateTable).
+		Where(sq.Eq{"project_id": pct.ProjectID}).
+		OrderBy("path ASC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	projectConfigTemplates := ProjectConfigTemplates{}
+	for rows.Next() {
+		var projectConfigTemplate ProjectConfigTemplate
+		if err := rows.Scan(
+			&projectConfigTemplate.ID,
+			&projectConfigTemplate.ProjectID,
+			&projectConfigTemplate.Path,
+			&projectConfigTemplate.Content,
+			&projectConfigTemplate.Creator,
+			&projectConfigTemplate.CreatorID,
+			&projectConfigTemplate.Editor,
+			&projectConfigTemplate.EditorID,
+			&projectConfigTemplate.InsertTime,
+			&projectConfigTemplate.UpdateTime,
+		); err != nil {
+			return nil, err
+		}
+		projectConfigTemplates = append(projectConfigTemplates, projectConfigTemplate)
+	}
+	return projectConfigTemplates, nil
+}
+
+// GetData -
+func (pct ProjectConfigTemplate) GetData() (ProjectConfigTemplate, error) {
+	var projectConfigTemplate ProjectConfigTemplate
+	err := sq.
+		Select("id, project_id, path, content").
+		From(projectConfigTemplateTable).
+		Where(sq.Eq{"id": pct.ID}).
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&projectConfigTemplate.ID,
+			&projectConfigTemplate.ProjectID,
+			&projectConfigTemplate.Path,
+			&projectConfigTemplate.Content,
+		)
+	return projectConfigTemplate, err
+}
+
+// AddRow return LastInsertId
+func (pct ProjectConfigTemplate) AddRow() (int64, error) {
+	result, err := sq.
+		Insert(projectConfigTemplateTable).
+		Columns("project_id", "path", "content", "creator", "creator_id").
+		Values(pct.ProjectID, pct.Path, pct.Content, pct.Creator, pct.CreatorID).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, err
+}
+
+// EditRow -
+func (pct ProjectConfigTemplate) EditRow() error {
+	_, err := sq.
+		Update(projectConfigTemplateTable).
+		SetMap(sq.Eq{
+			"path":      pct.Path,
+			"content":   pct.Content,
+			"editor":    pct.Editor,
+			"editor_id": pct.EditorID,
+		}).
+		Where(sq.Eq{"id": pct.ID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteRow -
+func (pct ProjectConfigTemplate) DeleteRow() error {
+	_, err := sq.
+		Delete(projectConfigTemplateTable).
+		Where(sq.Eq{"id": pct.ID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
@@ -0,0 +1,130 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const projectHealthCheckTable = "`project_health_check`"
+
+// health check type
+const (
+	HealthCheckHTTP = iota + 1
+	HealthCheckTCP
+)
+
+// ProjectHealthCheck runs against each server after the symlink switch
+type ProjectHealthCheck struct {
+	ID        int64 `json:"id"`
+	ProjectID int64 `json:"projectId"`
+	Type      uint8 `json:"type"`
+	// Target is the url of http check or the host:port of tcp check, e.g. http://{{.Server.ServerIP}}:8080/health
+	Target string `json:"target"`
+	// ExpectStatus 0 means any 2xx status
+	ExpectStatus int    `json:"expectStatus"`
+	ExpectBody   string `json:"expectBody"`
+	Retries      uint16 `json:"retries"`
+	Interval     uint16 `json:"interval"`
+	Timeout      uint16 `json:"timeout"`
+	InsertTime   string `json:"insertTime"`
+	UpdateTime   string `json:"updateTime"`
+}
+
+// ProjectHealthChecks -
+type ProjectHealthChecks []ProjectHealthCheck
+
+// GetListByProjectID -
+func (phc ProjectHealthCheck) GetListByProjectID() (ProjectHealthChecks, error) {
+	rows, err := sq.
+		Select("id, project_id, type, target, expect_status, expect_body, retries, `interval`, timeout, insert_time, update_time").
+		From(projectHealthCheckTable).
+		Where(sq.Eq{"project_id": phc.ProjectID}).
+		OrderBy("id ASC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	projectHealthChecks := ProjectHealthChecks{}
+	for rows.Next() {
+		var projectHealthCheck ProjectHealthCheck
+		if err := rows.Scan(
+			&projectHealthCheck.ID,
+			&projectHealthCheck.ProjectID,
+			&projectHealthCheck.Type,
+			&projectHealthCheck.Target,
+			&projectHealthCheck.ExpectStatus,
+			&projectHealthCheck.ExpectBody,
+			&projectHealthCheck.Retries,
+			&projectHealthCheck.Interval,
+			&projectHealthCheck.Timeout,
+			&projectHealthCheck.InsertTime,
+			&projectHealthCheck.UpdateTime,
+		); err != nil {
+			return nil, err
+		}
+		projectHealthChecks = append(projectHealthChecks, projectHealthCheck)
+	}
+	return projectHealthChecks, nil
+}
+
+// GetData -
+func (phc ProjectHealthCheck) GetData() (ProjectHealthCheck, error) {
+	var projectHealthCheck ProjectHealthCheck
+	err := sq.
+		Select("id, project_id, type, target").
+		From(projectHealthCheckTable).
+		Where(sq.Eq{"id": phc.ID}).
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&projectHealthCheck.ID,
+			&projectHealthCheck.ProjectID,
+			&projectHealthCheck.Type,
+			&projectHealthCheck.Target,
+		)
+	return projectHealthCheck, err
+}
+
+// AddRow return LastInsertId
+func (phc ProjectHealthCheck) AddRow() (int64, error) {
+	result, err := sq.
+		Insert(projectHealthCheckTable).
+		Columns("project_id", "type", "target", "expect_status", "expect_body", "retries", "`interval`", "timeout").
+		Values(phc.ProjectID, phc.Type, phc.Target, phc.ExpectStatus, phc.ExpectBody, phc.Retries, phc.Interval, phc.Timeout).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, err
+}
+
+// EditRow -
+func (phc ProjectHealthCheck) EditRow() error {
+	_, err := sq.
+		Update(projectHealthCheckTable).
+		SetMap(sq.Eq{
+			"type":          phc.Type,
+			"target":        phc.Target,
+			"expect_status": phc.ExpectStatus,
+			"expect_body":   phc.ExpectBody,
+			"retries":       phc.Retries,
+			"`interval`":    phc.Interval,
+			"timeout":       phc.Timeout,
+		}).
+		Where(sq.Eq{"id": phc.ID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteRow -
+func (phc ProjectHealthCheck) DeleteRow() error {
+	_, err := sq.
+		Delete(projectHealthCheckTable).
+		Where(sq.Eq{"id": phc.ID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
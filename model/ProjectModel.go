@@ -2,6 +2,10 @@ package model
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	sq "github.com/Masterminds/squirrel"
 )
 
@@ -9,31 +13,56 @@ const projectTable = "`project`"
 
 // Project -
 type Project struct {
-	ID                    int64  `json:"id"`
-	NamespaceID           int64  `json:"namespaceId"`
-	UserID                int64  `json:"userId,omitempty"`
-	Name                  string `json:"name"`
-	URL                   string `json:"url"`
-	Path                  string `json:"path"`
-	SymlinkPath           string `json:"symlinkPath"`
-	Environment           string `json:"environment"`
-	Branch                string `json:"branch"`
-	AfterPullScriptMode   string `json:"afterPullScriptMode"`
-	AfterPullScript       string `json:"afterPullScript"`
-	AfterDeployScriptMode string `json:"afterDeployScriptMode"`
-	AfterDeployScript     string `json:"afterDeployScript"`
-	RsyncOption           string `json:"rsyncOption"`
-	AutoDeploy            uint8  `json:"autoDeploy"`
-	PublisherID           int64  `json:"publisherId"`
-	PublisherName         string `json:"publisherName"`
-	PublishExt            string `json:"publishExt"`
-	DeployState           uint8  `json:"deployState"`
-	LastPublishToken      string `json:"lastPublishToken"`
-	NotifyType            uint8  `json:"notifyType"`
-	NotifyTarget          string `json:"notifyTarget"`
-	State                 uint8  `json:"state"`
-	InsertTime            string `json:"insertTime"`
-	UpdateTime            string `json:"updateTime"`
+	ID                     int64  `json:"id"`
+	NamespaceID            int64  `json:"namespaceId"`
+	UserID                 int64  `json:"userId,omitempty"`
+	Name                   string `json:"name"`
+	URL                    string `json:"url"`
+	Path                   string `json:"path"`
+	SymlinkPath            string `json:"symlinkPath"`
+	Environment            string `json:"environment"`
+	Branch                 string `json:"branch"`
+	BeforePullScriptMode   string `json:"beforePullScriptMode"`
+	BeforePullScript       string `json:"beforePullScript"`
+	AfterPullScriptMode    string `json:"afterPullScriptMode"`
+	AfterPullScript        string `json:"afterPullScript"`
+	BeforeDeployScriptMode string `json:"beforeDeployScriptMode"`
+	BeforeDeployScript     string `json:"beforeDeployScript"`
+	AfterDeployScriptMode  string `json:"afterDeployScriptMode"`
+	AfterDeployScript      string `json:"afterDeployScript"`
+	RsyncOption            string `json:"rsyncOption"`
+	Variables              string `json:"variables"`
+	Pipeline               string `json:"pipeline"`
+	StageTimeout           string `json:"stageTimeout"`
+	TransferMode           uint8  `json:"transferMode"`
+	ArtifactMode           uint8  `json:"artifactMode"`
+	ArtifactPath           string `json:"artifactPath"`
+	DeployStrategy         uint8  `json:"deployStrategy"`
+	BatchSize              uint16 `json:"batchSize"`
+	CanaryConfirm          uint8  `json:"canaryConfirm"`
+	AutoRollback           uint8  `json:"autoRollback"`
+	RetainCount            uint16 `json:"retainCount"`
+	RetainDays             uint16 `json:"retainDays"`
+	PinnedReleases         string `json:"pinnedReleases"`
+	RequeueInterrupted     uint8  `json:"requeueInterrupted"`
+	ApprovalCount          uint16 `json:"approvalCount"`
+	ApprovalRole           string `json:"approvalRole"`
+	TagPattern             string `json:"tagPattern"`
+	// WebhookSecret signs the webhook request, it is only read by GetWebhookSecret
+	WebhookSecret    string `json:"-"`
+	AutoDeploy       uint8  `json:"autoDeploy"`
+	PublisherID      int64  `json:"publisherId"`
+	PublisherName    string `json:"publisherName"`
+	PublishExt       string `json:"publishExt"`
+	DeployState      uint8  `json:"deployState"`
+	LastPublishToken string `json:"lastPublishToken"`
+	DeployOwner      string `json:"deployOwner"`
+	DeployHeartbeat  int64  `json:"deployHeartbeat"`
+	NotifyType       uint8  `json:"notifyType"`
+	NotifyTarget     string `json:"notifyTarget"`
+	State            uint8  `json:"state"`
+	InsertTime       string `json:"insertTime"`
+	UpdateTime       string `json:"updateTime"`
 }
 
 // Project deploy state
@@ -58,6 +87,35 @@ const (
 	NotifyCustom   = 255
 )
 
+// Project deploy strategy
+const (
+	StrategyAllAtOnce = 0
+	StrategyRolling   = 1
+	StrategyCanary    = 2
+)
+
+// Project transfer mode
+const (
+	TransferRsync = 0
+	TransferSSH   = 1
+)
+
+// Project pipeline stage
+const (
+	StageBeforePull   = "beforePull"
+	StageGit          = "git"
+	StageBuild        = "build"
+	StagePackage      = "package"
+	StageBeforeDeploy = "beforeDeploy"
+	StageTransfer     = "transfer"
+	StageAfterDeploy  = "afterDeploy"
+	StageHealthCheck  = "healthCheck"
+	StageClean        = "clean"
+)
+
+// DefaultPipeline is used when the project does not define its own pipeline
+var DefaultPipeline = []string{StageBeforePull, StageGit, StageBuild, StagePackage, StageBeforeDeploy, StageTransfer, StageAfterDeploy, StageHealthCheck, StageClean}
+
 // Projects -
 type Projects []Project
 
@@ -65,8 +123,8 @@ type Projects []Project
 func (p Project) AddRow() (int64, error) {
 	result, err := sq.
 		Insert(projectTable).
-		Columns("namespace_id", "name", "url", "path", "symlink_path", "environment", "branch", "after_pull_script_mode", "after_pull_script", "after_deploy_script_mode", "after_deploy_script", "rsync_option", "notify_type", "notify_target").
-		Values(p.NamespaceID, p.Name, p.URL, p.Path, p.SymlinkPath, p.Environment, p.Branch, p.AfterPullScriptMode, p.AfterPullScript, p.AfterDeployScriptMode, p.AfterDeployScript, p.RsyncOption, p.NotifyType, p.NotifyTarget).
+		Columns("namespace_id", "name", "url", "path", "symlink_path", "environment", "branch", "before_pull_script_mode", "before_pull_script", "after_pull_script_mode", "after_pull_script", "before_deploy_script_mode", "before_deploy_script", "after_deploy_script_mode", "after_deploy_script", "rsync_option", "variables", "pipeline", "stage_timeout", "transfer_mode", "artifact_mode", "artifact_path", "deploy_strategy", "batch_size", "canary_confirm", "auto_rollback", "retain_count", "retain_days", "pinned_releases", "requeue_interrupted", "approval_count", "approval_role", "tag_pattern", "notify_type", "notify_target").
+		Values(p.NamespaceID, p.Name, p.URL, p.Path, p.SymlinkPath, p.Environment, p.Branch, p.BeforePullScriptMode, p.BeforePullScript, p.AfterPullScriptMode, p.AfterPullScript, p.BeforeDeployScriptMode, p.BeforeDeployScript, p.AfterDeployScriptMode, p.AfterDeployScript, p.RsyncOption, p.Variables, p.Pipeline, p.StageTimeout, p.TransferMode, p.ArtifactMode, p.ArtifactPath, p.DeployStrategy, p.BatchSize, p.CanaryConfirm, p.AutoRollback, p.RetainCount, p.RetainDays, p.PinnedReleases, p.RequeueInterrupted, p.ApprovalCount, p.ApprovalRole, p.TagPattern, p.NotifyType, p.NotifyTarget).
 		RunWith(DB).
 		Exec()
 	if err != nil {
@@ -81,19 +139,40 @@ func (p Project) EditRow() error {
 	_, err := sq.
 		Update(projectTable).
 		SetMap(sq.Eq{
-			"name":                     p.Name,
-			"url":                      p.URL,
-			"path":                     p.Path,
-			"symlink_path":             p.SymlinkPath,
-			"environment":              p.Environment,
-			"branch":                   p.Branch,
-			"after_pull_script_mode":   p.AfterPullScriptMode,
-			"after_pull_script":        p.AfterPullScript,
-			"after_deploy_script_mode": p.AfterDeployScriptMode,
-			"after_deploy_script":      p.AfterDeployScript,
-			"rsync_option":             p.RsyncOption,
-			"notify_type":              p.NotifyType,
-			"notify_target":            p.NotifyTarget,
+			"name":                      p.Name,
+			"url":                       p.URL,
+			"path":                      p.Path,
+			"symlink_path":              p.SymlinkPath,
+			"environment":               p.Environment,
+			"branch":                    p.Branch,
+			"before_pull_script_mode":   p.BeforePullScriptMode,
+			"before_pull_script":        p.BeforePullScript,
+			"after_pull_script_mode":    p.AfterPullScriptMode,
+			"after_pull_script":         p.AfterPullScript,
+			"before_deploy_script_mode": p.BeforeDeployScriptMode,
+			"before_deploy_script":      p.BeforeDeployScript,
+			"after_deploy_script_mode":  p.AfterDeployScriptMode,
+			"after_deploy_script":       p.AfterDeployScript,
+			"rsync_option":              p.RsyncOption,
+			"variables":                 p.Variables,
+			"pipeline":                  p.Pipeline,
+			"stage_timeout":             p.StageTimeout,
+			"transfer_mode":             p.TransferMode,
+			"artifact_mode":             p.ArtifactMode,
+			"artifact_path":             p.ArtifactPath,
+			"deploy_strategy":           p.DeployStrategy,
+			"batch_size":                p.BatchSize,
+			"canary_confirm":            p.CanaryConfirm,
+			"auto_rollback":             p.AutoRollback,
+			"retain_count":              p.RetainCount,
+			"retain_days":               p.RetainDays,
+			"pinned_releases":           p.PinnedReleases,
+			"requeue_interrupted":       p.RequeueInterrupted,
+			"approval_count":            p.ApprovalCount,
+			"approval_role":             p.ApprovalRole,
+			"tag_pattern":               p.TagPattern,
+			"notify_type":               p.NotifyType,
+			"notify_target":             p.NotifyTarget,
 		}).
 		Where(sq.Eq{"id": p.ID}).
 		RunWith(DB).
@@ -128,15 +207,112 @@ func (p Project) RemoveRow() error {
 	return err
 }
 
-// Publish project
-func (p Project) Publish() error {
-	_, err := sq.
+// Publish claim the project for the deploy only when it is not deploying,
+// return false when another deploy has claimed it, the instances share the claim through the database
+func (p Project) Publish() (bool, error) {
+	result, err := sq.
 		Update(projectTable).
 		SetMap(sq.Eq{
 			"publisher_id":       p.PublisherID,
 			"publisher_name":     p.PublisherName,
 			"deploy_state":       p.DeployState,
 			"last_publish_token": p.LastPublishToken,
+			"deploy_owner":       p.DeployOwner,
+			"deploy_heartbeat":   time.Now().Unix(),
+		}).
+		Where(sq.Eq{"id": p.ID}).
+		Where(sq.NotEq{"deploy_state": ProjectDeploying}).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// RestoreClaim put the deploy_state of the project claimed by the token back,
+// it is used by the operation which is not a deploy, e.g. pruning the releases
+func (p Project) RestoreClaim(token string) error {
+	_, err := sq.
+		Update(projectTable).
+		SetMap(sq.Eq{
+			"deploy_state": p.DeployState,
+		}).
+		Where(sq.Eq{"id": p.ID, "last_publish_token": token, "deploy_state": ProjectDeploying}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// Heartbeat keep the deploy of the owner alive
+func (p Project) Heartbeat() error {
+	_, err := sq.
+		Update(projectTable).
+		SetMap(sq.Eq{
+			"deploy_heartbeat": time.Now().Unix(),
+		}).
+		Where(sq.Eq{"id": p.ID, "deploy_owner": p.DeployOwner, "deploy_state": ProjectDeploying}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// GetDeployingList the projects in deploying state
+func (p Project) GetDeployingList() (Projects, error) {
+	rows, err := sq.
+		Select("id, name, last_publish_token, publisher_id, publisher_name, deploy_owner, deploy_heartbeat, requeue_interrupted").
+		From(projectTable).
+		Where(sq.Eq{"deploy_state": ProjectDeploying}).
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	projects := Projects{}
+	for rows.Next() {
+		var project Project
+		if err := rows.Scan(
+			&project.ID,
+			&project.Name,
+			&project.LastPublishToken,
+			&project.PublisherID,
+			&project.PublisherName,
+			&project.DeployOwner,
+			&project.DeployHeartbeat,
+			&project.RequeueInterrupted,
+		); err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// DeployInterrupt set deploy_state to fail when the deploy of the token is still deploying,
+// return false when the deploy has finished
+func (p Project) DeployInterrupt() (bool, error) {
+	result, err := sq.
+		Update(projectTable).
+		SetMap(sq.Eq{
+			"deploy_state": ProjectFail,
+		}).
+		Where(sq.Eq{"id": p.ID, "last_publish_token": p.LastPublishToken, "deploy_state": ProjectDeploying}).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// DeployCancel reset deploy_state after the deploy is cancelled
+func (p Project) DeployCancel() error {
+	_, err := sq.
+		Update(projectTable).
+		SetMap(sq.Eq{
+			"deploy_state": ProjectNotDeploy,
 		}).
 		Where(sq.Eq{"id": p.ID}).
 		RunWith(DB).
@@ -175,7 +351,7 @@ func (p Project) DeployFail() error {
 // GetList -
 func (p Project) GetList(pagination Pagination) (Projects, error) {
 	builder := sq.
-		Select("project.id, name, url, path, symlink_path, environment, branch, after_pull_script_mode, after_pull_script, after_deploy_script_mode, after_deploy_script, rsync_option, auto_deploy, notify_type, notify_target, project.insert_time, project.update_time").
+		Select("project.id, name, url, path, symlink_path, environment, branch, before_pull_script_mode, before_pull_script, after_pull_script_mode, after_pull_script, before_deploy_script_mode, before_deploy_script, after_deploy_script_mode, after_deploy_script, rsync_option, variables, pipeline, stage_timeout, transfer_mode, artifact_mode, artifact_path, deploy_strategy, batch_size, canary_confirm, auto_rollback, retain_count, retain_days, pinned_releases, requeue_interrupted, approval_count, approval_role, tag_pattern, auto_deploy, notify_type, notify_target, project.insert_time, project.update_time").
 		From(projectTable).
 		Join(projectUserTable + " ON project_user.project_id = project.id").
 		Where(sq.Eq{
@@ -209,11 +385,32 @@ func (p Project) GetList(pagination Pagination) (Projects, error) {
 			&project.SymlinkPath,
 			&project.Environment,
 			&project.Branch,
+			&project.BeforePullScriptMode,
+			&project.BeforePullScript,
 			&project.AfterPullScriptMode,
 			&project.AfterPullScript,
+			&project.BeforeDeployScriptMode,
+			&project.BeforeDeployScript,
 			&project.AfterDeployScriptMode,
 			&project.AfterDeployScript,
 			&project.RsyncOption,
+			&project.Variables,
+			&project.Pipeline,
+			&project.StageTimeout,
+			&project.TransferMode,
+			&project.ArtifactMode,
+			&project.ArtifactPath,
+			&project.DeployStrategy,
+			&project.BatchSize,
+			&project.CanaryConfirm,
+			&project.AutoRollback,
+			&project.RetainCount,
+			&project.RetainDays,
+			&project.PinnedReleases,
+			&project.RequeueInterrupted,
+			&project.ApprovalCount,
+			&project.ApprovalRole,
+			&project.TagPattern,
 			&project.AutoDeploy,
 			&project.NotifyType,
 			&project.NotifyTarget,
@@ -351,7 +548,7 @@ func (p Project) GetAllByName() (Projects, error) {
 func (p Project) GetData() (Project, error) {
 	var project Project
 	err := sq.
-		Select("id, namespace_id, name, url, path, symlink_path, environment, branch, after_pull_script_mode, after_pull_script, after_deploy_script_mode, after_deploy_script, rsync_option, auto_deploy, deploy_state, notify_type, notify_target, insert_time, update_time").
+		Select("id, namespace_id, name, url, path, symlink_path, environment, branch, before_pull_script_mode, before_pull_script, after_pull_script_mode, after_pull_script, before_deploy_script_mode, before_deploy_script, after_deploy_script_mode, after_deploy_script, rsync_option, variables, pipeline, stage_timeout, transfer_mode, artifact_mode, artifact_path, deploy_strategy, batch_size, canary_confirm, auto_rollback, retain_count, retain_days, pinned_releases, requeue_interrupted, approval_count, approval_role, tag_pattern, auto_deploy, deploy_state, notify_type, notify_target, insert_time, update_time").
 		From(projectTable).
 		Where(sq.Eq{"id": p.ID}).
 		RunWith(DB).
@@ -365,11 +562,32 @@ func (p Project) GetData() (Project, error) {
 			&project.SymlinkPath,
 			&project.Environment,
 			&project.Branch,
+			&project.BeforePullScriptMode,
+			&project.BeforePullScript,
 			&project.AfterPullScriptMode,
 			&project.AfterPullScript,
+			&project.BeforeDeployScriptMode,
+			&project.BeforeDeployScript,
 			&project.AfterDeployScriptMode,
 			&project.AfterDeployScript,
 			&project.RsyncOption,
+			&project.Variables,
+			&project.Pipeline,
+			&project.StageTimeout,
+			&project.TransferMode,
+			&project.ArtifactMode,
+			&project.ArtifactPath,
+			&project.DeployStrategy,
+			&project.BatchSize,
+			&project.CanaryConfirm,
+			&project.AutoRollback,
+			&project.RetainCount,
+			&project.RetainDays,
+			&project.PinnedReleases,
+			&project.RequeueInterrupted,
+			&project.ApprovalCount,
+			&project.ApprovalRole,
+			&project.TagPattern,
 			&project.AutoDeploy,
 			&project.DeployState,
 			&project.NotifyType,
@@ -386,7 +604,7 @@ func (p Project) GetData() (Project, error) {
 func (p Project) GetDataByName() (Project, error) {
 	var project Project
 	err := sq.
-		Select("id, namespace_id, name, url, path, symlink_path, environment, branch, after_pull_script_mode, after_pull_script, after_deploy_script_mode, after_deploy_script, rsync_option, auto_deploy, deploy_state, notify_type, notify_target, insert_time, update_time").
+		Select("id, namespace_id, name, url, path, symlink_path, environment, branch, before_pull_script_mode, before_pull_script, after_pull_script_mode, after_pull_script, before_deploy_script_mode, before_deploy_script, after_deploy_script_mode, after_deploy_script, rsync_option, variables, pipeline, stage_timeout, transfer_mode, artifact_mode, artifact_path, deploy_strategy, batch_size, canary_confirm, auto_rollback, retain_count, retain_days, pinned_releases, requeue_interrupted, approval_count, approval_role, tag_pattern, auto_deploy, deploy_state, notify_type, notify_target, insert_time, update_time").
 		From(projectTable).
 		Where(sq.Eq{"name": p.Name}).
 		RunWith(DB).
@@ -400,11 +618,32 @@ func (p Project) GetDataByName() (Project, error) {
 			&project.SymlinkPath,
 			&project.Environment,
 			&project.Branch,
+			&project.BeforePullScriptMode,
+			&project.BeforePullScript,
 			&project.AfterPullScriptMode,
 			&project.AfterPullScript,
+			&project.BeforeDeployScriptMode,
+			&project.BeforeDeployScript,
 			&project.AfterDeployScriptMode,
 			&project.AfterDeployScript,
 			&project.RsyncOption,
+			&project.Variables,
+			&project.Pipeline,
+			&project.StageTimeout,
+			&project.TransferMode,
+			&project.ArtifactMode,
+			&project.ArtifactPath,
+			&project.DeployStrategy,
+			&project.BatchSize,
+			&project.CanaryConfirm,
+			&project.AutoRollback,
+			&project.RetainCount,
+			&project.RetainDays,
+			&project.PinnedReleases,
+			&project.RequeueInterrupted,
+			&project.ApprovalCount,
+			&project.ApprovalRole,
+			&project.TagPattern,
 			&project.AutoDeploy,
 			&project.DeployState,
 			&project.NotifyType,
@@ -417,6 +656,65 @@ func (p Project) GetDataByName() (Project, error) {
 	return project, nil
 }
 
+// GetPipeline return the stages in order
+func (p Project) GetPipeline() []string {
+	if len(p.Pipeline) == 0 {
+		return DefaultPipeline
+	}
+	return strings.Split(p.Pipeline, ",")
+}
+
+// GetStageTimeout return the timeout of the pipeline stage, 0 means no timeout
+func (p Project) GetStageTimeout(stage string) time.Duration {
+	for _, item := range strings.Split(p.StageTimeout, ",") {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != stage {
+			continue
+		}
+		second, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || second <= 0 {
+			return 0
+		}
+		return time.Duration(second) * time.Second
+	}
+	return 0
+}
+
+// GetPinnedReleases return the release tokens which never be pruned
+func (p Project) GetPinnedReleases() []string {
+	var tokens []string
+	for _, token := range strings.Split(p.PinnedReleases, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// GetWebhookSecret -
+func (p Project) GetWebhookSecret() (string, error) {
+	var webhookSecret string
+	err := sq.
+		Select("webhook_secret").
+		From(projectTable).
+		Where(sq.Eq{"id": p.ID}).
+		RunWith(DB).
+		QueryRow().
+		Scan(&webhookSecret)
+	return webhookSecret, err
+}
+
+// EditWebhookSecret -
+func (p Project) EditWebhookSecret() error {
+	_, err := sq.
+		Update(projectTable).
+		Set("webhook_secret", p.WebhookSecret).
+		Where(sq.Eq{"id": p.ID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
 // GetUserProjectData -
 func (p Project) GetUserProjectData() (Project, error) {
 	var project Project
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetDeployingList(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	DB = db
+
+	mock.ExpectQuery("SELECT id, name, last_publish_token, publisher_id, publisher_name, deploy_owner, deploy_heartbeat, requeue_interrupted FROM `project` WHERE deploy_state = ?").
+		WithArgs(ProjectDeploying).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "last_publish_token", "publisher_id", "publisher_name", "deploy_owner", "deploy_heartbeat", "requeue_interrupted"}).
+			AddRow(1, "goploy", "token", 2, "alice", "host-uuid", 1600000000, Enable))
+
+	projects, err := Project{}.GetDeployingList()
+	if err != nil {
+		t.Fatalf("GetDeployingList() error = %v", err)
+	}
+	if len(projects) != 1 {
+		t.Fatalf("GetDeployingList() = %v, want 1 project", projects)
+	}
+	if p := projects[0]; p.ID != 1 || p.DeployOwner != "host-uuid" || p.DeployHeartbeat != 1600000000 || p.RequeueInterrupted != Enable {
+		t.Errorf("GetDeployingList() = %+v", p)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	DB = db
+
+	project := Project{ID: 1, PublisherID: 2, PublisherName: "alice", DeployState: ProjectDeploying, LastPublishToken: "token", DeployOwner: "host-uuid"}
+	query := "UPDATE `project` SET deploy_heartbeat = ?, deploy_owner = ?, deploy_state = ?, last_publish_token = ?, publisher_id = ?, publisher_name = ? WHERE id = ? AND deploy_state <> ?"
+	tests := []struct {
+		name     string
+		affected int64
+		want     bool
+	}{
+		{name: "the project is claimed", affected: 1, want: true},
+		{name: "another deploy has claimed the project", affected: 0, want: false},
+	}
+	for _, tt := range tests {
+		mock.ExpectExec(query).
+			WithArgs(sqlmock.AnyArg(), "host-uuid", ProjectDeploying, "token", 2, "alice", 1, ProjectDeploying).
+			WillReturnResult(sqlmock.NewResult(0, tt.affected))
+		claimed, err := project.Publish()
+		if err != nil {
+			t.Fatalf("%s: Publish() error = %v", tt.name, err)
+		}
+		if claimed != tt.want {
+			t.Errorf("%s: Publish() = %v, want %v", tt.name, claimed, tt.want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
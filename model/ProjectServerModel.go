@@ -14,6 +14,7 @@ type ProjectServer struct {
 	ServerPort        int64  `json:"serverPort"`
 	ServerOwner       string `json:"serverOwner"`
 	ServerDescription string `json:"serverDescription"`
+	Variables         string `json:"variables"`
 	InsertTime        string `json:"insertTime"`
 	UpdateTime        string `json:"updateTime"`
 }
@@ -24,7 +25,7 @@ type ProjectServers []ProjectServer
 // GetBindServerListByProjectID -
 func (ps ProjectServer) GetBindServerListByProjectID() (ProjectServers, error) {
 	rows, err := sq.
-		Select("project_server.id, project_id, server_id, server.name, server.ip, server.port, server.owner, server.description, project_server.insert_time, project_server.update_time").
+		Select("project_server.id, project_id, server_id, server.name, server.ip, server.port, server.owner, server.description, project_server.variables, project_server.insert_time, project_server.update_time").
 		From(projectServerTable).
 		LeftJoin(serverTable + " ON project_server.server_id = server.id").
 		Where(sq.Eq{"project_id": ps.ProjectID}).
@@ -47,6 +48,7 @@ func (ps ProjectServer) GetBindServerListByProjectID() (ProjectServers, error) {
 			&projectServer.ServerPort,
 			&projectServer.ServerOwner,
 			&projectServer.ServerDescription,
+			&projectServer.Variables,
 			&projectServer.InsertTime,
 			&projectServer.UpdateTime); err != nil {
 			return nil, err
@@ -56,23 +58,57 @@ func (ps ProjectServer) GetBindServerListByProjectID() (ProjectServers, error) {
 	return projectServers, nil
 }
 
+// GetData -
+func (ps ProjectServer) GetData() (ProjectServer, error) {
+	var projectServer ProjectServer
+	err := sq.
+		Select("id, project_id, server_id, variables, insert_time, update_time").
+		From(projectServerTable).
+		Where(sq.Eq{"id": ps.ID}).
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&projectServer.ID,
+			&projectServer.ProjectID,
+			&projectServer.ServerID,
+			&projectServer.Variables,
+			&projectServer.InsertTime,
+			&projectServer.UpdateTime)
+	return projectServer, err
+}
+
 // AddMany -
 func (ps ProjectServers) AddMany() error {
 	if len(ps) == 0 {
 		return nil
 	}
 
+	// keep the variables of the server which has been bound
 	builder := sq.
-		Replace(projectServerTable).
-		Columns("project_id", "server_id")
+		Insert(projectServerTable).
+		Columns("project_id", "server_id", "variables").
+		Suffix("ON DUPLICATE KEY UPDATE project_id = VALUES(project_id)")
 
 	for _, row := range ps {
-		builder = builder.Values(row.ProjectID, row.ServerID)
+		builder = builder.Values(row.ProjectID, row.ServerID, row.Variables)
 	}
 	_, err := builder.RunWith(DB).Exec()
 	return err
 }
 
+// EditVariables -
+func (ps ProjectServer) EditVariables() error {
+	_, err := sq.
+		Update(projectServerTable).
+		SetMap(sq.Eq{
+			"variables": ps.Variables,
+		}).
+		Where(sq.Eq{"id": ps.ID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
 // DeleteRow -
 func (ps ProjectServer) DeleteRow() error {
 	_, err := sq.
@@ -1,6 +1,9 @@
 package model
 
-import sq "github.com/Masterminds/squirrel"
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/zhenorzz/goploy/utils"
+)
 
 const publishTraceTable = "`publish_trace`"
 
@@ -15,6 +18,7 @@ type PublishTrace struct {
 	PublisherID   int64  `json:"publisherId"`
 	PublisherName string `json:"publisherName"`
 	Type          int    `json:"type"`
+	Batch         int    `json:"batch"`
 	Ext           string `json:"ext"`
 	PublishState  int    `json:"publishState"`
 	InsertTime    string `json:"insertTime"`
@@ -24,6 +28,12 @@ type PublishTrace struct {
 // PublishTraces -
 type PublishTraces []PublishTrace
 
+// publish trace state besides Fail and Success
+const (
+	Cancel  = 2
+	Timeout = 3
+)
+
 // publish trace state
 const (
 	BeforePull = 1
@@ -37,14 +47,24 @@ const (
 	Deploy = 5
 
 	AfterDeploy = 6
+
+	Clean = 7
+
+	Rollback = 8
+
+	SwitchRelease = 9
+
+	PackArtifact = 10
+
+	HealthCheck = 11
 )
 
-// AddRow return LastInsertId
+// AddRow return LastInsertId, the secrets in detail and ext are masked
 func (pt PublishTrace) AddRow() (int64, error) {
 	result, err := sq.
 		Insert(publishTraceTable).
-		Columns("token", "project_id", "project_name", "detail", "state", "publisher_id", "publisher_name", "type", "ext").
-		Values(pt.Token, pt.ProjectID, pt.ProjectName, pt.Detail, pt.State, pt.PublisherID, pt.PublisherName, pt.Type, pt.Ext).
+		Columns("token", "project_id", "project_name", "detail", "state", "publisher_id", "publisher_name", "type", "batch", "ext").
+		Values(pt.Token, pt.ProjectID, pt.ProjectName, utils.Mask(pt.Detail), pt.State, pt.PublisherID, pt.PublisherName, pt.Type, pt.Batch, utils.Mask(pt.Ext)).
 		RunWith(DB).
 		Exec()
 
@@ -59,7 +79,7 @@ func (pt PublishTrace) AddRow() (int64, error) {
 // GetListByToken -
 func (pt PublishTrace) GetListByToken() (PublishTraces, error) {
 	rows, err := sq.
-		Select("id, token, project_id, project_name, detail, state, publisher_id, publisher_name, type, ext, insert_time, update_time").
+		Select("id, token, project_id, project_name, detail, state, publisher_id, publisher_name, type, batch, ext, insert_time, update_time").
 		From(publishTraceTable).
 		Where(sq.Eq{"token": pt.Token}).
 		RunWith(DB).
@@ -81,6 +101,7 @@ func (pt PublishTrace) GetListByToken() (PublishTraces, error) {
 			&publishTrace.PublisherID,
 			&publishTrace.PublisherName,
 			&publishTrace.Type,
+			&publishTrace.Batch,
 			&publishTrace.Ext,
 			&publishTrace.InsertTime,
 			&publishTrace.UpdateTime); err != nil {
@@ -91,6 +112,26 @@ func (pt PublishTrace) GetListByToken() (PublishTraces, error) {
 	return publishTraces, nil
 }
 
+// GetPreviousToken return the token of the last successful deploy before pt.Token
+func (pt PublishTrace) GetPreviousToken() (string, error) {
+	var token string
+	err := sq.
+		Select("token").
+		From(publishTraceTable).
+		Where(sq.Eq{"project_id": pt.ProjectID, "type": Deploy}).
+		Where(sq.NotEq{"token": pt.Token}).
+		Where("!EXISTS (SELECT id FROM " + publishTraceTable + " AS pt where pt.state != 1 AND pt.token = publish_trace.token)").
+		OrderBy("id DESC").
+		Limit(1).
+		RunWith(DB).
+		QueryRow().
+		Scan(&token)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
 // GetPreview -
 func (pt PublishTrace) GetPreview(pagination Pagination) (PublishTraces, Pagination, error) {
 	builder := sq.
@@ -0,0 +1,142 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const secretTable = "`secret`"
+
+// Secret the encrypted value is never sent to the client
+type Secret struct {
+	ID          int64  `json:"id"`
+	NamespaceID int64  `json:"namespaceId"`
+	ProjectID   int64  `json:"projectId"`
+	Name        string `json:"name"`
+	Value       string `json:"-"`
+	Description string `json:"description"`
+	CreatorID   int64  `json:"creatorId"`
+	Creator     string `json:"creator"`
+	EditorID    int64  `json:"editorId"`
+	Editor      string `json:"editor"`
+	InsertTime  string `json:"insertTime"`
+	UpdateTime  string `json:"updateTime"`
+}
+
+// Secrets -
+type Secrets []Secret
+
+// GetList the secrets of the namespace, filter by project id when it is set
+func (s Secret) GetList() (Secrets, error) {
+	builder := sq.
+		Select("id, namespace_id, project_id, name, description, creator_id, creator, editor_id, editor, insert_time, update_time").
+		From(secretTable).
+		Where(sq.Eq{"namespace_id": s.NamespaceID})
+	if s.ProjectID != 0 {
+		builder = builder.Where(sq.Eq{"project_id": s.ProjectID})
+	}
+	rows, err := builder.
+		OrderBy("project_id ASC, name ASC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	secrets := Secrets{}
+	for rows.Next() {
+		var secret Secret
+		if err := rows.Scan(
+			&secret.ID,
+			&secret.NamespaceID,
+			&secret.ProjectID,
+			&secret.Name,
+			&secret.Description,
+			&secret.CreatorID,
+			&secret.Creator,
+			&secret.EditorID,
+			&secret.Editor,
+			&secret.InsertTime,
+			&secret.UpdateTime,
+		); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// GetScopeList the secrets which the project can use, the namespace secrets go first,
+// so the project secret of the same name overrides it
+func (s Secret) GetScopeList() (Secrets, error) {
+	rows, err := sq.
+		Select("id, namespace_id, project_id, name, value").
+		From(secretTable).
+		Where(sq.Eq{
+			"namespace_id": s.NamespaceID,
+			"project_id":   []int64{0, s.ProjectID},
+		}).
+		OrderBy("project_id ASC, name ASC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	secrets := Secrets{}
+	for rows.Next() {
+		var secret Secret
+		if err := rows.Scan(
+			&secret.ID,
+			&secret.NamespaceID,
+			&secret.ProjectID,
+			&secret.Name,
+			&secret.Value,
+		); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// AddRow return LastInsertId
+func (s Secret) AddRow() (int64, error) {
+	result, err := sq.
+		Insert(secretTable).
+		Columns("namespace_id", "project_id", "name", "value", "description", "creator_id", "creator", "editor_id", "editor").
+		Values(s.NamespaceID, s.ProjectID, s.Name, s.Value, s.Description, s.CreatorID, s.Creator, s.CreatorID, s.Creator).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, err
+}
+
+// EditRow the value is kept when it is empty
+func (s Secret) EditRow() error {
+	setMap := sq.Eq{
+		"description": s.Description,
+		"editor_id":   s.EditorID,
+		"editor":      s.Editor,
+	}
+	if len(s.Value) != 0 {
+		setMap["value"] = s.Value
+	}
+	_, err := sq.
+		Update(secretTable).
+		SetMap(setMap).
+		Where(sq.Eq{"id": s.ID, "namespace_id": s.NamespaceID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteRow -
+func (s Secret) DeleteRow() error {
+	_, err := sq.
+		Delete(secretTable).
+		Where(sq.Eq{"id": s.ID, "namespace_id": s.NamespaceID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
@@ -237,11 +237,12 @@ func (u User) Validate(inputPassword string) error {
 	return nil
 }
 
-// CreateToken -
-func (u User) CreateToken() (string, error) {
+// CreateToken sign the jwt of the login session
+func (u User) CreateToken(sessionID string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   u.ID,
 		"name": u.Name,
+		"sid":  sessionID,
 		"exp":  time.Now().Add(time.Hour * 24).Unix(),
 		"nbf":  time.Now().Unix(),
 	})
@@ -0,0 +1,70 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const userRecoveryCodeTable = "`user_recovery_code`"
+
+// UserRecoveryCode the one-time code to pass the two-factor authentication without the TOTP device,
+// only the sha256 of the code is stored
+type UserRecoveryCode struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"userId"`
+	CodeHash   string `json:"-"`
+	UsedTime   string `json:"usedTime"`
+	InsertTime string `json:"insertTime"`
+}
+
+// ReplaceByUserID drop the old codes of the user and save the new code hashes
+func (urc UserRecoveryCode) ReplaceByUserID(codeHashes []string) error {
+	if err := urc.DeleteByUserID(); err != nil {
+		return err
+	}
+	builder := sq.
+		Insert(userRecoveryCodeTable).
+		Columns("user_id", "code_hash")
+	for _, codeHash := range codeHashes {
+		builder = builder.Values(urc.UserID, codeHash)
+	}
+	_, err := builder.RunWith(DB).Exec()
+	return err
+}
+
+// Use mark the unused code as used, return false if the code does not exist or has been used
+func (urc UserRecoveryCode) Use() (bool, error) {
+	result, err := sq.
+		Update(userRecoveryCodeTable).
+		Set("used_time", sq.Expr("NOW()")).
+		Where(sq.Eq{"user_id": urc.UserID, "code_hash": urc.CodeHash, "used_time": nil}).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// CountUnused -
+func (urc UserRecoveryCode) CountUnused() (int64, error) {
+	var count int64
+	err := sq.
+		Select("COUNT(*)").
+		From(userRecoveryCodeTable).
+		Where(sq.Eq{"user_id": urc.UserID, "used_time": nil}).
+		RunWith(DB).
+		QueryRow().
+		Scan(&count)
+	return count, err
+}
+
+// DeleteByUserID -
+func (urc UserRecoveryCode) DeleteByUserID() error {
+	_, err := sq.
+		Delete(userRecoveryCodeTable).
+		Where(sq.Eq{"user_id": urc.UserID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const userSessionTable = "`user_session`"
+
+// UserSession the login session of the user, the session id is carried by the jwt
+type UserSession struct {
+	ID             int64  `json:"id"`
+	UserID         int64  `json:"userId"`
+	SessionID      string `json:"-"`
+	IP             string `json:"ip"`
+	UserAgent      string `json:"userAgent"`
+	LastActiveTime string `json:"lastActiveTime"`
+	ExpireTime     string `json:"expireTime"`
+	InsertTime     string `json:"insertTime"`
+	// Current the session of the request
+	Current bool `json:"current"`
+}
+
+// UserSessions -
+type UserSessions []UserSession
+
+// GetListByUserID the sessions of the user which are not expired
+func (us UserSession) GetListByUserID() (UserSessions, error) {
+	rows, err := sq.
+		Select("id, user_id, session_id, ip, user_agent, last_active_time, expire_time, insert_time").
+		From(userSessionTable).
+		Where(sq.Eq{"user_id": us.UserID}).
+		Where("expire_time > NOW()").
+		OrderBy("last_active_time DESC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	userSessions := UserSessions{}
+	for rows.Next() {
+		var userSession UserSession
+		if err := rows.Scan(
+			&userSession.ID,
+			&userSession.UserID,
+			&userSession.SessionID,
+			&userSession.IP,
+			&userSession.UserAgent,
+			&userSession.LastActiveTime,
+			&userSession.ExpireTime,
+			&userSession.InsertTime,
+		); err != nil {
+			return nil, err
+		}
+		userSessions = append(userSessions, userSession)
+	}
+	return userSessions, nil
+}
+
+// GetDataBySessionID the session which is not expired
+func (us UserSession) GetDataBySessionID() (UserSession, error) {
+	var userSession UserSession
+	err := sq.
+		Select("id, user_id, session_id, ip, user_agent, last_active_time, expire_time, insert_time").
+		From(userSessionTable).
+		Where(sq.Eq{"session_id": us.SessionID}).
+		Where("expire_time > NOW()").
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&userSession.ID,
+			&userSession.UserID,
+			&userSession.SessionID,
+			&userSession.IP,
+			&userSession.UserAgent,
+			&userSession.LastActiveTime,
+			&userSession.ExpireTime,
+			&userSession.InsertTime,
+		)
+	if err != nil {
+		return userSession, err
+	}
+	return userSession, nil
+}
+
+// AddRow return LastInsertId
+func (us UserSession) AddRow() (int64, error) {
+	result, err := sq.
+		Insert(userSessionTable).
+		Columns("user_id", "session_id", "ip", "user_agent", "last_active_time", "expire_time").
+		Values(us.UserID, us.SessionID, us.IP, us.UserAgent, sq.Expr("NOW()"), us.ExpireTime).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, err
+}
+
+// Touch record the activity and extend the expire time
+func (us UserSession) Touch() error {
+	_, err := sq.
+		Update(userSessionTable).
+		Set("last_active_time", sq.Expr("NOW()")).
+		Set("expire_time", us.ExpireTime).
+		Set("ip", us.IP).
+		Where(sq.Eq{"session_id": us.SessionID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteRow -
+func (us UserSession) DeleteRow() error {
+	_, err := sq.
+		Delete(userSessionTable).
+		Where(sq.Eq{"id": us.ID, "user_id": us.UserID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteBySessionID -
+func (us UserSession) DeleteBySessionID() error {
+	_, err := sq.
+		Delete(userSessionTable).
+		Where(sq.Eq{"session_id": us.SessionID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteByUserID delete the sessions of the user except the session id
+func (us UserSession) DeleteByUserID() error {
+	_, err := sq.
+		Delete(userSessionTable).
+		Where(sq.Eq{"user_id": us.UserID}).
+		Where(sq.NotEq{"session_id": us.SessionID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteExpired -
+func (us UserSession) DeleteExpired() error {
+	_, err := sq.
+		Delete(userSessionTable).
+		Where("expire_time <= NOW()").
+		RunWith(DB).
+		Exec()
+	return err
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const userTokenTable = "`user_token`"
+
+// user token scope
+const (
+	UserTokenRead = iota + 1
+	UserTokenPublish
+)
+
+// UserToken the personal api token of the user, scoped to one namespace,
+// only the sha256 of the token is stored
+type UserToken struct {
+	ID          int64  `json:"id"`
+	UserID      int64  `json:"userId"`
+	NamespaceID int64  `json:"namespaceId"`
+	Name        string `json:"name"`
+	TokenHash   string `json:"-"`
+	TokenPrefix string `json:"tokenPrefix"`
+	Scope       uint8  `json:"scope"`
+	// ProjectIDs comma separated, empty means all projects in the namespace
+	ProjectIDs   string `json:"projectIds"`
+	ExpireTime   string `json:"expireTime"`
+	LastUsedTime string `json:"lastUsedTime"`
+	LastUsedIP   string `json:"lastUsedIp"`
+	InsertTime   string `json:"insertTime"`
+	UpdateTime   string `json:"updateTime"`
+}
+
+// UserTokens -
+type UserTokens []UserToken
+
+// GetListByUserID the tokens of the user in the namespace
+func (ut UserToken) GetListByUserID() (UserTokens, error) {
+	rows, err := sq.
+		Select("id, user_id, namespace_id, name, token_prefix, scope, project_ids, IFNULL(expire_time, ''), IFNULL(last_used_time, ''), last_used_ip, insert_time, update_time").
+		From(userTokenTable).
+		Where(sq.Eq{"user_id": ut.UserID, "namespace_id": ut.NamespaceID}).
+		OrderBy("id DESC").
+		RunWith(DB).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	userTokens := UserTokens{}
+	for rows.Next() {
+		var userToken UserToken
+		if err := rows.Scan(
+			&userToken.ID,
+			&userToken.UserID,
+			&userToken.NamespaceID,
+			&userToken.Name,
+			&userToken.TokenPrefix,
+			&userToken.Scope,
+			&userToken.ProjectIDs,
+			&userToken.ExpireTime,
+			&userToken.LastUsedTime,
+			&userToken.LastUsedIP,
+			&userToken.InsertTime,
+			&userToken.UpdateTime,
+		); err != nil {
+			return nil, err
+		}
+		userTokens = append(userTokens, userToken)
+	}
+	return userTokens, nil
+}
+
+// GetDataByHash the token which is not expired
+func (ut UserToken) GetDataByHash() (UserToken, error) {
+	var userToken UserToken
+	err := sq.
+		Select("id, user_id, namespace_id, name, token_prefix, scope, project_ids").
+		From(userTokenTable).
+		Where(sq.Eq{"token_hash": ut.TokenHash}).
+		Where("(expire_time IS NULL OR expire_time > NOW())").
+		RunWith(DB).
+		QueryRow().
+		Scan(
+			&userToken.ID,
+			&userToken.UserID,
+			&userToken.NamespaceID,
+			&userToken.Name,
+			&userToken.TokenPrefix,
+			&userToken.Scope,
+			&userToken.ProjectIDs,
+		)
+	return userToken, err
+}
+
+// AddRow return LastInsertId
+func (ut UserToken) AddRow() (int64, error) {
+	result, err := sq.
+		Insert(userTokenTable).
+		Columns("user_id", "namespace_id", "name", "token_hash", "token_prefix", "scope", "project_ids", "expire_time").
+		Values(ut.UserID, ut.NamespaceID, ut.Name, ut.TokenHash, ut.TokenPrefix, ut.Scope, ut.ProjectIDs, nullTime(ut.ExpireTime)).
+		RunWith(DB).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, err
+}
+
+// EditRow the token itself is not changed
+func (ut UserToken) EditRow() error {
+	_, err := sq.
+		Update(userTokenTable).
+		SetMap(sq.Eq{
+			"name":        ut.Name,
+			"scope":       ut.Scope,
+			"project_ids": ut.ProjectIDs,
+			"expire_time": nullTime(ut.ExpireTime),
+		}).
+		Where(sq.Eq{"id": ut.ID, "user_id": ut.UserID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// Touch record the last used time and ip
+func (ut UserToken) Touch() error {
+	_, err := sq.
+		Update(userTokenTable).
+		Set("last_used_time", sq.Expr("NOW()")).
+		Set("last_used_ip", ut.LastUsedIP).
+		Where(sq.Eq{"id": ut.ID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteRow revoke the token
+func (ut UserToken) DeleteRow() error {
+	_, err := sq.
+		Delete(userTokenTable).
+		Where(sq.Eq{"id": ut.ID, "user_id": ut.UserID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// AllowProject report whether the token can access the project
+func (ut UserToken) AllowProject(projectID int64) bool {
+	if len(ut.ProjectIDs) == 0 {
+		return true
+	}
+	for _, id := range strings.Split(ut.ProjectIDs, ",") {
+		if id == strconv.FormatInt(projectID, 10) {
+			return true
+		}
+	}
+	return false
+}
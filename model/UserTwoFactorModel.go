@@ -0,0 +1,66 @@
+package model
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+const userTwoFactorTable = "`user_two_factor`"
+
+// UserTwoFactor the TOTP secret of the user, the secret is encrypted by SECRET_KEY
+type UserTwoFactor struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"userId"`
+	Secret     string `json:"-"`
+	Enabled    uint8  `json:"enabled"`
+	InsertTime string `json:"insertTime"`
+	UpdateTime string `json:"updateTime"`
+}
+
+// GetDataByUserID -
+func (utf UserTwoFactor) GetDataByUserID() (UserTwoFactor, error) {
+	var userTwoFactor UserTwoFactor
+	err := sq.
+		Select("id, user_id, secret, enabled, insert_time, update_time").
+		From(userTwoFactorTable).
+		Where(sq.Eq{"user_id": utf.UserID}).
+		RunWith(DB).
+		QueryRow().
+		Scan(&userTwoFactor.ID, &userTwoFactor.UserID, &userTwoFactor.Secret, &userTwoFactor.Enabled, &userTwoFactor.InsertTime, &userTwoFactor.UpdateTime)
+	if err != nil {
+		return userTwoFactor, err
+	}
+	return userTwoFactor, nil
+}
+
+// AddOrUpdate save the secret waiting for the first code, the enabled secret is not replaced
+func (utf UserTwoFactor) AddOrUpdate() error {
+	_, err := sq.
+		Insert(userTwoFactorTable).
+		Columns("user_id", "secret", "enabled").
+		Values(utf.UserID, utf.Secret, Disable).
+		Suffix("ON DUPLICATE KEY UPDATE secret = IF(enabled = 1, secret, VALUES(secret))").
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// Enable -
+func (utf UserTwoFactor) Enable() error {
+	_, err := sq.
+		Update(userTwoFactorTable).
+		Set("enabled", Enable).
+		Where(sq.Eq{"user_id": utf.UserID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
+
+// DeleteByUserID -
+func (utf UserTwoFactor) DeleteByUserID() error {
+	_, err := sq.
+		Delete(userTwoFactorTable).
+		Where(sq.Eq{"user_id": utf.UserID}).
+		RunWith(DB).
+		Exec()
+	return err
+}
@@ -14,15 +14,21 @@ func Init() *router.Router {
 	// rt.Middleware(example)
 	// no need to check login
 	rt.RegisterWhiteList(map[string]struct{}{
-		"/user/login":        {},
-		"/user/isShowPhrase": {},
-		"/deploy/webhook":    {},
+		"/user/login":                 {},
+		"/user/isShowPhrase":          {},
+		"/user/oidc/login":            {},
+		"/user/oidc/callback":         {},
+		"/user/twoFactor/loginEnroll": {},
+		"/user/twoFactor/loginVerify": {},
+		"/deploy/webhook":             {},
 	})
 	// websocket route
 	rt.Add("/ws/connect", router.GET, ws.GetHub().Connect)
 
 	// user route
 	rt.Add("/user/login", router.POST, controller.User{}.Login)
+	rt.Add("/user/oidc/login", router.GET, controller.User{}.OIDCLogin)
+	rt.Add("/user/oidc/callback", router.GET, controller.User{}.OIDCCallback)
 	rt.Add("/user/info", router.GET, controller.User{}.Info)
 	rt.Add("/user/getList", router.GET, controller.User{}.GetList)
 	rt.Add("/user/getTotal", router.GET, controller.User{}.GetTotal)
@@ -31,6 +37,22 @@ func Init() *router.Router {
 	rt.Add("/user/edit", router.POST, controller.User{}.Edit).Role(core.RoleAdmin)
 	rt.Add("/user/remove", router.DELETE, controller.User{}.Remove).Role(core.RoleAdmin)
 	rt.Add("/user/changePassword", router.POST, controller.User{}.ChangePassword)
+	rt.Add("/user/token/getList", router.GET, controller.User{}.GetTokenList)
+	rt.Add("/user/token/add", router.POST, controller.User{}.AddToken)
+	rt.Add("/user/token/edit", router.POST, controller.User{}.EditToken)
+	rt.Add("/user/token/remove", router.DELETE, controller.User{}.RemoveToken)
+	rt.Add("/user/logout", router.POST, controller.User{}.Logout)
+	rt.Add("/user/session/getList", router.GET, controller.User{}.GetSessionList)
+	rt.Add("/user/session/remove", router.DELETE, controller.User{}.RemoveSession)
+	rt.Add("/user/session/removeAll", router.POST, controller.User{}.RemoveAllSessions)
+	rt.Add("/user/twoFactor/loginEnroll", router.POST, controller.User{}.LoginEnroll)
+	rt.Add("/user/twoFactor/loginVerify", router.POST, controller.User{}.LoginVerify)
+	rt.Add("/user/twoFactor/get", router.GET, controller.User{}.GetTwoFactor)
+	rt.Add("/user/twoFactor/enroll", router.POST, controller.User{}.EnrollTwoFactor)
+	rt.Add("/user/twoFactor/enable", router.POST, controller.User{}.EnableTwoFactor)
+	rt.Add("/user/twoFactor/disable", router.POST, controller.User{}.DisableTwoFactor)
+	rt.Add("/user/twoFactor/generateRecoveryCodes", router.POST, controller.User{}.GenerateRecoveryCodes)
+	rt.Add("/user/twoFactor/reset", router.POST, controller.User{}.ResetTwoFactor).Role(core.RoleAdmin)
 
 	// namespace route
 	rt.Add("/namespace/getList", router.GET, controller.Namespace{}.GetList)
@@ -41,18 +63,26 @@ func Init() *router.Router {
 	rt.Add("/namespace/edit", router.POST, controller.Namespace{}.Edit).Roles([]string{core.RoleAdmin, core.RoleManager})
 	rt.Add("/namespace/addUser", router.POST, controller.Namespace{}.AddUser).Roles([]string{core.RoleAdmin, core.RoleManager})
 	rt.Add("/namespace/removeUser", router.DELETE, controller.Namespace{}.RemoveUser).Roles([]string{core.RoleAdmin, core.RoleManager})
+	rt.Add("/namespace/getEnvironmentApprovalList", router.GET, controller.Namespace{}.GetEnvironmentApprovalList)
+	rt.Add("/namespace/editEnvironmentApproval", router.POST, controller.Namespace{}.EditEnvironmentApproval).Roles([]string{core.RoleAdmin, core.RoleManager})
+	rt.Add("/namespace/removeEnvironmentApproval", router.DELETE, controller.Namespace{}.RemoveEnvironmentApproval).Roles([]string{core.RoleAdmin, core.RoleManager})
+	rt.Add("/namespace/editTwoFactorRole", router.POST, controller.Namespace{}.EditTwoFactorRole).Role(core.RoleAdmin)
 
 	// project route
 	rt.Add("/project/getList", router.GET, controller.Project{}.GetList)
 	rt.Add("/project/getTotal", router.GET, controller.Project{}.GetTotal)
 	rt.Add("/project/getRemoteBranchList", router.GET, controller.Project{}.GetRemoteBranchList)
+	rt.Add("/project/getRemoteTagList", router.GET, controller.Project{}.GetRemoteTagList)
 	rt.Add("/project/getBindServerList", router.GET, controller.Project{}.GetBindServerList)
 	rt.Add("/project/getBindUserList", router.GET, controller.Project{}.GetBindUserList)
 	rt.Add("/project/add", router.POST, controller.Project{}.Add).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/edit", router.POST, controller.Project{}.Edit).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/setAutoDeploy", router.POST, controller.Project{}.SetAutoDeploy).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/getWebhookInfo", router.GET, controller.Project{}.GetWebhookInfo).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/generateWebhookSecret", router.POST, controller.Project{}.GenerateWebhookSecret).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/remove", router.DELETE, controller.Project{}.Remove).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/addServer", router.POST, controller.Project{}.AddServer).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/editServerVariables", router.POST, controller.Project{}.EditServerVariables).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/addUser", router.POST, controller.Project{}.AddUser).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/removeServer", router.DELETE, controller.Project{}.RemoveServer).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/removeUser", router.DELETE, controller.Project{}.RemoveUser).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
@@ -60,6 +90,28 @@ func Init() *router.Router {
 	rt.Add("/project/editTask", router.POST, controller.Project{}.EditTask).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/removeTask", router.POST, controller.Project{}.RemoveTask).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
 	rt.Add("/project/getTaskList", router.GET, controller.Project{}.GetTaskList).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/getConfigTemplateList", router.GET, controller.Project{}.GetConfigTemplateList).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/addConfigTemplate", router.POST, controller.Project{}.AddConfigTemplate).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/editConfigTemplate", router.POST, controller.Project{}.EditConfigTemplate).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/removeConfigTemplate", router.DELETE, controller.Project{}.RemoveConfigTemplate).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/previewConfigTemplate", router.GET, controller.Project{}.PreviewConfigTemplate).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/getHealthCheckList", router.GET, controller.Project{}.GetHealthCheckList).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/addHealthCheck", router.POST, controller.Project{}.AddHealthCheck).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/editHealthCheck", router.POST, controller.Project{}.EditHealthCheck).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+	rt.Add("/project/removeHealthCheck", router.DELETE, controller.Project{}.RemoveHealthCheck).Roles([]string{core.RoleAdmin, core.RoleManager, core.RoleGroupManager})
+
+	// secret route
+	rt.Add("/secret/getList", router.GET, controller.Secret{}.GetList).Roles([]string{core.RoleAdmin, core.RoleManager})
+	rt.Add("/secret/add", router.POST, controller.Secret{}.Add).Roles([]string{core.RoleAdmin, core.RoleManager})
+	rt.Add("/secret/edit", router.POST, controller.Secret{}.Edit).Roles([]string{core.RoleAdmin, core.RoleManager})
+	rt.Add("/secret/remove", router.DELETE, controller.Secret{}.Remove).Roles([]string{core.RoleAdmin, core.RoleManager})
+
+	// freeze route
+	rt.Add("/freeze/getList", router.GET, controller.Freeze{}.GetList)
+	rt.Add("/freeze/getOverrideList", router.GET, controller.Freeze{}.GetOverrideList)
+	rt.Add("/freeze/add", router.POST, controller.Freeze{}.Add).Roles([]string{core.RoleAdmin, core.RoleManager})
+	rt.Add("/freeze/edit", router.POST, controller.Freeze{}.Edit).Roles([]string{core.RoleAdmin, core.RoleManager})
+	rt.Add("/freeze/remove", router.DELETE, controller.Freeze{}.Remove).Roles([]string{core.RoleAdmin, core.RoleManager})
 
 	// monitor route
 	rt.Add("/monitor/getList", router.GET, controller.Monitor{}.GetList)
@@ -76,7 +128,18 @@ func Init() *router.Router {
 	rt.Add("/deploy/getCommitList", router.GET, controller.Deploy{}.GetCommitList)
 	rt.Add("/deploy/getPreview", router.GET, controller.Deploy{}.GetPreview)
 	rt.Add("/deploy/publish", router.POST, controller.Deploy{}.Publish, middleware.HasPublishAuth)
-	rt.Add("/deploy/webhook", router.POST, controller.Deploy{}.Webhook, middleware.FilterEvent)
+	rt.Add("/deploy/cancel", router.POST, controller.Deploy{}.Cancel, middleware.HasPublishAuth)
+	rt.Add("/deploy/canaryConfirm", router.POST, controller.Deploy{}.CanaryConfirm, middleware.HasPublishAuth)
+	rt.Add("/deploy/getReleaseList", router.GET, controller.Deploy{}.GetReleaseList)
+	rt.Add("/deploy/switchRelease", router.POST, controller.Deploy{}.SwitchRelease, middleware.HasPublishAuth)
+	rt.Add("/deploy/pruneRelease", router.POST, controller.Deploy{}.PruneRelease, middleware.HasPublishAuth)
+	rt.Add("/deploy/queue", router.GET, controller.Deploy{}.GetQueueList)
+	rt.Add("/deploy/queue/cancel", router.POST, controller.Deploy{}.CancelQueue)
+	rt.Add("/deploy/queue/approving", router.GET, controller.Deploy{}.GetApprovingList)
+	rt.Add("/deploy/queue/approvals", router.GET, controller.Deploy{}.GetApprovalList)
+	rt.Add("/deploy/approve", router.POST, controller.Deploy{}.Approve)
+	rt.Add("/deploy/reject", router.POST, controller.Deploy{}.Reject)
+	rt.Add("/deploy/webhook", router.POST, controller.Deploy{}.Webhook, middleware.FilterEvent, middleware.VerifyWebhook)
 
 	// server route
 	rt.Add("/server/getList", router.GET, controller.Server{}.GetList)
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
+)
+
+// deployApprovalExpire the deploy expires when it is not approved in time
+const deployApprovalExpire = 24 * time.Hour
+
+// approvalPolicy the project policy first, then the policy of the project environment,
+// 0 approval count means the deploy needs no approval
+func approvalPolicy(project model.Project) (uint16, string, error) {
+	approvalCount, approvalRole := project.ApprovalCount, project.ApprovalRole
+	if approvalCount == 0 {
+		environmentApproval, err := model.EnvironmentApproval{NamespaceID: project.NamespaceID, Environment: project.Environment}.GetDataByEnvironment()
+		if err == sql.ErrNoRows {
+			return 0, "", nil
+		} else if err != nil {
+			return 0, "", err
+		}
+		approvalCount, approvalRole = environmentApproval.ApprovalCount, environmentApproval.ApprovalRole
+	}
+	if len(approvalRole) == 0 {
+		approvalRole = core.RoleManager
+	}
+	return approvalCount, approvalRole, nil
+}
+
+// roleRank the index in core.Roles, the smaller the higher
+func roleRank(role string) int {
+	for i, r := range core.Roles {
+		if r == role {
+			return i
+		}
+	}
+	return len(core.Roles)
+}
+
+// deploySummary resolve the commit to deploy and describe the changes since the last build,
+// the approved deploy is pinned to the resolved commit
+func deploySummary(project model.Project, ref string) (string, string, error) {
+	defer LockRepository(project.ID)()
+	ctx := context.Background()
+	if err := gitFetch(ctx, project); err != nil {
+		return "", "", err
+	}
+	if len(ref) == 0 {
+		ref = project.Branch
+	}
+	commit, err := resolveRef(ctx, project, ref)
+	if err != nil {
+		return "", "", err
+	}
+	git := utils.GIT{Dir: core.RepositoryPath + project.Name}
+	if err := git.Log([]string{"-1", "--pretty=format:%H%n%an: %s", commit}); err != nil {
+		return "", "", errors.New(err.Error() + ", detail: " + git.Err.String())
+	}
+	summary := "ref: " + ref + "\n" + git.Output.String() + "\n"
+	// HEAD of the repository is the commit of the last build
+	if err := git.Run("diff", []string{"--stat", "HEAD", commit}); err != nil {
+		return "", "", errors.New(err.Error() + ", detail: " + git.Err.String())
+	}
+	return commit, summary + git.Output.String(), nil
+}
+
+// requireApproval turn the deploy into an approving one when the project needs approval
+func requireApproval(deployQueue *model.DeployQueue) (bool, error) {
+	project, err := model.Project{ID: deployQueue.ProjectID}.GetData()
+	if err != nil {
+		return false, err
+	}
+	approvalCount, approvalRole, err := approvalPolicy(project)
+	if err != nil || approvalCount == 0 {
+		return false, err
+	}
+	deployQueue.State = model.QueueApproving
+	deployQueue.ApprovalCount = approvalCount
+	deployQueue.ApprovalRole = approvalRole
+	deployQueue.ExpireTime = time.Now().Add(deployApprovalExpire).Format("2006-01-02 15:04:05")
+	ref := deployQueue.Ref
+	if len(deployQueue.CommitID) != 0 {
+		ref = deployQueue.CommitID
+	}
+	commit, summary, err := deploySummary(project, ref)
+	if err != nil {
+		core.Log(core.ERROR, "projectID:"+strconv.FormatInt(project.ID, 10)+" deploy summary fail, "+err.Error())
+		deployQueue.Summary = "summary unavailable, " + err.Error()
+	} else {
+		deployQueue.CommitID = commit
+		deployQueue.Summary = summary
+	}
+	return true, nil
+}
+
+// DecideDeploy record the decision of the approver,
+// the deploy is dispatched once it gets enough approvals and it is rejected by any rejection
+func DecideDeploy(queueID int64, userInfo model.User, namespace model.Namespace, decision uint8, comment string) error {
+	deployQueue, err := model.DeployQueue{ID: queueID}.GetData()
+	if err == sql.ErrNoRows {
+		return errors.New("The deploy is not found")
+	} else if err != nil {
+		return err
+	}
+	project, err := model.Project{ID: deployQueue.ProjectID}.GetData()
+	if err != nil {
+		return err
+	}
+	if project.NamespaceID != namespace.ID {
+		return errors.New("The deploy is not in the namespace")
+	}
+	if deployQueue.State != model.QueueApproving {
+		return errors.New("The deploy is not waiting for approval")
+	}
+	if deployQueue.PublisherID == userInfo.ID {
+		return errors.New("The publisher can not decide on their own deploy")
+	}
+	if roleRank(namespace.Role) > roleRank(deployQueue.ApprovalRole) {
+		return errors.New("The deploy needs the approval of " + deployQueue.ApprovalRole + " or above")
+	}
+
+	added, err := model.DeployApproval{
+		QueueID:  deployQueue.ID,
+		UserID:   userInfo.ID,
+		UserName: userInfo.Name,
+		Decision: decision,
+		Comment:  comment,
+	}.AddRow()
+	if err != nil {
+		return err
+	}
+	if !added {
+		return errors.New("You have decided on the deploy")
+	}
+
+	if decision == model.ApprovalReject {
+		rejected, err := model.DeployQueue{ID: deployQueue.ID, State: model.QueueRejected}.ChangeApprovingState()
+		if err != nil {
+			return err
+		}
+		if !rejected {
+			return errors.New("The deploy is not waiting for approval")
+		}
+		return nil
+	}
+
+	approvedCount, err := model.DeployApproval{QueueID: deployQueue.ID}.CountApproved()
+	if err != nil || approvedCount < deployQueue.ApprovalCount {
+		return err
+	}
+	// the other approver may move it to the queue at the same time
+	if _, err := (model.DeployQueue{ID: deployQueue.ID, State: model.QueueWaiting}).ChangeApprovingState(); err != nil {
+		return err
+	}
+	_, err = DispatchQueue(project.ID)
+	return err
+}
+
+// ExpireApproving mark the deploys which are not approved in time as expired
+func ExpireApproving() {
+	if _, err := (model.DeployQueue{}).ExpireApproving(); err != nil {
+		core.Log(core.ERROR, "expire approving deploy fail, "+err.Error())
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+)
+
+func TestApprovalPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		project model.Project
+		// nil means the namespace has no policy for the environment
+		environment *model.EnvironmentApproval
+		wantCount   uint16
+		wantRole    string
+	}{
+		{
+			name:      "the project policy wins and the role defaults to manager",
+			project:   model.Project{NamespaceID: 1, Environment: "production", ApprovalCount: 2},
+			wantCount: 2,
+			wantRole:  core.RoleManager,
+		},
+		{
+			name:        "the project without policy uses the environment policy",
+			project:     model.Project{NamespaceID: 1, Environment: "production"},
+			environment: &model.EnvironmentApproval{ID: 1, NamespaceID: 1, Environment: "production", ApprovalCount: 1, ApprovalRole: core.RoleAdmin},
+			wantCount:   1,
+			wantRole:    core.RoleAdmin,
+		},
+		{
+			name:    "no policy needs no approval",
+			project: model.Project{NamespaceID: 1, Environment: "production"},
+		},
+	}
+	for _, tt := range tests {
+		mock, done := mockDB(t, sqlmock.QueryMatcherRegexp)
+		if tt.project.ApprovalCount == 0 {
+			query := mock.ExpectQuery(quoteSQL("FROM `environment_approval` WHERE environment = ? AND namespace_id = ?")).
+				WithArgs("production", 1)
+			if ea := tt.environment; ea != nil {
+				query.WillReturnRows(sqlmock.NewRows([]string{"id", "namespace_id", "environment", "approval_count", "approval_role"}).
+					AddRow(ea.ID, ea.NamespaceID, ea.Environment, ea.ApprovalCount, ea.ApprovalRole))
+			} else {
+				query.WillReturnError(sql.ErrNoRows)
+			}
+		}
+		count, role, err := approvalPolicy(tt.project)
+		if err != nil {
+			t.Errorf("%s: approvalPolicy() error = %v", tt.name, err)
+		} else if count != tt.wantCount || role != tt.wantRole {
+			t.Errorf("%s: approvalPolicy() = %d, %q, want %d, %q", tt.name, count, role, tt.wantCount, tt.wantRole)
+		}
+		done()
+	}
+}
+
+func TestDecideDeploy(t *testing.T) {
+	approver := model.User{ID: 3, Name: "bob"}
+	manager := model.Namespace{ID: 1, Role: core.RoleManager}
+	expectQueue := func(mock sqlmock.Sqlmock, state uint8) {
+		mock.ExpectQuery(quoteSQL("FROM `deploy_queue` WHERE id = ?")).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "project_id", "project_name", "commit_id", "branch", "ref", "source", "state", "publisher_id", "publisher_name", "approval_count", "approval_role", "summary", "expire_time"}).
+				AddRow(7, 1, "goploy", "abc", "master", "", model.QueueSourceManual, state, 2, "alice", 2, core.RoleManager, "", ""))
+	}
+	expectDecision := func(mock sqlmock.Sqlmock, decision uint8, added bool) {
+		affected := int64(0)
+		if added {
+			affected = 1
+		}
+		mock.ExpectExec(quoteSQL("INSERT IGNORE INTO `deploy_approval`")).
+			WithArgs(7, approver.ID, approver.Name, decision, "").
+			WillReturnResult(sqlmock.NewResult(0, affected))
+	}
+	changeApproving := quoteSQL("UPDATE `deploy_queue` SET state = ? WHERE id = ? AND state = ? AND expire_time > NOW()")
+	countApproved := quoteSQL("SELECT COUNT(*) FROM `deploy_approval` WHERE decision = ? AND queue_id = ?")
+	tests := []struct {
+		name      string
+		userInfo  model.User
+		namespace model.Namespace
+		decision  uint8
+		expect    func(mock sqlmock.Sqlmock)
+		wantErr   string
+	}{
+		{
+			name:      "the deploy of another namespace",
+			userInfo:  approver,
+			namespace: model.Namespace{ID: 2, Role: core.RoleAdmin},
+			decision:  model.ApprovalApprove,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQueue(mock, model.QueueApproving)
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1})
+			},
+			wantErr: "The deploy is not in the namespace",
+		},
+		{
+			name:      "the deploy has been decided",
+			userInfo:  approver,
+			namespace: manager,
+			decision:  model.ApprovalApprove,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQueue(mock, model.QueueWaiting)
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1})
+			},
+			wantErr: "The deploy is not waiting for approval",
+		},
+		{
+			name:      "the publisher approves their own deploy",
+			userInfo:  model.User{ID: 2, Name: "alice"},
+			namespace: manager,
+			decision:  model.ApprovalApprove,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQueue(mock, model.QueueApproving)
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1})
+			},
+			wantErr: "The publisher can not decide on their own deploy",
+		},
+		{
+			name:      "the role is lower than the approval role",
+			userInfo:  approver,
+			namespace: model.Namespace{ID: 1, Role: core.RoleGroupManager},
+			decision:  model.ApprovalApprove,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQueue(mock, model.QueueApproving)
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1})
+			},
+			wantErr: "The deploy needs the approval of manager or above",
+		},
+		{
+			name:      "the approver decides twice",
+			userInfo:  approver,
+			namespace: manager,
+			decision:  model.ApprovalApprove,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQueue(mock, model.QueueApproving)
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1})
+				expectDecision(mock, model.ApprovalApprove, false)
+			},
+			wantErr: "You have decided on the deploy",
+		},
+		{
+			name:      "one rejection rejects the deploy",
+			userInfo:  approver,
+			namespace: manager,
+			decision:  model.ApprovalReject,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQueue(mock, model.QueueApproving)
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1})
+				expectDecision(mock, model.ApprovalReject, true)
+				mock.ExpectExec(changeApproving).
+					WithArgs(model.QueueRejected, 7, model.QueueApproving).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:      "the deploy waits for more approvals",
+			userInfo:  approver,
+			namespace: manager,
+			decision:  model.ApprovalApprove,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQueue(mock, model.QueueApproving)
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1})
+				expectDecision(mock, model.ApprovalApprove, true)
+				mock.ExpectQuery(countApproved).
+					WithArgs(model.ApprovalApprove, 7).
+					WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+			},
+		},
+		{
+			name:      "the last approval moves the deploy to the queue",
+			userInfo:  approver,
+			namespace: manager,
+			decision:  model.ApprovalApprove,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQueue(mock, model.QueueApproving)
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1})
+				expectDecision(mock, model.ApprovalApprove, true)
+				mock.ExpectQuery(countApproved).
+					WithArgs(model.ApprovalApprove, 7).
+					WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2))
+				mock.ExpectExec(changeApproving).
+					WithArgs(model.QueueWaiting, 7, model.QueueApproving).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				// the project is deploying, the approved deploy waits in the queue
+				expectProject(mock, model.Project{ID: 1, NamespaceID: 1, DeployState: model.ProjectDeploying})
+			},
+		},
+	}
+	for _, tt := range tests {
+		mock, done := mockDB(t, sqlmock.QueryMatcherRegexp)
+		tt.expect(mock)
+		err := DecideDeploy(7, tt.userInfo, tt.namespace, tt.decision, "")
+		if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+			t.Errorf("%s: DecideDeploy() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+		done()
+	}
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
+	"github.com/zhenorzz/goploy/ws"
+)
+
+// artifact is the packed build output of a commit, it is stored in core.ArtifactPath/<project>/<commit>.tar.gz
+type artifact struct {
+	Commit   utils.Commit `json:"commit"`
+	File     string       `json:"file"`
+	Checksum string       `json:"checksum"`
+	Size     int64        `json:"size"`
+	// the artifact is loaded from the store, the local stages are skipped
+	reused bool
+}
+
+// CheckArtifact check the artifact setting of the project
+func CheckArtifact(artifactMode uint8, artifactPath, pipeline string) error {
+	if cleanPath := path.Clean(artifactPath); path.IsAbs(artifactPath) || cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
+		return errors.New("Artifact path must be inside the repository")
+	}
+	if artifactMode != model.Enable {
+		return nil
+	}
+	for _, name := range (model.Project{Pipeline: pipeline}).GetPipeline() {
+		if name == model.StagePackage {
+			return nil
+		}
+	}
+	return errors.New("Pipeline must contain the package stage in artifact mode")
+}
+
+func artifactDir(project model.Project) string {
+	return path.Join(core.ArtifactPath, project.Name)
+}
+
+// loadArtifact return the stored artifact of the commit after verifying its checksum
+func loadArtifact(project model.Project, commit string) (artifact, error) {
+	var stored artifact
+	meta, err := ioutil.ReadFile(path.Join(artifactDir(project), commit+".json"))
+	if err != nil {
+		return stored, err
+	}
+	if err := json.Unmarshal(meta, &stored); err != nil {
+		return stored, err
+	}
+	file, err := os.Open(stored.File)
+	if err != nil {
+		return stored, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return stored, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != stored.Checksum {
+		return stored, errors.New("Artifact " + stored.File + " checksum mismatch")
+	}
+	return stored, nil
+}
+
+// reuseArtifact record the stored artifact as the pull trace, so the deploy history still shows the commit
+func (sync Sync) reuseArtifact() {
+	ws.GetHub().Data <- &ws.Data{
+		Type: ws.TypeProject,
+		Message: ws.ProjectMessage{
+			ProjectID:   sync.Project.ID,
+			ProjectName: sync.Project.Name,
+			State:       ws.GitReset,
+			Message:     "Reuse artifact",
+			Ext:         sync.artifact.Commit,
+		},
+	}
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" reuse artifact "+sync.artifact.File)
+	publishTraceModel := sync.newPublishTrace(model.Pull)
+	ext, _ := json.Marshal(sync.artifact.Commit)
+	publishTraceModel.Ext = string(ext)
+	publishTraceModel.Detail = "reuse artifact " + sync.artifact.File
+	publishTraceModel.State = model.Success
+	if _, err := publishTraceModel.AddRow(); err != nil {
+		core.Log(core.ERROR, err.Error())
+	}
+}
+
+func packageStage(sync Sync, publishTraceModel *model.PublishTrace) error {
+	project := sync.Project
+	if project.ArtifactMode != model.Enable {
+		return errSkipStage
+	}
+	if len(sync.artifact.Commit.Commit) == 0 {
+		return errors.New("The git stage must run before the package stage")
+	}
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.PackArtifact, Message: "Pack artifact"},
+	}
+	if err := os.MkdirAll(artifactDir(project), 0755); err != nil {
+		return err
+	}
+	srcDir := path.Join(core.RepositoryPath, project.Name, project.ArtifactPath)
+	file := path.Join(artifactDir(project), sync.artifact.Commit.Commit+".tar.gz")
+	checksum, size, err := packDir(sync.ctx, srcDir, file)
+	if err != nil {
+		return err
+	}
+	sync.artifact.File = file
+	sync.artifact.Checksum = checksum
+	sync.artifact.Size = size
+	meta, _ := json.Marshal(sync.artifact)
+	if err := ioutil.WriteFile(path.Join(artifactDir(project), sync.artifact.Commit.Commit+".json"), meta, 0644); err != nil {
+		return err
+	}
+	publishTraceModel.Ext = string(meta)
+	publishTraceModel.Detail = "pack " + file + ", sha256 " + checksum
+	pruneArtifacts(project)
+	return nil
+}
+
+// packDir write the tar.gz of srcDir to file, return the sha256 and the size of the file
+func packDir(ctx context.Context, srcDir, file string) (string, int64, error) {
+	tmpFile := file + ".tmp"
+	output, err := os.Create(tmpFile)
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmpFile)
+	hash := sha256.New()
+	gzipWriter := gzip.NewWriter(io.MultiWriter(output, hash))
+	tarWriter := tar.NewWriter(gzipWriter)
+	err = filepath.Walk(srcDir, func(fullName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, err := filepath.Rel(srcDir, fullName)
+		if err != nil || name == "." {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(fullName); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		input, err := os.Open(fullName)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+		_, err = io.Copy(tarWriter, input)
+		return err
+	})
+	if err == nil {
+		err = tarWriter.Close()
+	}
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	info, err := os.Stat(tmpFile)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmpFile, file); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), info.Size(), nil
+}
+
+// pruneArtifacts keep the latest artifacts by the retain count of the project
+func pruneArtifacts(project model.Project) {
+	if project.RetainCount == 0 {
+		return
+	}
+	metas, err := filepath.Glob(path.Join(artifactDir(project), "*.json"))
+	if err != nil {
+		core.Log(core.ERROR, err.Error())
+		return
+	}
+	modTimes := map[string]int64{}
+	for _, meta := range metas {
+		if info, err := os.Stat(meta); err == nil {
+			modTimes[meta] = info.ModTime().UnixNano()
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool { return modTimes[metas[i]] > modTimes[metas[j]] })
+	for i := int(project.RetainCount); i < len(metas); i++ {
+		os.Remove(strings.TrimSuffix(metas[i], ".json") + ".tar.gz")
+		os.Remove(metas[i])
+	}
+}
+
+// artifactTransfer upload the artifact and unpack it in the release directory
+type artifactTransfer struct {
+	project  model.Project
+	artifact artifact
+}
+
+func (at artifactTransfer) describe(projectServer model.ProjectServer, srcPath, destDir string) string {
+	return "upload artifact " + at.artifact.File + " to " + projectServer.ServerOwner + "@" + projectServer.ServerIP + ":" + destDir
+}
+
+func (at artifactTransfer) run(ctx context.Context, projectServer model.ProjectServer, srcPath, destDir string) (string, error) {
+	if len(at.artifact.File) == 0 {
+		return "", errors.New("Artifact is not packed, add the package stage to the pipeline")
+	}
+	file, err := os.Open(at.artifact.File)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	client, err := utils.DialSSH(projectServer.ServerOwner, "", projectServer.ServerIP, int(projectServer.ServerPort))
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-done:
+		}
+	}()
+
+	tmpFile := "/tmp/goploy-artifact-" + at.project.LastPublishToken + ".tar.gz"
+	command := "mkdir -p " + destDir +
+		" && cat > " + tmpFile +
+		" && echo '" + at.artifact.Checksum + "  " + tmpFile + "' | sha256sum -c --quiet" +
+		" && tar -xzf " + tmpFile + " -C " + destDir
+	if len(at.project.AfterDeployScript) != 0 {
+		// the after deploy script is not in the artifact, write it to the release directory
+		scriptName := path.Join(destDir, "goploy-after-deploy."+utils.GetScriptExt(at.project.AfterDeployScriptMode))
+		command += " && echo " + base64.StdEncoding.EncodeToString([]byte(at.project.AfterDeployScript)) + " | base64 -d > " + scriptName
+	}
+	command += ";code=$?;rm -f " + tmpFile + ";exit $code"
+	if _, err := runClientCommand(client, command, file); err != nil {
+		return "", err
+	}
+	return "unpack artifact " + path.Base(at.artifact.File) + " (" + strconv.FormatInt(at.artifact.Size, 10) + " bytes, sha256 " + at.artifact.Checksum + ")", nil
+}
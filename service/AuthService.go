@@ -0,0 +1,298 @@
+package service
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+)
+
+// ErrAuthUserNotFound the account does not exist in the authenticator
+var ErrAuthUserNotFound = errors.New("Account does not exist")
+
+// Authenticator verify the account and password, return the goploy user
+type Authenticator interface {
+	Authenticate(account, password string) (model.User, error)
+}
+
+// PasswordAuthenticator verify the bcrypt password of the local user
+type PasswordAuthenticator struct{}
+
+// Authenticate -
+func (PasswordAuthenticator) Authenticate(account, password string) (model.User, error) {
+	userData, err := model.User{Account: account}.GetDataByAccount()
+	if err == sql.ErrNoRows {
+		return userData, ErrAuthUserNotFound
+	} else if err != nil {
+		return userData, err
+	}
+	if err := userData.Validate(password); err != nil {
+		return userData, err
+	}
+	return userData, nil
+}
+
+// LDAPGroup the goploy namespace and role of the ldap group members
+type LDAPGroup struct {
+	DN          string
+	NamespaceID int64
+	Role        string
+}
+
+// LDAPAuthenticator bind the ldap user, provision the user on first login
+// and sync the mapped group membership into the namespace
+type LDAPAuthenticator struct {
+	URL          string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string
+	NameAttr     string
+	MobileAttr   string
+	GroupAttr    string
+	Groups       []LDAPGroup
+}
+
+// NewLDAPAuthenticator read the LDAP_* configuration in .env
+func NewLDAPAuthenticator() (LDAPAuthenticator, error) {
+	a := LDAPAuthenticator{
+		URL:          os.Getenv("LDAP_URL"),
+		BindDN:       os.Getenv("LDAP_BIND_DN"),
+		BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:       os.Getenv("LDAP_BASE_DN"),
+		UserFilter:   os.Getenv("LDAP_USER_FILTER"),
+		NameAttr:     os.Getenv("LDAP_ATTR_NAME"),
+		MobileAttr:   os.Getenv("LDAP_ATTR_MOBILE"),
+		GroupAttr:    os.Getenv("LDAP_ATTR_GROUP"),
+	}
+	if a.UserFilter == "" {
+		a.UserFilter = "(uid=%s)"
+	}
+	if !strings.Contains(a.UserFilter, "%s") {
+		return a, errors.New("LDAP_USER_FILTER must contain %s for the account")
+	}
+	if a.NameAttr == "" {
+		a.NameAttr = "cn"
+	}
+	if a.GroupAttr == "" {
+		a.GroupAttr = "memberOf"
+	}
+	groups, err := ParseLDAPGroupMapping(os.Getenv("LDAP_GROUP_MAPPING"))
+	if err != nil {
+		return a, err
+	}
+	a.Groups = groups
+	return a, nil
+}
+
+// ParseLDAPGroupMapping parse the group dn:namespace id:role list separated by semicolon,
+// the group dn contains colon is not supported
+func ParseLDAPGroupMapping(mapping string) ([]LDAPGroup, error) {
+	var groups []LDAPGroup
+	for _, item := range strings.Split(mapping, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 3 {
+			return nil, errors.New("Invalid LDAP_GROUP_MAPPING " + item + ", the format is group dn:namespace id:role")
+		}
+		namespaceID, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil || namespaceID <= 0 {
+			return nil, errors.New("Invalid namespace id in LDAP_GROUP_MAPPING " + item)
+		}
+		role := strings.TrimSpace(fields[2])
+		if roleRank(role) == len(core.Roles) {
+			return nil, errors.New("Invalid role in LDAP_GROUP_MAPPING " + item)
+		}
+		groups = append(groups, LDAPGroup{DN: strings.TrimSpace(fields[0]), NamespaceID: namespaceID, Role: role})
+	}
+	return groups, nil
+}
+
+// Authenticate -
+func (a LDAPAuthenticator) Authenticate(account, password string) (model.User, error) {
+	// ldap treats the bind with empty password as an unauthenticated bind which always succeeds
+	if password == "" {
+		return model.User{}, errors.New("密码错误")
+	}
+	conn, err := ldap.DialURL(a.URL)
+	if err != nil {
+		return model.User{}, err
+	}
+	defer conn.Close()
+
+	if a.BindDN != "" {
+		if err := conn.Bind(a.BindDN, a.BindPassword); err != nil {
+			return model.User{}, errors.New("LDAP bind failed, " + err.Error())
+		}
+	}
+
+	attributes := []string{"dn", a.NameAttr, a.GroupAttr}
+	if a.MobileAttr != "" {
+		attributes = append(attributes, a.MobileAttr)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.UserFilter, ldap.EscapeFilter(account)),
+		attributes,
+		nil,
+	))
+	if err != nil {
+		return model.User{}, err
+	}
+	if len(result.Entries) == 0 {
+		return model.User{}, ErrAuthUserNotFound
+	} else if len(result.Entries) > 1 {
+		return model.User{}, errors.New("LDAP_USER_FILTER matches more than one entry")
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return model.User{}, errors.New("密码错误")
+		}
+		return model.User{}, err
+	}
+
+	userData, err := a.provision(account, entry)
+	if err != nil {
+		return userData, err
+	}
+	return userData, a.syncGroup(userData, entry.GetAttributeValues(a.GroupAttr))
+}
+
+// provision create the user on first login and keep the name and mobile same as the ldap
+func (a LDAPAuthenticator) provision(account string, entry *ldap.Entry) (model.User, error) {
+	var mobile string
+	if a.MobileAttr != "" {
+		mobile = entry.GetAttributeValue(a.MobileAttr)
+	}
+	userData, _, err := provisionUser(account, entry.GetAttributeValue(a.NameAttr), mobile)
+	return userData, err
+}
+
+// provisionUser create the user of the external identity on first login,
+// the existing user with the same account is linked and its name and mobile are updated
+func provisionUser(account, name, mobile string) (model.User, bool, error) {
+	if name == "" {
+		name = account
+	}
+	userData, err := model.User{Account: account}.GetDataByAccount()
+	if err == sql.ErrNoRows {
+		// the local password is never used by the external user, just make it unguessable
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return userData, false, err
+		}
+		id, err := model.User{Account: account, Password: hex.EncodeToString(random), Name: name, Mobile: mobile}.AddRow()
+		if err != nil {
+			return userData, false, err
+		}
+		core.Log(core.TRACE, "user "+account+" provisioned")
+		userData, err = model.User{ID: id}.GetData()
+		return userData, true, err
+	} else if err != nil {
+		return userData, false, err
+	}
+
+	if userData.Name != name || userData.Mobile != mobile {
+		userData.Name, userData.Mobile = name, mobile
+		if err := (model.User{ID: userData.ID, Name: name, Mobile: mobile, SuperManager: userData.SuperManager}).EditRow(); err != nil {
+			return userData, false, err
+		}
+	}
+	return userData, false, nil
+}
+
+// groupRoles return the mapped namespaces and the role of the member in each of them,
+// the empty role means the user is in none of the groups of the namespace
+func groupRoles(groups []LDAPGroup, memberOf []string) ([]int64, map[int64]string) {
+	member := map[string]bool{}
+	for _, dn := range memberOf {
+		member[strings.ToLower(dn)] = true
+	}
+
+	var namespaceIDs []int64
+	roles := map[int64]string{}
+	for _, group := range groups {
+		if _, ok := roles[group.NamespaceID]; !ok {
+			namespaceIDs = append(namespaceIDs, group.NamespaceID)
+			roles[group.NamespaceID] = ""
+		}
+		if !member[strings.ToLower(group.DN)] {
+			continue
+		}
+		// the highest role wins when the user is in several groups of the namespace
+		if role := roles[group.NamespaceID]; role == "" || roleRank(group.Role) < roleRank(role) {
+			roles[group.NamespaceID] = group.Role
+		}
+	}
+	return namespaceIDs, roles
+}
+
+// syncGroup replace the user role in the mapped namespaces with the ldap group membership,
+// the namespace not in the mapping is managed in goploy
+func (a LDAPAuthenticator) syncGroup(userData model.User, memberOf []string) error {
+	// the super manager is the admin of every namespace
+	if len(a.Groups) == 0 || userData.SuperManager == model.SuperManager {
+		return nil
+	}
+	namespaceIDs, roles := groupRoles(a.Groups, memberOf)
+	if err := (model.NamespaceUser{UserID: userData.ID}).DeleteInNamespaceIDs(namespaceIDs); err != nil {
+		return err
+	}
+
+	namespaceUsersModel := model.NamespaceUsers{}
+	for _, namespaceID := range namespaceIDs {
+		if roles[namespaceID] == "" {
+			continue
+		}
+		namespaceUsersModel = append(namespaceUsersModel, model.NamespaceUser{
+			NamespaceID: namespaceID,
+			UserID:      userData.ID,
+			Role:        roles[namespaceID],
+		})
+	}
+	if err := namespaceUsersModel.AddMany(); err != nil {
+		return err
+	}
+	core.DeleteNamespaceCache(userData.ID)
+
+	for _, namespaceUser := range namespaceUsersModel {
+		if namespaceUser.Role == core.RoleManager {
+			if err := (model.ProjectUser{}).AddNamespaceProjectInUserID(namespaceUser.NamespaceID, []int64{userData.ID}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Authenticate try the ldap first when LDAP_URL is configured,
+// the account not in the ldap or the unreachable ldap falls back to the local user
+func Authenticate(account, password string) (model.User, error) {
+	if os.Getenv("LDAP_URL") != "" {
+		authenticator, err := NewLDAPAuthenticator()
+		if err != nil {
+			return model.User{}, err
+		}
+		userData, err := authenticator.Authenticate(account, password)
+		if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+			core.Log(core.WARNING, "ldap is unreachable, "+err.Error())
+		} else if err != ErrAuthUserNotFound {
+			return userData, err
+		}
+	}
+	return PasswordAuthenticator{}.Authenticate(account, password)
+}
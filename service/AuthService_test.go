@@ -0,0 +1,110 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zhenorzz/goploy/core"
+)
+
+func TestParseLDAPGroupMapping(t *testing.T) {
+	tests := []struct {
+		mapping string
+		want    []LDAPGroup
+		wantErr bool
+	}{
+		{mapping: "", want: nil},
+		{mapping: " ; ", want: nil},
+		{
+			mapping: "cn=dev,ou=groups,dc=example,dc=com:1:member",
+			want:    []LDAPGroup{{DN: "cn=dev,ou=groups,dc=example,dc=com", NamespaceID: 1, Role: core.RoleMember}},
+		},
+		{
+			mapping: " cn=ops,dc=example,dc=com : 2 : manager ;cn=dev,dc=example,dc=com:3:group-manager;",
+			want: []LDAPGroup{
+				{DN: "cn=ops,dc=example,dc=com", NamespaceID: 2, Role: core.RoleManager},
+				{DN: "cn=dev,dc=example,dc=com", NamespaceID: 3, Role: core.RoleGroupManager},
+			},
+		},
+		{mapping: "cn=dev,dc=example,dc=com:1", wantErr: true},
+		{mapping: "cn=dev:1:member:extra", wantErr: true},
+		{mapping: "cn=dev,dc=example,dc=com:abc:member", wantErr: true},
+		{mapping: "cn=dev,dc=example,dc=com:0:member", wantErr: true},
+		{mapping: "cn=dev,dc=example,dc=com:-1:member", wantErr: true},
+		{mapping: "cn=dev,dc=example,dc=com:1:owner", wantErr: true},
+		{mapping: "cn=dev,dc=example,dc=com:1:member;cn=ops:2", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLDAPGroupMapping(tt.mapping)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLDAPGroupMapping(%q) error = %v, wantErr %v", tt.mapping, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLDAPGroupMapping(%q) = %v, want %v", tt.mapping, got, tt.want)
+		}
+	}
+}
+
+func TestGroupRoles(t *testing.T) {
+	groups := []LDAPGroup{
+		{DN: "cn=dev,dc=example,dc=com", NamespaceID: 1, Role: core.RoleMember},
+		{DN: "cn=lead,dc=example,dc=com", NamespaceID: 1, Role: core.RoleGroupManager},
+		{DN: "cn=ops,dc=example,dc=com", NamespaceID: 1, Role: core.RoleManager},
+		{DN: "cn=dev,dc=example,dc=com", NamespaceID: 2, Role: core.RoleMember},
+		{DN: "cn=admin,dc=example,dc=com", NamespaceID: 3, Role: core.RoleAdmin},
+	}
+	tests := []struct {
+		name     string
+		memberOf []string
+		want     map[int64]string
+	}{
+		{
+			name:     "no group",
+			memberOf: nil,
+			want:     map[int64]string{1: "", 2: "", 3: ""},
+		},
+		{
+			name:     "one group in several namespaces",
+			memberOf: []string{"cn=dev,dc=example,dc=com"},
+			want:     map[int64]string{1: core.RoleMember, 2: core.RoleMember, 3: ""},
+		},
+		{
+			name:     "the highest role wins",
+			memberOf: []string{"cn=dev,dc=example,dc=com", "cn=ops,dc=example,dc=com", "cn=lead,dc=example,dc=com"},
+			want:     map[int64]string{1: core.RoleManager, 2: core.RoleMember, 3: ""},
+		},
+		{
+			name:     "the order of the groups does not matter",
+			memberOf: []string{"cn=lead,dc=example,dc=com", "cn=dev,dc=example,dc=com"},
+			want:     map[int64]string{1: core.RoleGroupManager, 2: core.RoleMember, 3: ""},
+		},
+		{
+			name:     "the dn is case insensitive",
+			memberOf: []string{"CN=Admin,DC=Example,DC=Com"},
+			want:     map[int64]string{1: "", 2: "", 3: core.RoleAdmin},
+		},
+		{
+			name:     "the group not in the mapping is ignored",
+			memberOf: []string{"cn=other,dc=example,dc=com"},
+			want:     map[int64]string{1: "", 2: "", 3: ""},
+		},
+	}
+	for _, tt := range tests {
+		namespaceIDs, roles := groupRoles(groups, tt.memberOf)
+		if !reflect.DeepEqual(namespaceIDs, []int64{1, 2, 3}) {
+			t.Errorf("%s: namespace ids = %v, want [1 2 3]", tt.name, namespaceIDs)
+		}
+		if !reflect.DeepEqual(roles, tt.want) {
+			t.Errorf("%s: roles = %v, want %v", tt.name, roles, tt.want)
+		}
+	}
+}
+
+func TestLDAPAuthenticateEmptyPassword(t *testing.T) {
+	// nothing listens on the port, the empty password must be rejected before dialing
+	a := LDAPAuthenticator{URL: "ldap://127.0.0.1:1", UserFilter: "(uid=%s)"}
+	if _, err := a.Authenticate("alice", ""); err == nil || err.Error() != "密码错误" {
+		t.Errorf("Authenticate with empty password error = %v, want the password error", err)
+	}
+}
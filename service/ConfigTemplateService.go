@@ -0,0 +1,124 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
+)
+
+// configTemplateData is the data of the config template,
+// e.g. {{.Server.ServerIP}}, {{.Var.GOPLOY_TOKEN}}, {{.Var.DB_PASSWORD}}
+type configTemplateData struct {
+	Project model.Project
+	Server  model.ProjectServer
+	// the built-in variables, the project variables, the server variables and the secrets
+	Var map[string]string
+}
+
+// CheckConfigTemplate check the path is inside the release directory and the content can be parsed
+func CheckConfigTemplate(filePath, content string) error {
+	if cleanPath := path.Clean(filePath); path.IsAbs(filePath) || cleanPath == "." || cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
+		return errors.New("Config template path must be inside the release directory")
+	}
+	if _, err := template.New(filePath).Option("missingkey=error").Parse(content); err != nil {
+		return err
+	}
+	return nil
+}
+
+// renderTemplate render the text/template for the server
+func (sync Sync) renderTemplate(projectServer model.ProjectServer, name, content string) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", err
+	}
+	data := configTemplateData{Project: sync.Project, Server: projectServer, Var: map[string]string{}}
+	for _, v := range sync.scriptVariables(projectServer, sync.Project.LastPublishToken) {
+		data.Var[v.name] = v.value
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// configTemplateArchive return the tar.gz of the rendered config templates and their paths inside the release directory
+func (sync Sync) configTemplateArchive(projectServer model.ProjectServer) (*bytes.Buffer, []string, error) {
+	var archive bytes.Buffer
+	var files []string
+	gzipWriter := gzip.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, configTemplate := range sync.configTemplates {
+		content, err := sync.renderTemplate(projectServer, configTemplate.Path, configTemplate.Content)
+		if err != nil {
+			return nil, nil, errors.New("render config template " + configTemplate.Path + " fail, " + err.Error())
+		}
+		file := path.Clean(configTemplate.Path)
+		header := &tar.Header{
+			Name:    file,
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: time.Now(),
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, nil, err
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, nil, err
+	}
+	return &archive, files, nil
+}
+
+// uploadConfigTemplates write the rendered config templates into the release directory before the symlink switch
+func (sync Sync) uploadConfigTemplates(projectServer model.ProjectServer, destDir string, publishTraceModel *model.PublishTrace) error {
+	if len(sync.configTemplates) == 0 {
+		return nil
+	}
+	archive, files, err := sync.configTemplateArchive(projectServer)
+	if err != nil {
+		return err
+	}
+	client, err := utils.DialSSH(projectServer.ServerOwner, "", projectServer.ServerIP, int(projectServer.ServerPort))
+	if err != nil {
+		return errors.New("upload config templates fail, " + err.Error())
+	}
+	defer client.Close()
+	// the rendered content may contain the secrets, stream it as the tar instead of the command line
+	if _, err := runClientCommand(client, "mkdir -p "+shellQuote(destDir)+" && tar -xzf - -C "+shellQuote(destDir), archive); err != nil {
+		return errors.New("upload config templates fail, " + err.Error())
+	}
+	for _, file := range files {
+		publishTraceModel.Detail += "render config " + path.Join(destDir, file) + "\n"
+	}
+	return nil
+}
+
+// PreviewConfigTemplate render the config template for the server with the secrets redacted
+func PreviewConfigTemplate(userInfo model.User, project model.Project, projectServer model.ProjectServer, configTemplate model.ProjectConfigTemplate) (string, error) {
+	secrets, err := model.Secret{NamespaceID: project.NamespaceID, ProjectID: project.ID}.GetScopeList()
+	if err != nil {
+		return "", err
+	}
+	sync := Sync{UserInfo: userInfo, Project: project}
+	for _, secret := range secrets {
+		sync.secrets = append(sync.secrets, variable{name: secret.Name, value: utils.SecretMask})
+	}
+	return sync.renderTemplate(projectServer, configTemplate.Path, configTemplate.Content)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zhenorzz/goploy/model"
+)
+
+func TestConfigTemplateArchive(t *testing.T) {
+	// the long line is cut by the pty in the canonical mode, it must arrive intact in the tar
+	longValue := strings.Repeat("x", 8192)
+	sync := Sync{
+		Project: model.Project{Name: "goploy"},
+		secrets: []variable{{name: "DB_PASSWORD", value: longValue}},
+		configTemplates: model.ProjectConfigTemplates{
+			{Path: "./conf/app.ini", Content: "name={{.Project.Name}}\nip={{.Server.ServerIP}}\n"},
+			{Path: ".env", Content: "DB_PASSWORD={{.Var.DB_PASSWORD}}"},
+		},
+	}
+	archive, files, err := sync.configTemplateArchive(model.ProjectServer{ServerIP: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("configTemplateArchive() error = %v", err)
+	}
+	if want := []string{"conf/app.ini", ".env"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	gzipReader, err := gzip.NewReader(archive)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	got := map[string]string{}
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			break
+		}
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			t.Fatalf("read %s error = %v", header.Name, err)
+		}
+		got[header.Name] = string(content)
+	}
+	want := map[string]string{
+		"conf/app.ini": "name=goploy\nip=10.0.0.1\n",
+		".env":         "DB_PASSWORD=" + longValue,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("archive = %v, want %v", got, want)
+	}
+}
+
+func TestConfigTemplateArchiveMissingKey(t *testing.T) {
+	sync := Sync{
+		configTemplates: model.ProjectConfigTemplates{
+			{Path: "app.ini", Content: "{{.Var.NOT_DEFINED}}"},
+		},
+	}
+	if _, _, err := sync.configTemplateArchive(model.ProjectServer{}); err == nil {
+		t.Errorf("configTemplateArchive() with the missing variable error = nil, want the render error")
+	}
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/ws"
+)
+
+const (
+	heartbeatInterval = 30 * time.Second
+	heartbeatTimeout  = 2 * time.Minute
+)
+
+// dispatchMutex make sure only one deploy of the project is dispatched
+var dispatchMutex sync.Mutex
+
+// canaryConfirms holds the deploys waiting for the canary confirmation, key is project id
+var canaryConfirms = struct {
+	sync.Mutex
+	chans map[int64]chan bool
+}{chans: map[int64]chan bool{}}
+
+// ConfirmCanary continue or abort the deploy which is waiting after the canary server
+func ConfirmCanary(projectID int64, pass bool) error {
+	canaryConfirms.Lock()
+	ch, ok := canaryConfirms.chans[projectID]
+	delete(canaryConfirms.chans, projectID)
+	canaryConfirms.Unlock()
+	if !ok {
+		return errors.New("The project is not waiting for canary confirmation")
+	}
+	ch <- pass
+	return nil
+}
+
+// waitCanaryConfirm block until ConfirmCanary is called or the deploy is cancelled
+func waitCanaryConfirm(ctx context.Context, projectID int64) bool {
+	ch := make(chan bool, 1)
+	canaryConfirms.Lock()
+	canaryConfirms.chans[projectID] = ch
+	canaryConfirms.Unlock()
+	select {
+	case pass := <-ch:
+		return pass
+	case <-ctx.Done():
+		canaryConfirms.Lock()
+		delete(canaryConfirms.chans, projectID)
+		canaryConfirms.Unlock()
+		return false
+	}
+}
+
+type deployContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// deployContexts holds the context of the running deploys, key is project id
+var deployContexts = struct {
+	sync.Mutex
+	contexts map[int64]deployContext
+}{contexts: map[int64]deployContext{}}
+
+// newDeployContext return the context of the running deploy and the func to release it
+func newDeployContext(projectID int64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	deployContexts.Lock()
+	deployContexts.contexts[projectID] = deployContext{ctx: ctx, cancel: cancel}
+	deployContexts.Unlock()
+	go deployHeartbeat(ctx, projectID)
+	return ctx, func() {
+		deployContexts.Lock()
+		// the next deploy of the project may have been dispatched
+		if deployContexts.contexts[projectID].ctx == ctx {
+			delete(deployContexts.contexts, projectID)
+		}
+		deployContexts.Unlock()
+		cancel()
+	}
+}
+
+// deployHeartbeat keep the deploy alive until ctx is done, the reconciler treats the deploy without heartbeat as dead
+func deployHeartbeat(ctx context.Context, projectID int64) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := (model.Project{ID: projectID, DeployOwner: core.InstanceID}).Heartbeat(); err != nil {
+				core.Log(core.ERROR, "projectID:"+strconv.FormatInt(projectID, 10)+" deploy heartbeat fail, "+err.Error())
+			}
+		}
+	}
+}
+
+// isDeployRunning report whether the project has a live deploy in this instance
+func isDeployRunning(projectID int64) bool {
+	deployContexts.Lock()
+	defer deployContexts.Unlock()
+	_, ok := deployContexts.contexts[projectID]
+	return ok
+}
+
+// ReconcileDeploys fail the deploys which have no live worker,
+// the project which enable requeue_interrupted put the interrupted deploy back to the queue
+func ReconcileDeploys() {
+	projects, err := model.Project{}.GetDeployingList()
+	if err != nil {
+		core.Log(core.ERROR, "get deploying project list fail, "+err.Error())
+		return
+	}
+	now := time.Now().Unix()
+	for _, project := range projects {
+		idle := time.Duration(now-project.DeployHeartbeat) * time.Second
+		// only the heartbeat tells whether the deploy of the other instance is alive,
+		// the instances may share the hostname, e.g. the containers
+		dead := idle > heartbeatTimeout
+		if project.DeployOwner == core.InstanceID {
+			// the deploy of this instance stops as soon as the worker exits
+			dead = !isDeployRunning(project.ID) && idle > heartbeatInterval
+		}
+		if !dead {
+			continue
+		}
+		interrupted, err := project.DeployInterrupt()
+		if err != nil {
+			core.Log(core.ERROR, "projectID:"+strconv.FormatInt(project.ID, 10)+" interrupt deploy fail, "+err.Error())
+			continue
+		}
+		if !interrupted {
+			continue
+		}
+		core.Log(core.TRACE, "projectID:"+strconv.FormatInt(project.ID, 10)+" deploy "+project.LastPublishToken+" of "+project.DeployOwner+" is interrupted")
+		publishTraceModel := model.PublishTrace{
+			Token:         project.LastPublishToken,
+			ProjectID:     project.ID,
+			ProjectName:   project.Name,
+			PublisherID:   project.PublisherID,
+			PublisherName: project.PublisherName,
+			Detail:        "interrupted, the deploy of " + project.DeployOwner + " has no heartbeat",
+			State:         model.Fail,
+		}
+		if _, err := publishTraceModel.AddRow(); err != nil {
+			core.Log(core.ERROR, err.Error())
+		}
+		ws.GetHub().Data <- &ws.Data{
+			Type:    ws.TypeProject,
+			Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.ProjectFail, Message: "Deploy is interrupted"},
+		}
+
+		if project.RequeueInterrupted == model.Enable {
+			deployQueue, err := model.DeployQueue{Token: project.LastPublishToken}.GetDataByToken()
+			if err == nil {
+				// the interrupted deploy has been dispatched once, it needs no approval again
+				deployQueue.State = model.QueueWaiting
+				_, err = deployQueue.AddRow()
+			}
+			if err != nil {
+				core.Log(core.ERROR, "projectID:"+strconv.FormatInt(project.ID, 10)+" requeue interrupted deploy fail, "+err.Error())
+			}
+		}
+		dispatchNext(project.ID)
+	}
+}
+
+// CancelDeploy signal the running deploy of the project to stop
+func CancelDeploy(projectID int64) error {
+	deployContexts.Lock()
+	deployContext, ok := deployContexts.contexts[projectID]
+	deployContexts.Unlock()
+	if !ok || deployContext.ctx.Err() != nil {
+		return errors.New("The project is not deploying")
+	}
+	deployContext.cancel()
+	return nil
+}
+
+// Enqueue add the deploy request to the project queue,
+// the webhook push of the same branch collapse into the waiting one,
+// the deploy of the project which needs approval waits for the approvers instead
+func Enqueue(deployQueue model.DeployQueue) (model.DeployQueue, error) {
+	approving, err := requireApproval(&deployQueue)
+	if err != nil {
+		return deployQueue, err
+	}
+	if !approving && deployQueue.Source == model.QueueSourceWebhook {
+		waitingQueue, err := deployQueue.GetWaitingWebhook()
+		if err == nil {
+			return waitingQueue, nil
+		} else if err != sql.ErrNoRows {
+			return deployQueue, err
+		}
+	}
+	deployQueue.ID, err = deployQueue.AddRow()
+	return deployQueue, err
+}
+
+// ClaimProject claim the project for the operation outside the queue, e.g. switching the release,
+// it shares the mutex and the database claim with DispatchQueue, return false when the project is deploying
+func ClaimProject(project *model.Project, userInfo model.User) (bool, error) {
+	dispatchMutex.Lock()
+	defer dispatchMutex.Unlock()
+	project.PublisherID = userInfo.ID
+	project.PublisherName = userInfo.Name
+	project.DeployState = model.ProjectDeploying
+	project.LastPublishToken = uuid.New().String()
+	project.DeployOwner = core.InstanceID
+	return project.Publish()
+}
+
+// DispatchQueue start the oldest waiting deploy when the project is not deploying,
+// return the dispatched queue id, 0 means nothing is dispatched
+func DispatchQueue(projectID int64) (int64, error) {
+	dispatchMutex.Lock()
+	defer dispatchMutex.Unlock()
+	project, err := model.Project{ID: projectID}.GetData()
+	if err != nil {
+		return 0, err
+	}
+	if project.DeployState == model.ProjectDeploying {
+		return 0, nil
+	}
+	deployQueues, err := model.DeployQueue{ProjectID: projectID}.GetWaitingList()
+	if err != nil || len(deployQueues) == 0 {
+		return 0, err
+	}
+	_, frozen, err := GetActiveFreeze(project)
+	if err != nil {
+		return 0, err
+	}
+	// only the deploy overridden by the admin leaves the queue during the freeze,
+	// the others are dispatched by the queue task once the freeze ends
+	i := 0
+	for frozen && i < len(deployQueues) && deployQueues[i].FreezeOverride == model.Disable {
+		i++
+	}
+	if i == len(deployQueues) {
+		return 0, nil
+	}
+	deployQueue := deployQueues[i]
+	// load everything the deploy needs before the claim, so the failure leaves the queue waiting
+	projectServers, err := model.ProjectServer{ProjectID: projectID}.GetBindServerListByProjectID()
+	if err != nil {
+		return 0, err
+	}
+	userInfo, err := model.User{ID: deployQueue.PublisherID}.GetData()
+	if err != nil {
+		return 0, err
+	}
+	deployQueue.State = model.QueueDispatched
+	deployQueue.Token = uuid.New().String()
+	if dispatched, err := deployQueue.ChangeWaitingState(); err != nil || !dispatched {
+		return 0, err
+	}
+	project.PublisherID = userInfo.ID
+	project.PublisherName = userInfo.Name
+	project.DeployState = model.ProjectDeploying
+	project.LastPublishToken = deployQueue.Token
+	project.DeployOwner = core.InstanceID
+	// the mutex only guards this instance, the project is claimed in the database against the other instances
+	if claimed, err := project.Publish(); err != nil || !claimed {
+		if resetErr := deployQueue.ResetDispatchedState(); resetErr != nil {
+			core.Log(core.ERROR, "projectID:"+strconv.FormatInt(projectID, 10)+" reset the dispatched queue fail, "+resetErr.Error())
+		}
+		return 0, err
+	}
+	go Sync{
+		UserInfo:       userInfo,
+		Project:        project,
+		ProjectServers: projectServers,
+		CommitID:       deployQueue.CommitID,
+		Ref:            deployQueue.Ref,
+	}.Exec()
+	return deployQueue.ID, nil
+}
+
+// dispatchNext start the next waiting deploy after the project finish deploying
+func dispatchNext(projectID int64) {
+	if _, err := DispatchQueue(projectID); err != nil {
+		core.Log(core.ERROR, "projectID:"+strconv.FormatInt(projectID, 10)+" dispatch deploy queue fail, "+err.Error())
+	}
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/ws"
+)
+
+// mockDB replace the database with the sqlmock and write the log into a temporary directory
+func mockDB(t *testing.T, queryMatcher sqlmock.QueryMatcher) (sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(queryMatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logPath, err := ioutil.TempDir("", "goploy-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logEnv, hasLogEnv := os.LookupEnv("LOG_PATH")
+	os.Setenv("LOG_PATH", logPath)
+	model.DB = db
+	return mock, func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+		if hasLogEnv {
+			os.Setenv("LOG_PATH", logEnv)
+		} else {
+			os.Unsetenv("LOG_PATH")
+		}
+		os.RemoveAll(logPath)
+	}
+}
+
+// drainHub receive the websocket messages, there is no hub running in the test
+func drainHub() func() {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ws.GetHub().Data:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func quoteSQL(query string) string {
+	return regexp.QuoteMeta(query)
+}
+
+// expectProject return the project for model.Project.GetData
+func expectProject(mock sqlmock.Sqlmock, p model.Project) {
+	columns := "id, namespace_id, name, url, path, symlink_path, environment, branch, before_pull_script_mode, before_pull_script, after_pull_script_mode, after_pull_script, before_deploy_script_mode, before_deploy_script, after_deploy_script_mode, after_deploy_script, rsync_option, variables, pipeline, stage_timeout, transfer_mode, artifact_mode, artifact_path, deploy_strategy, batch_size, canary_confirm, auto_rollback, retain_count, retain_days, pinned_releases, requeue_interrupted, approval_count, approval_role, tag_pattern, auto_deploy, deploy_state, notify_type, notify_target, insert_time, update_time"
+	values := []driver.Value{
+		p.ID, p.NamespaceID, p.Name, p.URL, p.Path, p.SymlinkPath, p.Environment, p.Branch,
+		p.BeforePullScriptMode, p.BeforePullScript, p.AfterPullScriptMode, p.AfterPullScript,
+		p.BeforeDeployScriptMode, p.BeforeDeployScript, p.AfterDeployScriptMode, p.AfterDeployScript,
+		p.RsyncOption, p.Variables, p.Pipeline, p.StageTimeout, p.TransferMode, p.ArtifactMode, p.ArtifactPath,
+		p.DeployStrategy, p.BatchSize, p.CanaryConfirm, p.AutoRollback, p.RetainCount, p.RetainDays, p.PinnedReleases,
+		p.RequeueInterrupted, p.ApprovalCount, p.ApprovalRole, p.TagPattern, p.AutoDeploy, p.DeployState,
+		p.NotifyType, p.NotifyTarget, p.InsertTime, p.UpdateTime,
+	}
+	mock.ExpectQuery(quoteSQL("SELECT " + columns + " FROM `project` WHERE id = ?")).
+		WithArgs(p.ID).
+		WillReturnRows(sqlmock.NewRows(strings.Split(columns, ", ")).AddRow(values...))
+}
+
+func waitingQueueRows(deployQueues ...model.DeployQueue) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "project_id", "project_name", "commit_id", "branch", "ref", "source", "state", "publisher_id", "publisher_name", "freeze_override", "insert_time", "update_time"})
+	for _, dq := range deployQueues {
+		rows.AddRow(dq.ID, dq.ProjectID, dq.ProjectName, dq.CommitID, dq.Branch, dq.Ref, dq.Source, model.QueueWaiting, dq.PublisherID, dq.PublisherName, dq.FreezeOverride, "", "")
+	}
+	return rows
+}
+
+func freezeWindowRows(cron string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "namespace_id", "project_id", "type", "cron", "start_time", "end_time", "reason", "creator", "creator_id", "editor", "editor_id", "insert_time", "update_time"})
+	if len(cron) != 0 {
+		rows.AddRow(1, 1, 0, model.FreezeRecurring, cron, "", "", "release day", "admin", 1, "admin", 1, "", "")
+	}
+	return rows
+}
+
+func TestEnqueueCollapseWebhook(t *testing.T) {
+	project := model.Project{ID: 1, NamespaceID: 1, Name: "goploy", Environment: "production", Branch: "master"}
+	webhookQueue := model.DeployQueue{ProjectID: 1, ProjectName: "goploy", Branch: "master", Ref: "master", Source: model.QueueSourceWebhook, PublisherID: 2, PublisherName: "alice"}
+	tests := []struct {
+		name       string
+		queue      model.DeployQueue
+		waitingID  int64
+		wantID     int64
+		wantInsert bool
+	}{
+		{name: "the webhook joins the waiting webhook of the same ref", queue: webhookQueue, waitingID: 5, wantID: 5},
+		{name: "the webhook is queued when none is waiting", queue: webhookQueue, wantID: 6, wantInsert: true},
+		{name: "the manual deploy is always queued", queue: model.DeployQueue{ProjectID: 1, ProjectName: "goploy", Branch: "master", Source: model.QueueSourceManual, PublisherID: 2, PublisherName: "alice"}, wantID: 6, wantInsert: true},
+	}
+	for _, tt := range tests {
+		mock, done := mockDB(t, sqlmock.QueryMatcherRegexp)
+		expectProject(mock, project)
+		mock.ExpectQuery(quoteSQL("FROM `environment_approval` WHERE environment = ? AND namespace_id = ?")).
+			WithArgs("production", 1).
+			WillReturnError(sql.ErrNoRows)
+		if tt.queue.Source == model.QueueSourceWebhook {
+			waiting := mock.ExpectQuery(quoteSQL("FROM `deploy_queue` WHERE branch = ? AND project_id = ? AND ref = ? AND source = ? AND state = ? ORDER BY id DESC LIMIT 1")).
+				WithArgs("master", 1, "master", model.QueueSourceWebhook, model.QueueWaiting)
+			if tt.waitingID != 0 {
+				waiting.WillReturnRows(sqlmock.NewRows([]string{"id", "project_id", "project_name", "commit_id", "branch", "ref", "source", "state", "publisher_id", "publisher_name"}).
+					AddRow(tt.waitingID, 1, "goploy", "", "master", "master", model.QueueSourceWebhook, model.QueueWaiting, 3, "bob"))
+			} else {
+				waiting.WillReturnError(sql.ErrNoRows)
+			}
+		}
+		if tt.wantInsert {
+			mock.ExpectExec(quoteSQL("INSERT INTO `deploy_queue`")).
+				WillReturnResult(sqlmock.NewResult(6, 1))
+		}
+		deployQueue, err := Enqueue(tt.queue)
+		if err != nil {
+			t.Errorf("%s: Enqueue() error = %v", tt.name, err)
+		} else if deployQueue.ID != tt.wantID {
+			t.Errorf("%s: Enqueue() id = %d, want %d", tt.name, deployQueue.ID, tt.wantID)
+		}
+		done()
+	}
+}
+
+func TestDispatchQueueProjectDeploying(t *testing.T) {
+	mock, done := mockDB(t, sqlmock.QueryMatcherRegexp)
+	defer done()
+	expectProject(mock, model.Project{ID: 1, NamespaceID: 1, DeployState: model.ProjectDeploying})
+	if id, err := DispatchQueue(1); err != nil || id != 0 {
+		t.Errorf("DispatchQueue() = %d, %v, want nothing dispatched", id, err)
+	}
+}
+
+func TestDispatchQueueFreeze(t *testing.T) {
+	mock, done := mockDB(t, sqlmock.QueryMatcherRegexp)
+	defer done()
+	expectProject(mock, model.Project{ID: 1, NamespaceID: 1, DeployState: model.ProjectSuccess})
+	mock.ExpectQuery(quoteSQL("FROM `deploy_queue` WHERE state = ? AND project_id = ? ORDER BY id ASC")).
+		WithArgs(model.QueueWaiting, 1).
+		WillReturnRows(waitingQueueRows(model.DeployQueue{ID: 7, ProjectID: 1, PublisherID: 2, FreezeOverride: model.Disable}))
+	mock.ExpectQuery(quoteSQL("FROM `freeze_window`")).
+		WillReturnRows(freezeWindowRows("* * * * *"))
+	// the queue waits for the end of the freeze, nothing is claimed
+	if id, err := DispatchQueue(1); err != nil || id != 0 {
+		t.Errorf("DispatchQueue() = %d, %v, want nothing dispatched", id, err)
+	}
+}
+
+func TestDispatchQueueClaim(t *testing.T) {
+	tests := []struct {
+		name string
+		// the queue is dispatched by another instance before this one
+		queueTaken bool
+	}{
+		{name: "the project is claimed by another instance, the queue goes back to waiting"},
+		{name: "the queue is dispatched by another instance", queueTaken: true},
+	}
+	for _, tt := range tests {
+		mock, done := mockDB(t, sqlmock.QueryMatcherRegexp)
+		expectProject(mock, model.Project{ID: 1, NamespaceID: 1, DeployState: model.ProjectSuccess})
+		// the overridden deploy leaves the queue during the freeze, the others keep waiting
+		mock.ExpectQuery(quoteSQL("FROM `deploy_queue` WHERE state = ? AND project_id = ? ORDER BY id ASC")).
+			WithArgs(model.QueueWaiting, 1).
+			WillReturnRows(waitingQueueRows(
+				model.DeployQueue{ID: 7, ProjectID: 1, PublisherID: 2, FreezeOverride: model.Disable},
+				model.DeployQueue{ID: 8, ProjectID: 1, PublisherID: 2, FreezeOverride: model.Enable},
+			))
+		mock.ExpectQuery(quoteSQL("FROM `freeze_window`")).
+			WillReturnRows(freezeWindowRows("* * * * *"))
+		mock.ExpectQuery(quoteSQL("FROM `project_server`")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "project_id", "server_id", "name", "ip", "port", "owner", "description", "variables", "insert_time", "update_time"}).
+				AddRow(1, 1, 1, "web", "10.0.0.1", 22, "root", "", "", "", ""))
+		mock.ExpectQuery(quoteSQL("FROM `user` WHERE id = ?")).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account", "password", "name", "mobile", "super_manager", "state", "insert_time", "update_time"}).
+				AddRow(2, "alice", "", "alice", "", 0, model.Enable, "", ""))
+		if tt.queueTaken {
+			mock.ExpectExec(quoteSQL("UPDATE `deploy_queue` SET state = ?, token = ? WHERE id = ? AND state = ?")).
+				WithArgs(model.QueueDispatched, sqlmock.AnyArg(), 8, model.QueueWaiting).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		} else {
+			mock.ExpectExec(quoteSQL("UPDATE `deploy_queue` SET state = ?, token = ? WHERE id = ? AND state = ?")).
+				WithArgs(model.QueueDispatched, sqlmock.AnyArg(), 8, model.QueueWaiting).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(quoteSQL("UPDATE `project` SET")).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(quoteSQL("UPDATE `deploy_queue` SET state = ?, token = ? WHERE id = ? AND state = ? AND token = ?")).
+				WithArgs(model.QueueWaiting, "", 8, model.QueueDispatched, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		if id, err := DispatchQueue(1); err != nil || id != 0 {
+			t.Errorf("%s: DispatchQueue() = %d, %v, want nothing dispatched", tt.name, id, err)
+		}
+		done()
+	}
+}
+
+func TestReconcileDeploys(t *testing.T) {
+	// the failed statement is only logged, count the interrupts to catch the alive deploy being interrupted,
+	// the alive deploys come before the last expected interrupt so their statements reach the matcher
+	interrupts := 0
+	mock, done := mockDB(t, sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+		if strings.HasPrefix(actualSQL, "UPDATE `project` SET deploy_state") {
+			interrupts++
+		}
+		return sqlmock.QueryMatcherRegexp.Match(expectedSQL, actualSQL)
+	}))
+	defer done()
+	defer drainHub()()
+	// the project 4 is deploying in this instance
+	_, release := newDeployContext(4)
+	defer release()
+
+	stale := time.Now().Add(-10 * time.Minute).Unix()
+	mock.ExpectQuery(quoteSQL("FROM `project` WHERE deploy_state = ?")).
+		WithArgs(model.ProjectDeploying).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "last_publish_token", "publisher_id", "publisher_name", "deploy_owner", "deploy_heartbeat", "requeue_interrupted"}).
+			// the other instance stops the heartbeat
+			AddRow(1, "dead", "token-1", 2, "alice", "other-instance", stale, model.Enable).
+			// the other instance keeps the heartbeat
+			AddRow(2, "alive", "token-2", 2, "alice", "other-instance", time.Now().Unix(), model.Enable).
+			// the worker of this instance is running, the heartbeat is late
+			AddRow(4, "running", "token-4", 2, "alice", core.InstanceID, stale, model.Disable).
+			// the worker of this instance has exited, the deploy finishes before the interrupt
+			AddRow(3, "finished", "token-3", 2, "alice", core.InstanceID, stale, model.Disable))
+
+	interrupt := quoteSQL("UPDATE `project` SET deploy_state = ? WHERE deploy_state = ? AND id = ? AND last_publish_token = ?")
+	mock.ExpectExec(interrupt).
+		WithArgs(model.ProjectFail, model.ProjectDeploying, 1, "token-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(quoteSQL("INSERT INTO `publish_trace`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// the interrupted deploy goes back to the queue without approval
+	mock.ExpectQuery(quoteSQL("FROM `deploy_queue` WHERE token = ?")).
+		WithArgs("token-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "project_id", "project_name", "commit_id", "branch", "ref", "source", "state", "token", "publisher_id", "publisher_name"}).
+			AddRow(9, 1, "dead", "", "master", "", model.QueueSourceManual, model.QueueDispatched, "token-1", 2, "alice"))
+	mock.ExpectExec(quoteSQL("INSERT INTO `deploy_queue`")).
+		WithArgs(1, "dead", "", "master", "", model.QueueSourceManual, model.QueueWaiting, 2, "alice", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(10, 1))
+	// the next deploy is dispatched, the project is gone in the test
+	mock.ExpectQuery(quoteSQL("FROM `project` WHERE id = ?")).
+		WithArgs(1).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(interrupt).
+		WithArgs(model.ProjectFail, model.ProjectDeploying, 3, "token-3").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ReconcileDeploys()
+	if interrupts != 2 {
+		t.Errorf("ReconcileDeploys() interrupts %d deploys, want 2", interrupts)
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
+)
+
+const freezeTimeLayout = "2006-01-02 15:04:05"
+
+// CheckFreezeWindow check the cron of the recurring window and the range of the ad-hoc window
+func CheckFreezeWindow(freezeType uint8, cron, startTime, endTime string) error {
+	switch freezeType {
+	case model.FreezeRecurring:
+		_, err := utils.ParseCron(cron)
+		return err
+	case model.FreezeRange:
+		start, err := time.ParseInLocation(freezeTimeLayout, startTime, time.Local)
+		if err != nil {
+			return errors.New("Invalid start time, " + err.Error())
+		}
+		end, err := time.ParseInLocation(freezeTimeLayout, endTime, time.Local)
+		if err != nil {
+			return errors.New("Invalid end time, " + err.Error())
+		}
+		if !end.After(start) {
+			return errors.New("End time must be after start time")
+		}
+		return nil
+	}
+	return errors.New("Freeze window type must be recurring or range")
+}
+
+// GetActiveFreeze return the window which freezes the project now, false means the project can be deployed
+func GetActiveFreeze(project model.Project) (model.FreezeWindow, bool, error) {
+	freezeWindows, err := model.FreezeWindow{NamespaceID: project.NamespaceID, ProjectID: project.ID}.GetScopeList()
+	if err != nil {
+		return model.FreezeWindow{}, false, err
+	}
+	now := time.Now()
+	for _, freezeWindow := range freezeWindows {
+		if freezeWindowCovers(freezeWindow, now) {
+			return freezeWindow, true, nil
+		}
+	}
+	return model.FreezeWindow{}, false, nil
+}
+
+func freezeWindowCovers(freezeWindow model.FreezeWindow, t time.Time) bool {
+	if freezeWindow.Type == model.FreezeRecurring {
+		cron, err := utils.ParseCron(freezeWindow.Cron)
+		if err != nil {
+			core.Log(core.ERROR, "freeze window "+freezeWindow.Cron+" parse fail, "+err.Error())
+			return false
+		}
+		return cron.Match(t)
+	}
+	start, err := time.ParseInLocation(freezeTimeLayout, freezeWindow.StartTime, time.Local)
+	if err != nil {
+		return false
+	}
+	end, err := time.ParseInLocation(freezeTimeLayout, freezeWindow.EndTime, time.Local)
+	if err != nil {
+		return false
+	}
+	return !t.Before(start) && t.Before(end)
+}
+
+// FreezeMessage describe why the project is frozen
+func FreezeMessage(freezeWindow model.FreezeWindow) string {
+	message := "Project is frozen"
+	if freezeWindow.Type == model.FreezeRange {
+		message += " until " + freezeWindow.EndTime
+	} else {
+		message += " by " + freezeWindow.Cron
+	}
+	if len(freezeWindow.Reason) != 0 {
+		message += ", reason: " + freezeWindow.Reason
+	}
+	return message
+}
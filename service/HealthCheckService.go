@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
+	"github.com/zhenorzz/goploy/ws"
+)
+
+// healthCheckBodyLimit is the max size of the response body to match
+const healthCheckBodyLimit = 1 << 20
+
+// CheckHealthCheck check the type and the target template of the health check
+func CheckHealthCheck(checkType uint8, target string) error {
+	if checkType != model.HealthCheckHTTP && checkType != model.HealthCheckTCP {
+		return errors.New("Health check type must be http or tcp")
+	}
+	if _, err := template.New("target").Option("missingkey=error").Parse(target); err != nil {
+		return err
+	}
+	return nil
+}
+
+func healthCheckStage(sync Sync, projectServer model.ProjectServer, publishTraceModel *model.PublishTrace) error {
+	if len(sync.healthChecks) == 0 {
+		return errSkipStage
+	}
+	project := sync.Project
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.HealthCheck, Message: "Health check " + projectServer.ServerName},
+	}
+	var detail strings.Builder
+	for _, healthCheck := range sync.healthChecks {
+		target, err := sync.renderTemplate(projectServer, "target", healthCheck.Target)
+		if err != nil {
+			return err
+		}
+		err = runHealthCheck(sync.ctx, healthCheck, target)
+		if err != nil {
+			detail.WriteString("fail " + target + ", " + err.Error() + "\n")
+			publishTraceModel.Detail = detail.String()
+			return errors.New("health check " + target + " fail, " + err.Error())
+		}
+		detail.WriteString("pass " + target + "\n")
+	}
+	publishTraceModel.Detail = detail.String()
+	return nil
+}
+
+// runHealthCheck retry the check until it passes, the last error is returned
+func runHealthCheck(ctx context.Context, healthCheck model.ProjectHealthCheck, target string) error {
+	timeout := time.Duration(healthCheck.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	var err error
+	for attempt := 0; attempt <= int(healthCheck.Retries); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(healthCheck.Interval) * time.Second):
+			}
+		}
+		if healthCheck.Type == model.HealthCheckTCP {
+			err = utils.DialTCP(target, timeout)
+		} else {
+			err = checkHTTP(ctx, healthCheck, target, timeout)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func checkHTTP(ctx context.Context, healthCheck model.ProjectHealthCheck, target string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if healthCheck.ExpectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+	} else if healthCheck.ExpectStatus != 0 && resp.StatusCode != healthCheck.ExpectStatus {
+		return errors.New("unexpected status " + strconv.Itoa(resp.StatusCode) + ", expect " + strconv.Itoa(healthCheck.ExpectStatus))
+	}
+	if len(healthCheck.ExpectBody) == 0 {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, healthCheckBodyLimit))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(body), healthCheck.ExpectBody) {
+		return errors.New("response body does not contain " + healthCheck.ExpectBody)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"golang.org/x/oauth2"
+)
+
+// oidcStateExpire the time limit to finish the login in the provider
+const oidcStateExpire = 10 * time.Minute
+
+// oidcState the nonce and pkce code verifier of the authorization request
+type oidcState struct {
+	Nonce        string
+	CodeVerifier string
+}
+
+// OIDCAuthenticator login with the authorization code and pkce flow of the OpenID Connect provider
+type OIDCAuthenticator struct {
+	config       oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+	AccountClaim string
+	NameClaim    string
+	MobileClaim  string
+	Namespaces   []model.NamespaceUser
+}
+
+var (
+	oidcAuthenticator *OIDCAuthenticator
+	oidcMutex         sync.Mutex
+)
+
+// GetOIDCAuthenticator discover the provider configured by the OIDC_* in .env,
+// the provider is kept to reuse the cached JWKS, the failed discovery is retried on the next login
+func GetOIDCAuthenticator() (*OIDCAuthenticator, error) {
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	if oidcAuthenticator != nil {
+		return oidcAuthenticator, nil
+	}
+
+	issuer := os.Getenv("OIDC_ISSUER")
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if issuer == "" || clientID == "" {
+		return nil, errors.New("OIDC login is not configured")
+	}
+	namespaces, err := parseOIDCNamespace(os.Getenv("OIDC_DEFAULT_NAMESPACE"))
+	if err != nil {
+		return nil, err
+	}
+	// the provider keeps the context to fetch the JWKS later
+	provider, err := oidc.NewProvider(context.Background(), issuer)
+	if err != nil {
+		return nil, errors.New("OIDC discovery failed, " + err.Error())
+	}
+
+	scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	a := &OIDCAuthenticator{
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:     provider.Verifier(&oidc.Config{ClientID: clientID}),
+		AccountClaim: os.Getenv("OIDC_CLAIM_ACCOUNT"),
+		NameClaim:    os.Getenv("OIDC_CLAIM_NAME"),
+		MobileClaim:  os.Getenv("OIDC_CLAIM_MOBILE"),
+		Namespaces:   namespaces,
+	}
+	if a.AccountClaim == "" {
+		a.AccountClaim = "preferred_username"
+	}
+	if a.NameClaim == "" {
+		a.NameClaim = "name"
+	}
+	oidcAuthenticator = a
+	return oidcAuthenticator, nil
+}
+
+// parseOIDCNamespace parse the namespace id:role list separated by semicolon
+func parseOIDCNamespace(mapping string) ([]model.NamespaceUser, error) {
+	var namespaces []model.NamespaceUser
+	for _, item := range strings.Split(mapping, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 2 {
+			return nil, errors.New("Invalid OIDC_DEFAULT_NAMESPACE " + item + ", the format is namespace id:role")
+		}
+		namespaceID, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil || namespaceID <= 0 {
+			return nil, errors.New("Invalid namespace id in OIDC_DEFAULT_NAMESPACE " + item)
+		}
+		role := strings.TrimSpace(fields[1])
+		if roleRank(role) == len(core.Roles) {
+			return nil, errors.New("Invalid role in OIDC_DEFAULT_NAMESPACE " + item)
+		}
+		namespaces = append(namespaces, model.NamespaceUser{NamespaceID: namespaceID, Role: role})
+	}
+	return namespaces, nil
+}
+
+// AuthCodeURL return the provider login url, the state is cached with the nonce and the pkce code verifier
+func (a *OIDCAuthenticator) AuthCodeURL() (string, error) {
+	state, err := randomURLString(16)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomURLString(16)
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := randomURLString(32)
+	if err != nil {
+		return "", err
+	}
+	core.Cache.Set("oidc:"+state, oidcState{Nonce: nonce, CodeVerifier: codeVerifier}, oidcStateExpire)
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	return a.config.AuthCodeURL(
+		state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+// Callback exchange the code, verify the id token against the provider JWKS
+// and return the user mapped from the claims
+func (a *OIDCAuthenticator) Callback(ctx context.Context, state, code string) (model.User, error) {
+	cached, ok := core.Cache.Get("oidc:" + state)
+	if !ok || state == "" {
+		return model.User{}, errors.New("Login state is invalid or expired, please login again")
+	}
+	// the state is used only once
+	core.Cache.Delete("oidc:" + state)
+	s := cached.(oidcState)
+
+	token, err := a.config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", s.CodeVerifier))
+	if err != nil {
+		return model.User{}, errors.New("OIDC code exchange failed, " + err.Error())
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return model.User{}, errors.New("OIDC provider does not return the id token")
+	}
+	idToken, err := a.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return model.User{}, errors.New("Invalid id token, " + err.Error())
+	}
+	if idToken.Nonce != s.Nonce {
+		return model.User{}, errors.New("Invalid id token nonce")
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return model.User{}, err
+	}
+	account, _ := claims[a.AccountClaim].(string)
+	if account == "" {
+		return model.User{}, errors.New("The id token has no " + a.AccountClaim + " claim")
+	} else if len(account) > 30 {
+		return model.User{}, errors.New("The account " + account + " is longer than 30")
+	}
+	name, _ := claims[a.NameClaim].(string)
+	var mobile string
+	if a.MobileClaim != "" {
+		mobile, _ = claims[a.MobileClaim].(string)
+	}
+
+	userData, created, err := provisionUser(account, name, mobile)
+	if err != nil || !created {
+		return userData, err
+	}
+
+	// the default namespace is granted on first login, it is managed in goploy later
+	namespaceUsersModel := model.NamespaceUsers{}
+	for _, namespaceUser := range a.Namespaces {
+		namespaceUser.UserID = userData.ID
+		namespaceUsersModel = append(namespaceUsersModel, namespaceUser)
+	}
+	if err := namespaceUsersModel.AddMany(); err != nil {
+		return userData, err
+	}
+	core.DeleteNamespaceCache(userData.ID)
+	for _, namespaceUser := range namespaceUsersModel {
+		if namespaceUser.Role == core.RoleManager {
+			if err := (model.ProjectUser{}).AddNamespaceProjectInUserID(namespaceUser.NamespaceID, []int64{userData.ID}); err != nil {
+				return userData, err
+			}
+		}
+	}
+	return userData, nil
+}
+
+// randomURLString return the base64url of n random bytes
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
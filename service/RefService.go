@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
+	"github.com/zhenorzz/goploy/ws"
+)
+
+var refRegexp = regexp.MustCompile(`^[A-Za-z0-9_.][A-Za-z0-9_./-]*$`)
+
+// CheckRef check the ref is a branch, a tag or a commit name which can be passed to git safely
+func CheckRef(ref string) error {
+	if len(ref) != 0 && (!refRegexp.MatchString(ref) || strings.Contains(ref, "..")) {
+		return errors.New("Ref must be a branch, a tag or a commit")
+	}
+	return nil
+}
+
+// CheckTagPattern check the shell pattern matching the tags which the webhook deploys, e.g. v*
+func CheckTagPattern(tagPattern string) error {
+	if _, err := path.Match(tagPattern, ""); err != nil {
+		return errors.New("Invalid tag pattern, " + err.Error())
+	}
+	return nil
+}
+
+// repositoryLocks serialize the git commands on the repository of the project, key is project id
+var repositoryLocks = struct {
+	sync.Mutex
+	locks map[int64]*sync.Mutex
+}{locks: map[int64]*sync.Mutex{}}
+
+// LockRepository lock the repository of the project until the returned func is called,
+// the deploy, the approval summary and the commit list share the same working copy
+func LockRepository(projectID int64) func() {
+	repositoryLocks.Lock()
+	lock, ok := repositoryLocks.locks[projectID]
+	if !ok {
+		lock = &sync.Mutex{}
+		repositoryLocks.locks[projectID] = lock
+	}
+	repositoryLocks.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// gitFetch update the remote branches and the tags of the cached repository
+func gitFetch(ctx context.Context, project model.Project) error {
+	git := utils.GIT{Dir: core.RepositoryPath + project.Name, Ctx: ctx}
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.GitPull, Message: "git fetch"},
+	}
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(project.ID, 10)+" git fetch --prune --tags --force origin")
+	if err := git.Run("fetch", []string{"--prune", "--tags", "--force", "origin"}); err != nil {
+		core.Log(core.ERROR, err.Error()+", detail: "+git.Err.String())
+		return errors.New(git.Err.String())
+	}
+	return nil
+}
+
+// resolveRef return the commit of the remote branch, the tag or the commit, in that order
+func resolveRef(ctx context.Context, project model.Project, ref string) (string, error) {
+	git := utils.GIT{Dir: core.RepositoryPath + project.Name, Ctx: ctx}
+	for _, rev := range []string{"refs/remotes/origin/" + ref, "refs/tags/" + ref, ref} {
+		if err := git.Run("rev-parse", []string{"--verify", "--quiet", rev + "^{commit}"}); err == nil {
+			return strings.TrimSpace(git.Output.String()), nil
+		}
+	}
+	return "", errors.New("Ref " + ref + " is not found in the repository")
+}
+
+// gitCheckoutRef check out the ref on a detached HEAD, the project branch is kept for the next pull
+func gitCheckoutRef(ctx context.Context, project model.Project, ref string) (utils.Commit, error) {
+	if err := gitCreate(ctx, project); err != nil {
+		return utils.Commit{}, err
+	}
+	if err := gitFetch(ctx, project); err != nil {
+		return utils.Commit{}, err
+	}
+	commit, err := resolveRef(ctx, project, ref)
+	if err != nil {
+		return utils.Commit{}, err
+	}
+	git := utils.GIT{Dir: core.RepositoryPath + project.Name, Ctx: ctx}
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.GitCheckout, Message: "git checkout " + ref},
+	}
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(project.ID, 10)+" git checkout --force --detach "+commit)
+	if err := git.Clean([]string{"-f"}); err != nil {
+		core.Log(core.ERROR, err.Error()+", detail: "+git.Err.String())
+		return utils.Commit{}, errors.New(git.Err.String())
+	}
+	if err := git.Checkout([]string{"--force", "--detach", commit}); err != nil {
+		core.Log(core.ERROR, err.Error()+", detail: "+git.Err.String())
+		return utils.Commit{}, errors.New(git.Err.String())
+	}
+	gitCommitInfo, err := gitCommitLog(ctx, project)
+	if err != nil {
+		return utils.Commit{}, err
+	}
+	ws.GetHub().Data <- &ws.Data{
+		Type: ws.TypeProject,
+		Message: ws.ProjectMessage{
+			ProjectID:   project.ID,
+			ProjectName: project.Name,
+			State:       ws.GitPull,
+			Message:     "Get pull info",
+			Ext:         gitCommitInfo,
+		},
+	}
+	return gitCommitInfo, nil
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+)
+
+// Release is a release directory under the symlink path
+type Release struct {
+	Token      string `json:"token"`
+	Size       int64  `json:"size"`
+	ModifyTime string `json:"modifyTime"`
+	Active     bool   `json:"active"`
+	Pinned     bool   `json:"pinned"`
+	Expired    bool   `json:"expired"`
+}
+
+// ServerRelease the releases retained on the server
+type ServerRelease struct {
+	ServerID   int64     `json:"serverId"`
+	ServerName string    `json:"serverName"`
+	Current    string    `json:"current"`
+	Releases   []Release `json:"releases"`
+	Error      string    `json:"error"`
+}
+
+// ServerPrune the releases removed from the server
+type ServerPrune struct {
+	ServerID   int64    `json:"serverId"`
+	ServerName string   `json:"serverName"`
+	Removed    []string `json:"removed"`
+	Error      string   `json:"error"`
+}
+
+// GetReleaseList list the releases under the symlink path of each server, newest first
+func GetReleaseList(project model.Project, projectServers model.ProjectServers) []ServerRelease {
+	ch := make(chan ServerRelease, len(projectServers))
+	for _, projectServer := range projectServers {
+		go func(projectServer model.ProjectServer) {
+			serverRelease := ServerRelease{ServerID: projectServer.ServerID, ServerName: projectServer.ServerName, Releases: []Release{}}
+			current, releases, err := listReleases(context.Background(), project, projectServer)
+			if err != nil {
+				serverRelease.Error = err.Error()
+			} else {
+				serverRelease.Current = current
+				serverRelease.Releases = releases
+			}
+			ch <- serverRelease
+		}(projectServer)
+	}
+
+	// keep the order of the project servers
+	releaseMap := map[int64]ServerRelease{}
+	for i := 0; i < len(projectServers); i++ {
+		serverRelease := <-ch
+		releaseMap[serverRelease.ServerID] = serverRelease
+	}
+	serverReleases := make([]ServerRelease, 0, len(projectServers))
+	for _, projectServer := range projectServers {
+		serverReleases = append(serverReleases, releaseMap[projectServer.ServerID])
+	}
+	return serverReleases
+}
+
+// PruneReleases remove the expired releases on each server, each server record a publish trace,
+// it takes the same claim as the deploy so the release being deployed is never removed,
+// return false when the project is deploying
+func (sync Sync) PruneReleases() ([]ServerPrune, bool, error) {
+	previousState := sync.Project.DeployState
+	// the prune traces are recorded under the token of the claim
+	if claimed, err := ClaimProject(&sync.Project, sync.UserInfo); err != nil || !claimed {
+		return nil, claimed, err
+	}
+	var release func()
+	sync.ctx, release = newDeployContext(sync.Project.ID)
+	serverPrunes := sync.pruneServers()
+	if err := (model.Project{ID: sync.Project.ID, DeployState: previousState}).RestoreClaim(sync.Project.LastPublishToken); err != nil {
+		core.Log(core.ERROR, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" restore the deploy state after the prune fail, "+err.Error())
+	}
+	release()
+	go dispatchNext(sync.Project.ID)
+	return serverPrunes, true, nil
+}
+
+func (sync Sync) pruneServers() []ServerPrune {
+	ch := make(chan ServerPrune, len(sync.ProjectServers))
+	for _, projectServer := range sync.ProjectServers {
+		go func(projectServer model.ProjectServer) {
+			serverPrune := ServerPrune{ServerID: projectServer.ServerID, ServerName: projectServer.ServerName, Removed: []string{}}
+			publishTraceModel := sync.newPublishTrace(model.Clean)
+			removed, script, err := pruneReleases(sync.ctx, sync.Project, projectServer)
+			publishTraceModel.Ext = pruneExt(projectServer, removed, script)
+			if err != nil {
+				core.Log(core.ERROR, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" prune "+projectServer.ServerName+" fail, "+err.Error())
+				serverPrune.Error = err.Error()
+				publishTraceModel.Detail = err.Error()
+				publishTraceModel.State = model.Fail
+			} else {
+				serverPrune.Removed = removed
+				publishTraceModel.Detail = "Remove " + strconv.Itoa(len(removed)) + " releases"
+				publishTraceModel.State = model.Success
+			}
+			if _, err := publishTraceModel.AddRow(); err != nil {
+				core.Log(core.ERROR, err.Error())
+			}
+			ch <- serverPrune
+		}(projectServer)
+	}
+
+	pruneMap := map[int64]ServerPrune{}
+	for i := 0; i < len(sync.ProjectServers); i++ {
+		serverPrune := <-ch
+		pruneMap[serverPrune.ServerID] = serverPrune
+	}
+	serverPrunes := make([]ServerPrune, 0, len(sync.ProjectServers))
+	for _, projectServer := range sync.ProjectServers {
+		serverPrunes = append(serverPrunes, pruneMap[projectServer.ServerID])
+	}
+	return serverPrunes
+}
+
+func pruneExt(projectServer model.ProjectServer, removed []string, script string) string {
+	ext, _ := json.Marshal(struct {
+		ServerID   int64    `json:"serverId"`
+		ServerName string   `json:"serverName"`
+		Removed    []string `json:"removed"`
+		Script     string   `json:"script"`
+	}{projectServer.ServerID, projectServer.ServerName, removed, script})
+	return string(ext)
+}
+
+// listReleases return the active release token and the releases on the server, newest first
+func listReleases(ctx context.Context, project model.Project, projectServer model.ProjectServer) (string, []Release, error) {
+	releaseDir := path.Join(project.SymlinkPath, project.Name)
+	script := "echo $(readlink " + project.Path + ");" +
+		"cd " + releaseDir + " && ls -t | while read name; do echo \"$name $(stat -c %Y \"$name\") $(du -sk \"$name\" | cut -f1)\"; done"
+	output, err := runRemoteScript(ctx, projectServer, script)
+	if err != nil {
+		return "", nil, err
+	}
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	current := ""
+	if strings.HasPrefix(lines[0], releaseDir+"/") {
+		current = path.Base(lines[0])
+	}
+
+	pinned := map[string]bool{}
+	for _, token := range project.GetPinnedReleases() {
+		pinned[token] = true
+	}
+	releases := []Release{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		modifyTime, _ := strconv.ParseInt(fields[1], 10, 64)
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		release := Release{
+			Token:      fields[0],
+			Size:       size,
+			ModifyTime: time.Unix(modifyTime, 0).Format("2006-01-02 15:04:05"),
+			Active:     fields[0] == current,
+			Pinned:     pinned[fields[0]],
+		}
+		release.Expired = isReleaseExpired(project, len(releases), time.Unix(modifyTime, 0)) && !release.Active && !release.Pinned
+		releases = append(releases, release)
+	}
+	return current, releases, nil
+}
+
+// isReleaseExpired a release is expired when it is beyond the retain count and older than the retain days,
+// zero value means no limit, nothing is expired when both are zero
+func isReleaseExpired(project model.Project, index int, modifyTime time.Time) bool {
+	if project.RetainCount == 0 && project.RetainDays == 0 {
+		return false
+	}
+	if project.RetainCount != 0 && index < int(project.RetainCount) {
+		return false
+	}
+	if project.RetainDays != 0 && time.Since(modifyTime) < time.Duration(project.RetainDays)*24*time.Hour {
+		return false
+	}
+	return true
+}
+
+// pruneReleases remove the expired releases on the server, return the removed tokens and the script
+func pruneReleases(ctx context.Context, project model.Project, projectServer model.ProjectServer) ([]string, string, error) {
+	_, releases, err := listReleases(ctx, project, projectServer)
+	if err != nil {
+		return nil, "", err
+	}
+	var removed []string
+	var quoted []string
+	for _, release := range releases {
+		if !release.Expired {
+			continue
+		}
+		if strings.ContainsAny(release.Token, "'/") {
+			return nil, "", errors.New("Invalid release directory name " + release.Token)
+		}
+		removed = append(removed, release.Token)
+		quoted = append(quoted, "'"+release.Token+"'")
+	}
+	if len(removed) == 0 {
+		return []string{}, "", nil
+	}
+	script := "cd " + path.Join(project.SymlinkPath, project.Name) + " && rm -rf " + strings.Join(quoted, " ")
+	if _, err := runRemoteScript(ctx, projectServer, script); err != nil {
+		return nil, script, err
+	}
+	return removed, script, nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
+)
+
+// secretMinLength avoid masking the common short words in the log
+const secretMinLength = 4
+
+// secretCipher return the AES-GCM cipher with the key derived from SECRET_KEY
+func secretCipher() (cipher.AEAD, error) {
+	secretKey := os.Getenv("SECRET_KEY")
+	if len(secretKey) == 0 {
+		return nil, errors.New("SECRET_KEY is not configured")
+	}
+	key := sha256.Sum256([]byte(secretKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret return the base64 of the nonce and the sealed value
+func EncryptSecret(value string) (string, error) {
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+func decryptSecret(encrypted string) (string, error) {
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("Secret is corrupted")
+	}
+	value, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("Secret can not be decrypted, SECRET_KEY may be changed")
+	}
+	return string(value), nil
+}
+
+// CheckSecretName check the secret name can be used as environment variable
+func CheckSecretName(name string) error {
+	if !variableNameRegexp.MatchString(name) {
+		return errors.New("Secret name must contain only letters, digits and underscore, and not start with a digit")
+	}
+	if strings.HasPrefix(name, builtinVariablePrefix) {
+		return errors.New("Secret " + name + " uses the reserved prefix " + builtinVariablePrefix)
+	}
+	if deniedVariableName(name) {
+		return errors.New("Secret " + name + " is not allowed, it changes how the script runs")
+	}
+	return nil
+}
+
+// CheckSecretValue check the secret value is long enough to be masked
+func CheckSecretValue(value string) error {
+	if len(value) < secretMinLength {
+		return errors.New("Secret value must be at least " + strconv.Itoa(secretMinLength) + " characters")
+	}
+	return nil
+}
+
+// loadSecrets decrypt the secrets of the scope and mask their values from now on,
+// projectID 0 load the namespace secrets only
+func loadSecrets(namespaceID, projectID int64) ([]variable, error) {
+	secrets, err := model.Secret{NamespaceID: namespaceID, ProjectID: projectID}.GetScopeList()
+	if err != nil {
+		return nil, err
+	}
+	var variables []variable
+	for _, secret := range secrets {
+		value, err := decryptSecret(secret.Value)
+		if err != nil {
+			return nil, errors.New(secret.Name + ": " + err.Error())
+		}
+		utils.AddMask(value)
+		variables = append(variables, variable{name: secret.Name, value: value})
+	}
+	return variables, nil
+}
+
+// SecretExportCommand return the command which exports the named namespace secrets before the script
+// and the stdin of the ssh session carrying the secrets, it is used by the server install template,
+// the template is shared by all namespaces so only the secrets picked by the installer are exported
+func SecretExportCommand(namespaceID int64, names []string, script string) (string, string, error) {
+	if len(names) == 0 {
+		return script, "", nil
+	}
+	secrets, err := loadSecrets(namespaceID, 0)
+	if err != nil {
+		return "", "", err
+	}
+	var picked []variable
+	for _, name := range names {
+		found := false
+		for _, secret := range secrets {
+			if secret.name == name {
+				picked = append(picked, secret)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", "", errors.New("Secret " + name + " is not in the namespace")
+		}
+	}
+	command, stdin := exportCommand(picked, script)
+	return command, stdin, nil
+}
@@ -2,6 +2,8 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"github.com/zhenorzz/goploy/core"
@@ -16,6 +18,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Sync -
@@ -24,135 +27,623 @@ type Sync struct {
 	Project        model.Project
 	ProjectServers model.ProjectServers
 	CommitID       string
+	// Ref is the branch, the tag or the commit to deploy, empty means the project branch
+	Ref string
+	// ctx is done when the deploy is cancelled
+	ctx context.Context
+	// artifact is shared by the stages of the deploy in artifact mode
+	artifact *artifact
+	// secrets of the namespace and the project, injected into the scripts
+	secrets []variable
+	// configTemplates are rendered for each server after the files are transferred
+	configTemplates model.ProjectConfigTemplates
+	// healthChecks run against each server after the symlink switch
+	healthChecks model.ProjectHealthChecks
 }
 
 type syncMessage struct {
-	serverName string
-	projectID  int64
-	detail     string
-	state      int
+	serverName    string
+	projectID     int64
+	detail        string
+	state         int
+	projectServer model.ProjectServer
+	// the symlink has been pointed to the new release
+	switched bool
+}
+
+// stage is a step of the deploy pipeline,
+// local stage runs once in the repository, remote stage runs on each project server
+type stage struct {
+	traceType int
+	// optional stage records its trace but never fails the deploy
+	optional bool
+	local    func(sync Sync, publishTraceModel *model.PublishTrace) error
+	remote   func(sync Sync, projectServer model.ProjectServer, publishTraceModel *model.PublishTrace) error
+}
+
+// errSkipStage returned by a stage which has nothing to do, no trace will be recorded
+var errSkipStage = errors.New("skip stage")
+
+var stages = map[string]stage{
+	model.StageBeforePull:   {traceType: model.BeforePull, local: beforePullStage},
+	model.StageGit:          {traceType: model.Pull, local: gitStage},
+	model.StageBuild:        {traceType: model.AfterPull, local: afterPullStage},
+	model.StagePackage:      {traceType: model.PackArtifact, local: packageStage},
+	model.StageBeforeDeploy: {traceType: model.BeforeDeploy, remote: beforeDeployStage},
+	model.StageTransfer:     {traceType: model.Deploy, remote: transferStage},
+	model.StageAfterDeploy:  {traceType: model.AfterDeploy, remote: afterDeployStage},
+	model.StageHealthCheck:  {traceType: model.HealthCheck, remote: healthCheckStage},
+	model.StageClean:        {traceType: model.Clean, remote: cleanStage, optional: true},
+}
+
+// CheckPipeline check the stages of pipeline are known and not repeated
+func CheckPipeline(pipeline string) error {
+	if len(pipeline) == 0 {
+		return nil
+	}
+	exist := map[string]bool{}
+	for _, name := range strings.Split(pipeline, ",") {
+		if _, ok := stages[name]; !ok {
+			return errors.New("Unknown pipeline stage: " + name)
+		}
+		if exist[name] {
+			return errors.New("Repeated pipeline stage: " + name)
+		}
+		exist[name] = true
+	}
+	if !exist[model.StageGit] {
+		return errors.New("Pipeline must contain the git stage")
+	}
+	return nil
+}
+
+// CheckStageTimeout check the stage timeout format, e.g. git:300,transfer:600
+func CheckStageTimeout(stageTimeout string) error {
+	if len(stageTimeout) == 0 {
+		return nil
+	}
+	for _, item := range strings.Split(stageTimeout, ",") {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return errors.New("Invalid stage timeout: " + item)
+		}
+		if _, ok := stages[strings.TrimSpace(kv[0])]; !ok {
+			return errors.New("Unknown stage timeout: " + kv[0])
+		}
+		if second, err := strconv.Atoi(strings.TrimSpace(kv[1])); err != nil || second <= 0 {
+			return errors.New("Stage timeout must be a positive number of seconds: " + item)
+		}
+	}
+	return nil
+}
+
+// stageTimeoutError is returned when the stage exceeds its timeout
+type stageTimeoutError struct {
+	stage   string
+	timeout time.Duration
+}
+
+func (e stageTimeoutError) Error() string {
+	return e.stage + " stage timeout after " + e.timeout.String()
 }
 
 // Exec Sync
 func (sync Sync) Exec() {
 	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" deploy start")
-	publishTraceModel := model.PublishTrace{
+	var release func()
+	sync.ctx, release = newDeployContext(sync.Project.ID)
+	defer release()
+	secrets, err := loadSecrets(sync.Project.NamespaceID, sync.Project.ID)
+	if err != nil {
+		sync.deployFail("load secrets fail, " + err.Error())
+		return
+	}
+	sync.secrets = secrets
+	sync.configTemplates, err = model.ProjectConfigTemplate{ProjectID: sync.Project.ID}.GetListByProjectID()
+	if err != nil {
+		sync.deployFail("load config templates fail, " + err.Error())
+		return
+	}
+	sync.healthChecks, err = model.ProjectHealthCheck{ProjectID: sync.Project.ID}.GetListByProjectID()
+	if err != nil {
+		sync.deployFail("load health checks fail, " + err.Error())
+		return
+	}
+	sync.artifact = &artifact{}
+	// redeploy the stored artifact without cloning and building
+	if sync.Project.ArtifactMode == model.Enable && len(sync.CommitID) != 0 {
+		if stored, err := loadArtifact(sync.Project, sync.CommitID); err == nil {
+			*sync.artifact = stored
+			sync.artifact.reused = true
+			sync.reuseArtifact()
+		}
+	}
+	// the continuous remote stages run together on each server
+	var remoteStages []string
+	for _, name := range sync.Project.GetPipeline() {
+		if err := sync.ctx.Err(); err != nil {
+			sync.deployFail(err.Error())
+			return
+		}
+		if sync.artifact.reused && stages[name].local != nil {
+			continue
+		}
+		if stages[name].remote != nil {
+			remoteStages = append(remoteStages, name)
+			continue
+		}
+		if err := sync.runRemoteStages(remoteStages); err != nil {
+			sync.deployFail(err.Error())
+			return
+		}
+		remoteStages = nil
+		if err := sync.runLocalStage(name); err != nil {
+			sync.deployFail(err.Error())
+			return
+		}
+	}
+	if err := sync.runRemoteStages(remoteStages); err != nil {
+		sync.deployFail(err.Error())
+		return
+	}
+	sync.Project.DeploySuccess()
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" deploy success")
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.ProjectSuccess, Message: "Success"},
+	}
+	go notify(sync.Project, model.ProjectSuccess, "")
+	go dispatchNext(sync.Project.ID)
+}
+
+func (sync Sync) deployFail(message string) {
+	if sync.ctx != nil && sync.ctx.Err() != nil {
+		sync.deployCancel()
+		return
+	}
+	sync.Project.DeployFail()
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" deploy fail")
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.ProjectFail, Message: message},
+	}
+	go notify(sync.Project, model.ProjectFail, message)
+	go dispatchNext(sync.Project.ID)
+}
+
+func (sync Sync) deployCancel() {
+	sync.Project.DeployCancel()
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" deploy cancelled")
+	publishTraceModel := sync.newPublishTrace(0)
+	publishTraceModel.Detail = "cancelled by user"
+	publishTraceModel.State = model.Cancel
+	if _, err := publishTraceModel.AddRow(); err != nil {
+		core.Log(core.ERROR, err.Error())
+	}
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.ProjectCancel, Message: "Cancelled"},
+	}
+	go dispatchNext(sync.Project.ID)
+}
+
+// setTraceError record the stage error, the stage interrupted by cancel or timeout has its own state
+func (sync Sync) setTraceError(publishTraceModel *model.PublishTrace, err error) {
+	publishTraceModel.Detail = err.Error()
+	publishTraceModel.State = model.Fail
+	if _, ok := err.(stageTimeoutError); ok {
+		publishTraceModel.State = model.Timeout
+	} else if sync.ctx != nil && sync.ctx.Err() != nil {
+		publishTraceModel.State = model.Cancel
+	}
+}
+
+// runStage run the stage within the stage timeout of the project
+func (sync Sync) runStage(name string, run func(stageSync Sync) error) error {
+	timeout := sync.Project.GetStageTimeout(name)
+	if timeout == 0 {
+		return run(sync)
+	}
+	stageSync := sync
+	var cancel context.CancelFunc
+	stageSync.ctx, cancel = context.WithTimeout(sync.ctx, timeout)
+	defer cancel()
+	err := run(stageSync)
+	if err != nil && err != errSkipStage && stageSync.ctx.Err() == context.DeadlineExceeded {
+		core.Log(core.ERROR, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" "+name+" stage timeout, "+err.Error())
+		return stageTimeoutError{stage: name, timeout: timeout}
+	}
+	return err
+}
+
+func (sync Sync) newPublishTrace(traceType int) model.PublishTrace {
+	return model.PublishTrace{
 		Token:         sync.Project.LastPublishToken,
 		ProjectID:     sync.Project.ID,
 		ProjectName:   sync.Project.Name,
 		PublisherID:   sync.UserInfo.ID,
 		PublisherName: sync.UserInfo.Name,
-		Type:          model.Pull,
+		Type:          traceType,
 	}
-	var gitCommitInfo utils.Commit
-	var err error
-	if len(sync.CommitID) == 0 {
-		gitCommitInfo, err = gitSync(sync.Project)
-	} else {
-		gitCommitInfo, err = gitRollback(sync.CommitID, sync.Project)
+}
+
+func (sync Sync) runLocalStage(name string) error {
+	publishTraceModel := sync.newPublishTrace(stages[name].traceType)
+	err := sync.runStage(name, func(stageSync Sync) error {
+		// the local stages change the working copy of the repository
+		defer LockRepository(stageSync.Project.ID)()
+		return stages[name].local(stageSync, &publishTraceModel)
+	})
+	if err == errSkipStage {
+		return nil
 	}
 	if err != nil {
-		sync.Project.DeployFail()
-		publishTraceModel.Detail = err.Error()
-		publishTraceModel.State = model.Fail
-		ws.GetHub().Data <- &ws.Data{
-			Type:    ws.TypeProject,
-			Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.ProjectFail, Message: err.Error()},
-		}
-		if _, err := publishTraceModel.AddRow(); err != nil {
-			core.Log(core.ERROR, err.Error())
-		}
-		go notify(sync.Project, model.ProjectFail, err.Error())
-		return
+		sync.setTraceError(&publishTraceModel, err)
+	} else {
+		publishTraceModel.State = model.Success
 	}
-	ext, _ := json.Marshal(gitCommitInfo)
-	publishTraceModel.Ext = string(ext)
-	publishTraceModel.State = model.Success
 	if _, err := publishTraceModel.AddRow(); err != nil {
 		core.Log(core.ERROR, err.Error())
 	}
-	if sync.Project.AfterPullScript != "" {
+	if stages[name].optional {
+		return nil
+	}
+	return err
+}
+
+// batches split the project servers by the deploy strategy
+func (sync Sync) batches() []model.ProjectServers {
+	var batches []model.ProjectServers
+	projectServers := sync.ProjectServers
+	if sync.Project.DeployStrategy == model.StrategyCanary && len(projectServers) > 1 {
+		batches = append(batches, projectServers[:1])
+		projectServers = projectServers[1:]
+	}
+	size := len(projectServers)
+	if sync.Project.DeployStrategy != model.StrategyAllAtOnce && sync.Project.BatchSize > 0 {
+		size = int(sync.Project.BatchSize)
+	}
+	for len(projectServers) > 0 {
+		if size > len(projectServers) {
+			size = len(projectServers)
+		}
+		batches = append(batches, projectServers[:size])
+		projectServers = projectServers[size:]
+	}
+	return batches
+}
+
+func (sync Sync) runRemoteStages(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	batches := sync.batches()
+	var switchedServers model.ProjectServers
+	for index, projectServers := range batches {
+		batch := index + 1
+		if batch == 2 && sync.Project.DeployStrategy == model.StrategyCanary && sync.Project.CanaryConfirm == model.Enable {
+			ws.GetHub().Data <- &ws.Data{
+				Type:    ws.TypeProject,
+				Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.CanaryConfirm, Message: "Wait for canary confirmation"},
+			}
+			core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" wait for canary confirmation")
+			if !waitCanaryConfirm(sync.ctx, sync.Project.ID) {
+				return errors.New("Canary is rejected")
+			}
+		}
+
+		var serverNames []string
+		for _, projectServer := range projectServers {
+			serverNames = append(serverNames, projectServer.ServerName)
+		}
 		ws.GetHub().Data <- &ws.Data{
-			Type:    ws.TypeProject,
-			Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.AfterPullScript, Message: "Run pull script"},
+			Type: ws.TypeProject,
+			Message: ws.ProjectMessage{
+				ProjectID:   sync.Project.ID,
+				ProjectName: sync.Project.Name,
+				State:       ws.DeployBatch,
+				Message:     "Deploy batch " + strconv.Itoa(batch) + "/" + strconv.Itoa(len(batches)),
+				Ext: struct {
+					Batch      int      `json:"batch"`
+					BatchTotal int      `json:"batchTotal"`
+					Servers    []string `json:"servers"`
+				}{batch, len(batches), serverNames},
+			},
+		}
+
+		ch := make(chan syncMessage, len(projectServers))
+		for _, projectServer := range projectServers {
+			go sync.remoteSync(ch, names, batch, projectServer)
+		}
+
+		message := ""
+		for i := 0; i < len(projectServers); i++ {
+			syncMessage := <-ch
+			if syncMessage.state == model.ProjectFail {
+				message += syncMessage.serverName + " error message: " + syncMessage.detail
+			}
+			if syncMessage.switched {
+				switchedServers = append(switchedServers, syncMessage.projectServer)
+			}
+		}
+		// stop the following batches
+		if message != "" {
+			if sync.Project.AutoRollback == model.Enable && len(switchedServers) > 0 {
+				message += ", " + sync.rollback(switchedServers)
+			}
+			return errors.New("Batch " + strconv.Itoa(batch) + " fail, " + message)
+		}
+	}
+	return nil
+}
+
+func (sync Sync) remoteSync(chInput chan<- syncMessage, names []string, batch int, projectServer model.ProjectServer) {
+	switched := false
+	for _, name := range names {
+		if err := sync.ctx.Err(); err != nil {
+			chInput <- syncMessage{
+				serverName:    projectServer.ServerName,
+				projectID:     sync.Project.ID,
+				detail:        err.Error(),
+				state:         model.ProjectFail,
+				projectServer: projectServer,
+				switched:      switched,
+			}
+			return
 		}
-		outputString, err := runAfterPullScript(sync.Project)
-		publishTraceModel.Type = model.AfterPull
+		publishTraceModel := sync.newPublishTrace(stages[name].traceType)
+		publishTraceModel.Batch = batch
 		ext, _ := json.Marshal(struct {
-			Script string `json:"script"`
-		}{sync.Project.AfterPullScript})
+			ServerID   int64  `json:"serverId"`
+			ServerName string `json:"serverName"`
+		}{projectServer.ServerID, projectServer.ServerName})
 		publishTraceModel.Ext = string(ext)
+		err := sync.runStage(name, func(stageSync Sync) error {
+			return stages[name].remote(stageSync, projectServer, &publishTraceModel)
+		})
+		if err == errSkipStage {
+			continue
+		}
+		// the symlink is switched before the after deploy script runs
+		if name == model.StageAfterDeploy && len(sync.Project.SymlinkPath) != 0 {
+			switched = true
+		}
 		if err != nil {
-			sync.Project.DeployFail()
-			publishTraceModel.Detail = err.Error()
-			publishTraceModel.State = model.Fail
-			ws.GetHub().Data <- &ws.Data{
-				Type:    ws.TypeProject,
-				Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.ProjectFail, Message: err.Error()},
-			}
-			if _, err := publishTraceModel.AddRow(); err != nil {
-				core.Log(core.ERROR, err.Error())
+			sync.setTraceError(&publishTraceModel, err)
+		} else {
+			publishTraceModel.State = model.Success
+		}
+		if _, err := publishTraceModel.AddRow(); err != nil {
+			core.Log(core.ERROR, err.Error())
+		}
+		if err != nil && !stages[name].optional {
+			chInput <- syncMessage{
+				serverName:    projectServer.ServerName,
+				projectID:     sync.Project.ID,
+				detail:        err.Error(),
+				state:         model.ProjectFail,
+				projectServer: projectServer,
+				switched:      switched,
 			}
-			go notify(sync.Project, model.ProjectFail, err.Error())
 			return
 		}
-		publishTraceModel.Detail = outputString
-		publishTraceModel.State = model.Success
+	}
+	chInput <- syncMessage{
+		serverName:    projectServer.ServerName,
+		projectID:     sync.Project.ID,
+		state:         model.ProjectSuccess,
+		projectServer: projectServer,
+		switched:      switched,
+	}
+}
+
+// SwitchRelease point the symlink of the project servers to a retained release
+func (sync Sync) SwitchRelease(token string) {
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" switch release to "+token)
+	var release func()
+	sync.ctx, release = newDeployContext(sync.Project.ID)
+	defer release()
+	secrets, err := loadSecrets(sync.Project.NamespaceID, sync.Project.ID)
+	if err != nil {
+		sync.deployFail("load secrets fail, " + err.Error())
+		return
+	}
+	sync.secrets = secrets
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.SwitchRelease, Message: "Switch release to " + token},
+	}
+	if err := sync.switchRelease(sync.ctx, sync.ProjectServers, token, model.SwitchRelease); err != nil {
+		sync.deployFail(err.Error())
+		return
+	}
+	sync.Project.DeploySuccess()
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" switch release success")
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.ProjectSuccess, Message: "Success"},
+	}
+	go notify(sync.Project, model.ProjectSuccess, "")
+	go dispatchNext(sync.Project.ID)
+}
+
+// rollback point the symlink of the servers to the previous release and rerun the after deploy script,
+// return the rollback result
+func (sync Sync) rollback(projectServers model.ProjectServers) string {
+	project := sync.Project
+	previousToken, err := model.PublishTrace{ProjectID: project.ID, Token: project.LastPublishToken}.GetPreviousToken()
+	if err != nil {
+		core.Log(core.ERROR, "projectID:"+strconv.FormatInt(project.ID, 10)+" rollback fail, "+err.Error())
+		publishTraceModel := sync.newPublishTrace(model.Rollback)
+		publishTraceModel.Detail = "no previous release to roll back to, " + err.Error()
+		publishTraceModel.State = model.Fail
 		if _, err := publishTraceModel.AddRow(); err != nil {
 			core.Log(core.ERROR, err.Error())
 		}
+		return "rollback fail, no previous release"
 	}
 
 	ws.GetHub().Data <- &ws.Data{
 		Type:    ws.TypeProject,
-		Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.Rsync, Message: "Rsync"},
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.Rollback, Message: "Rollback to " + previousToken},
+	}
+
+	if err := sync.switchRelease(context.Background(), projectServers, previousToken, model.Rollback); err != nil {
+		return "rollback to " + previousToken + " fail, " + err.Error()
+	}
+	return "rollback to " + previousToken
+}
+
+// switchRelease point the symlink of the servers to the release directory of the token
+// and rerun the after deploy script, each server record a publish trace
+func (sync Sync) switchRelease(ctx context.Context, projectServers model.ProjectServers, token string, traceType int) error {
+	project := sync.Project
+	destDir := path.Join(project.SymlinkPath, project.Name, token)
+	switchCommands := []string{
+		"test -d " + destDir,
+		"ln -sfn " + destDir + " " + project.Path,
+		// change the destination folder time, make sure it can not be clean
+		"touch -m " + destDir,
 	}
-	ch := make(chan syncMessage, len(sync.ProjectServers))
-	for _, projectServer := range sync.ProjectServers {
-		go remoteSync(ch, sync.UserInfo, sync.Project, projectServer)
+	if len(project.AfterDeployScript) != 0 {
+		scriptPath := "/tmp/goploy-after-deploy-" + project.LastPublishToken + "." + utils.GetScriptExt(project.AfterDeployScriptMode)
+		switchCommands = append(switchCommands, "("+remoteScriptCommand(project.AfterDeployScriptMode, project.AfterDeployScript, scriptPath)+")")
+	}
+	script := strings.Join(switchCommands, " && ")
+
+	ch := make(chan syncMessage, len(projectServers))
+	for _, projectServer := range projectServers {
+		go func(projectServer model.ProjectServer) {
+			publishTraceModel := sync.newPublishTrace(traceType)
+			ext, _ := json.Marshal(struct {
+				ServerID   int64  `json:"serverId"`
+				ServerName string `json:"serverName"`
+				Token      string `json:"token"`
+				Script     string `json:"script"`
+			}{projectServer.ServerID, projectServer.ServerName, token, script})
+			publishTraceModel.Ext = string(ext)
+			command, stdin := exportCommand(sync.scriptVariables(projectServer, token), script)
+			output, err := runRemoteScriptStdin(ctx, projectServer, command, stdin)
+			if err != nil {
+				publishTraceModel.Detail = err.Error()
+				publishTraceModel.State = model.Fail
+			} else {
+				publishTraceModel.Detail = output
+				publishTraceModel.State = model.Success
+			}
+			if _, err := publishTraceModel.AddRow(); err != nil {
+				core.Log(core.ERROR, err.Error())
+			}
+			message := syncMessage{serverName: projectServer.ServerName, projectID: project.ID, state: model.ProjectSuccess}
+			if err != nil {
+				message.state = model.ProjectFail
+				message.detail = err.Error()
+			}
+			ch <- message
+		}(projectServer)
 	}
 
 	message := ""
-	for i := 0; i < len(sync.ProjectServers); i++ {
+	for i := 0; i < len(projectServers); i++ {
 		syncMessage := <-ch
 		if syncMessage.state == model.ProjectFail {
 			message += syncMessage.serverName + " error message: " + syncMessage.detail
 		}
 	}
-	if message == "" {
-		sync.Project.DeploySuccess()
-		core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" deploy success")
-		ws.GetHub().Data <- &ws.Data{
-			Type:    ws.TypeProject,
-			Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.ProjectSuccess, Message: "Success"},
-		}
-		go notify(sync.Project, model.ProjectSuccess, message)
+	if message != "" {
+		return errors.New(message)
+	}
+	return nil
+}
 
-	} else {
-		sync.Project.DeployFail()
-		core.Log(core.TRACE, "projectID:"+strconv.FormatInt(sync.Project.ID, 10)+" deploy fail")
-		ws.GetHub().Data <- &ws.Data{
-			Type:    ws.TypeProject,
-			Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.ProjectFail, Message: message},
-		}
-		go notify(sync.Project, model.ProjectFail, message)
+func beforePullStage(sync Sync, publishTraceModel *model.PublishTrace) error {
+	if sync.Project.BeforePullScript == "" {
+		return errSkipStage
+	}
+	// the script runs in the repository, so clone it first
+	if err := gitCreate(sync.ctx, sync.Project); err != nil {
+		return err
+	}
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.BeforePullScript, Message: "Run before pull script"},
+	}
+	ext, _ := json.Marshal(struct {
+		Script string `json:"script"`
+	}{sync.Project.BeforePullScript})
+	publishTraceModel.Ext = string(ext)
+	outputString, err := runLocalScript(sync.ctx, sync.Project, sync.Project.BeforePullScriptMode, sync.Project.BeforePullScript, "goploy-before-pull", sync.localEnviron())
+	if err != nil {
+		return err
+	}
+	publishTraceModel.Detail = outputString
+	return nil
+}
 
+// ref the branch, the tag or the commit deployed
+func (sync Sync) ref() string {
+	if len(sync.Ref) != 0 {
+		return sync.Ref
+	} else if len(sync.CommitID) != 0 {
+		return sync.CommitID
 	}
+	return sync.Project.Branch
+}
 
-	clean(sync.Project, sync.ProjectServers)
-	return
+func gitStage(sync Sync, publishTraceModel *model.PublishTrace) error {
+	var gitCommitInfo utils.Commit
+	var err error
+	if len(sync.CommitID) != 0 {
+		gitCommitInfo, err = gitRollback(sync.ctx, sync.CommitID, sync.Project)
+	} else if len(sync.Ref) != 0 && sync.Ref != sync.Project.Branch {
+		gitCommitInfo, err = gitCheckoutRef(sync.ctx, sync.Project, sync.Ref)
+	} else {
+		gitCommitInfo, err = gitSync(sync.ctx, sync.Project)
+	}
+	if err != nil {
+		return err
+	}
+	if sync.artifact != nil {
+		sync.artifact.Commit = gitCommitInfo
+	}
+	ext, _ := json.Marshal(struct {
+		utils.Commit
+		Ref string `json:"ref"`
+	}{gitCommitInfo, sync.ref()})
+	publishTraceModel.Ext = string(ext)
+	return nil
 }
 
-func gitSync(project model.Project) (utils.Commit, error) {
-	if err := gitCreate(project); err != nil {
+func afterPullStage(sync Sync, publishTraceModel *model.PublishTrace) error {
+	if sync.Project.AfterPullScript == "" {
+		return errSkipStage
+	}
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: sync.Project.ID, ProjectName: sync.Project.Name, State: ws.AfterPullScript, Message: "Run pull script"},
+	}
+	ext, _ := json.Marshal(struct {
+		Script string `json:"script"`
+	}{sync.Project.AfterPullScript})
+	publishTraceModel.Ext = string(ext)
+	outputString, err := runLocalScript(sync.ctx, sync.Project, sync.Project.AfterPullScriptMode, sync.Project.AfterPullScript, "goploy-after-pull", sync.localEnviron())
+	if err != nil {
+		return err
+	}
+	publishTraceModel.Detail = outputString
+	return nil
+}
+
+func gitSync(ctx context.Context, project model.Project) (utils.Commit, error) {
+	if err := gitCreate(ctx, project); err != nil {
 		return utils.Commit{}, err
 	}
 
-	if err := gitPull(project); err != nil {
+	if err := gitPull(ctx, project); err != nil {
 		return utils.Commit{}, err
 	}
 
-	commit, err := gitCommitLog(project)
+	commit, err := gitCommitLog(ctx, project)
 	if err != nil {
 		return utils.Commit{}, err
 	}
@@ -169,12 +660,25 @@ func gitSync(project model.Project) (utils.Commit, error) {
 	return commit, err
 }
 
-func gitRollback(commitSha string, project model.Project) (utils.Commit, error) {
-	if err := gitReset(commitSha, project); err != nil {
+func gitRollback(ctx context.Context, commitSha string, project model.Project) (utils.Commit, error) {
+	// the commit may be on the other branch or the tag
+	if err := gitCreate(ctx, project); err != nil {
+		return utils.Commit{}, err
+	}
+	if err := gitFetch(ctx, project); err != nil {
+		return utils.Commit{}, err
+	}
+	// reset on a detached HEAD, so the project branch is not moved to the commit
+	git := utils.GIT{Dir: core.RepositoryPath + project.Name, Ctx: ctx}
+	if err := git.Checkout([]string{"--detach"}); err != nil {
+		core.Log(core.ERROR, err.Error()+", detail: "+git.Err.String())
+		return utils.Commit{}, errors.New(git.Err.String())
+	}
+	if err := gitReset(ctx, commitSha, project); err != nil {
 		return utils.Commit{}, err
 	}
 
-	commit, err := gitCommitLog(project)
+	commit, err := gitCommitLog(ctx, project)
 	if err != nil {
 		return utils.Commit{}, err
 	}
@@ -191,7 +695,7 @@ func gitRollback(commitSha string, project model.Project) (utils.Commit, error)
 	return commit, err
 }
 
-func gitCreate(project model.Project) error {
+func gitCreate(ctx context.Context, project model.Project) error {
 	srcPath := core.RepositoryPath + project.Name
 	// 已有文件夹无需删除
 	if _, err := os.Stat(srcPath); err == nil {
@@ -201,7 +705,7 @@ func gitCreate(project model.Project) error {
 	if err := os.RemoveAll(srcPath); err != nil {
 		return err
 	}
-	git := utils.GIT{}
+	git := utils.GIT{Ctx: ctx}
 	ws.GetHub().Data <- &ws.Data{
 		Type:    ws.TypeProject,
 		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.GitClone, Message: "git clone"},
@@ -228,8 +732,8 @@ func gitCreate(project model.Project) error {
 	return nil
 }
 
-func gitPull(project model.Project) error {
-	git := utils.GIT{Dir: core.RepositoryPath + project.Name}
+func gitPull(ctx context.Context, project model.Project) error {
+	git := utils.GIT{Dir: core.RepositoryPath + project.Name, Ctx: ctx}
 	// git clean removes all not tracked files
 	ws.GetHub().Data <- &ws.Data{
 		Type:    ws.TypeProject,
@@ -252,6 +756,15 @@ func gitPull(project model.Project) error {
 		return errors.New(git.Err.String())
 	}
 
+	// the ref deploy leaves HEAD detached, back to the project branch
+	if err := git.Run("symbolic-ref", []string{"-q", "HEAD"}); err != nil {
+		core.Log(core.TRACE, "projectID:"+strconv.FormatInt(project.ID, 10)+" git checkout "+project.Branch)
+		if err := git.Checkout([]string{project.Branch}); err != nil {
+			core.Log(core.ERROR, err.Error()+", detail: "+git.Err.String())
+			return errors.New(git.Err.String())
+		}
+	}
+
 	ws.GetHub().Data <- &ws.Data{
 		Type:    ws.TypeProject,
 		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.GitPull, Message: "git pull"},
@@ -264,13 +777,13 @@ func gitPull(project model.Project) error {
 	return nil
 }
 
-func gitReset(commit string, project model.Project) error {
+func gitReset(ctx context.Context, commit string, project model.Project) error {
 	srcPath := core.RepositoryPath + project.Name
 	ws.GetHub().Data <- &ws.Data{
 		Type:    ws.TypeProject,
 		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.GitReset, Message: "git reset"},
 	}
-	resetCmd := exec.Command("git", "reset", "--hard", commit)
+	resetCmd := exec.CommandContext(ctx, "git", "reset", "--hard", commit)
 	resetCmd.Dir = srcPath
 	var resetOutbuf, resetErrbuf bytes.Buffer
 	resetCmd.Stdout = &resetOutbuf
@@ -285,8 +798,8 @@ func gitReset(commit string, project model.Project) error {
 	return nil
 }
 
-func gitCommitLog(project model.Project) (utils.Commit, error) {
-	git := utils.GIT{Dir: core.RepositoryPath + project.Name}
+func gitCommitLog(ctx context.Context, project model.Project) (utils.Commit, error) {
+	git := utils.GIT{Dir: core.RepositoryPath + project.Name, Ctx: ctx}
 
 	if err := git.Log([]string{"--stat", "--pretty=format:`start`%H`%an`%at`%s`", "-n", "1"}); err != nil {
 		core.Log(core.ERROR, err.Error()+", detail: "+git.Err.String())
@@ -296,101 +809,113 @@ func gitCommitLog(project model.Project) (utils.Commit, error) {
 	return commitList[0], nil
 }
 
-func runAfterPullScript(project model.Project) (string, error) {
+// runLocalScript write the script to the repository and run it there with the variables in env
+func runLocalScript(ctx context.Context, project model.Project, scriptMode, script, name string, env []string) (string, error) {
 	srcPath := path.Join(core.RepositoryPath, project.Name)
-	scriptName := "goploy-after-pull." + utils.GetScriptExt(project.AfterPullScriptMode)
+	scriptName := name + "." + utils.GetScriptExt(scriptMode)
 	scriptFullName := path.Join(srcPath, scriptName)
-	scriptMode := "bash"
-	if len(project.AfterPullScriptMode) != 0 {
-		scriptMode = project.AfterPullScriptMode
+	if len(scriptMode) == 0 {
+		scriptMode = "bash"
 	}
-	ioutil.WriteFile(scriptFullName, []byte(project.AfterPullScript), 0755)
-	handler := exec.Command(scriptMode, path.Join(".", scriptName))
+	ioutil.WriteFile(scriptFullName, []byte(script), 0755)
+	handler := exec.CommandContext(ctx, scriptMode, path.Join(".", scriptName))
 	handler.Dir = srcPath
+	handler.Env = append(os.Environ(), env...)
 	var outbuf, errbuf bytes.Buffer
 	handler.Stdout = &outbuf
 	handler.Stderr = &errbuf
-	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(project.ID, 10)+project.AfterPullScript)
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(project.ID, 10)+script)
 	if err := handler.Run(); err != nil {
 		core.Log(core.ERROR, errbuf.String())
 		return "", errors.New(errbuf.String())
 	}
 
-	os.Remove(scriptName)
+	os.Remove(scriptFullName)
 	return outbuf.String(), nil
 }
 
-func remoteSync(chInput chan<- syncMessage, userInfo model.User, project model.Project, projectServer model.ProjectServer) {
-	remoteMachine := projectServer.ServerOwner + "@" + projectServer.ServerIP
-	destDir := project.Path
+func beforeDeployStage(sync Sync, projectServer model.ProjectServer, publishTraceModel *model.PublishTrace) error {
+	project := sync.Project
+	if len(project.BeforeDeployScript) == 0 {
+		return errSkipStage
+	}
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.BeforeDeployScript, Message: "Run before deploy script " + projectServer.ServerName},
+	}
 	ext, _ := json.Marshal(struct {
 		ServerID   int64  `json:"serverId"`
 		ServerName string `json:"serverName"`
-	}{projectServer.ServerID, projectServer.ServerName})
-	publishTraceModel := model.PublishTrace{
-		Token:         project.LastPublishToken,
-		ProjectID:     project.ID,
-		ProjectName:   project.Name,
-		PublisherID:   userInfo.ID,
-		PublisherName: userInfo.Name,
-		Type:          model.Deploy,
-		Ext:           string(ext),
+		Script     string `json:"script"`
+	}{projectServer.ServerID, projectServer.ServerName, project.BeforeDeployScript})
+	publishTraceModel.Ext = string(ext)
+
+	// the files have not been transferred yet, ship the script with the command
+	scriptPath := "/tmp/goploy-before-deploy-" + project.LastPublishToken + "." + utils.GetScriptExt(project.BeforeDeployScriptMode)
+	variables := sync.scriptVariables(projectServer, project.LastPublishToken)
+	command, stdin := exportCommand(variables, remoteScriptCommand(project.BeforeDeployScriptMode, project.BeforeDeployScript, scriptPath))
+	output, err := runRemoteScriptStdin(sync.ctx, projectServer, command, stdin)
+	if err != nil {
+		return err
 	}
+	publishTraceModel.Detail = output
+	return nil
+}
+
+// remoteScriptCommand return a command which writes the script to scriptPath, runs and removes it
+func remoteScriptCommand(scriptMode, script, scriptPath string) string {
+	if len(scriptMode) == 0 {
+		scriptMode = "bash"
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(script))
+	return "echo " + encoded + " | base64 -d > " + scriptPath + ";" + scriptMode + " " + scriptPath + ";code=$?;rm -f " + scriptPath + ";exit $code"
+}
+
+func transferStage(sync Sync, projectServer model.ProjectServer, publishTraceModel *model.PublishTrace) error {
+	project := sync.Project
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.Rsync, Message: "Rsync " + projectServer.ServerName},
+	}
+	destDir := releaseDir(project, project.LastPublishToken)
 
 	if len(project.AfterDeployScript) != 0 {
 		scriptName := path.Join(core.RepositoryPath, project.Name, "goploy-after-deploy."+utils.GetScriptExt(project.AfterDeployScriptMode))
 		ioutil.WriteFile(scriptName, []byte(project.AfterDeployScript), 0755)
 	}
 
-	rsyncOption, _ := utils.ParseCommandLine(project.RsyncOption)
-	rsyncOption = append(rsyncOption, "-e", "ssh -p "+strconv.Itoa(int(projectServer.ServerPort))+" -o StrictHostKeyChecking=no")
-	if len(project.SymlinkPath) != 0 {
-		destDir = path.Join(project.SymlinkPath, project.Name, project.LastPublishToken)
-		rsyncOption = append(rsyncOption, "--rsync-path=mkdir -p "+destDir+" && rsync")
-	}
 	srcPath := core.RepositoryPath + project.Name + "/"
-	destPath := remoteMachine + ":" + destDir
-	rsyncOption = append(rsyncOption, srcPath, destPath)
-	cmd := exec.Command("rsync", rsyncOption...)
-	var outbuf, errbuf bytes.Buffer
-	cmd.Stdout = &outbuf
-	cmd.Stderr = &errbuf
-	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(project.ID, 10)+" rsync "+strings.Join(rsyncOption, " "))
-	var rsyncError error
+	transfer := newTransfer(sync)
+	command := transfer.describe(projectServer, srcPath, destDir)
+	ext, _ := json.Marshal(struct {
+		ServerID   int64  `json:"serverId"`
+		ServerName string `json:"serverName"`
+		Command    string `json:"command"`
+	}{projectServer.ServerID, projectServer.ServerName, command})
+	publishTraceModel.Ext = string(ext)
+	core.Log(core.TRACE, "projectID:"+strconv.FormatInt(project.ID, 10)+" "+command)
+	var err error
 	// 失败重试三次
-	for attempt := 0; attempt < 3; attempt++ {
-		rsyncError = cmd.Run()
-		if rsyncError != nil {
-			core.Log(core.ERROR, errbuf.String())
+	for attempt := 0; attempt < 3 && sync.ctx.Err() == nil; attempt++ {
+		var output string
+		if output, err = transfer.run(sync.ctx, projectServer, srcPath, destDir); err != nil {
+			core.Log(core.ERROR, err.Error())
 		} else {
-			ext, _ := json.Marshal(struct {
-				ServerID   int64  `json:"serverId"`
-				ServerName string `json:"serverName"`
-				Command    string `json:"command"`
-			}{projectServer.ServerID, projectServer.ServerName, "rsync " + strings.Join(rsyncOption, " ")})
-			publishTraceModel.Ext = string(ext)
-			publishTraceModel.Detail = outbuf.String()
-			publishTraceModel.State = model.Success
-			publishTraceModel.AddRow()
-			break
+			publishTraceModel.Detail = output
+			return sync.uploadConfigTemplates(projectServer, destDir, publishTraceModel)
 		}
 	}
-
-	if rsyncError != nil {
-		publishTraceModel.Detail = errbuf.String()
-		publishTraceModel.State = model.Fail
-		publishTraceModel.AddRow()
-		chInput <- syncMessage{
-			serverName: projectServer.ServerName,
-			projectID:  project.ID,
-			detail:     errbuf.String(),
-			state:      model.ProjectFail,
-		}
-		return
+	if err == nil {
+		err = sync.ctx.Err()
 	}
+	return err
+}
 
+func afterDeployStage(sync Sync, projectServer model.ProjectServer, publishTraceModel *model.PublishTrace) error {
+	project := sync.Project
 	var afterDeployCommands []string
 	if len(project.SymlinkPath) != 0 {
+		destDir := path.Join(project.SymlinkPath, project.Name, project.LastPublishToken)
 		afterDeployCommands = append(afterDeployCommands, "ln -sfn "+destDir+" "+project.Path)
 		// change the destination folder time, make sure it can not be clean
 		afterDeployCommands = append(afterDeployCommands, "touch -m "+destDir)
@@ -398,7 +923,7 @@ func remoteSync(chInput chan<- syncMessage, userInfo model.User, project model.P
 
 	if len(project.AfterDeployScript) != 0 {
 		scriptMode := "bash"
-		if len(project.AfterDeployScript) != 0 {
+		if len(project.AfterDeployScriptMode) != 0 {
 			scriptMode = project.AfterDeployScriptMode
 		}
 		afterDeployCommands = append(afterDeployCommands, scriptMode+" "+path.Join(project.Path, "goploy-after-deploy."+utils.GetScriptExt(project.AfterDeployScriptMode)))
@@ -406,83 +931,116 @@ func remoteSync(chInput chan<- syncMessage, userInfo model.User, project model.P
 
 	// no symlink and deploy script
 	if len(afterDeployCommands) == 0 {
-		chInput <- syncMessage{
-			serverName: projectServer.ServerName,
-			projectID:  project.ID,
-			state:      model.ProjectSuccess,
-		}
-		return
+		return errSkipStage
 	}
 
-	publishTraceModel.Type = model.AfterDeploy
-	ext, _ = json.Marshal(struct {
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.AfterDeployScript, Message: "Run deploy script " + projectServer.ServerName},
+	}
+	ext, _ := json.Marshal(struct {
 		ServerID   int64  `json:"serverId"`
 		ServerName string `json:"serverName"`
 		Script     string `json:"script"`
 	}{projectServer.ServerID, projectServer.ServerName, strings.Join(afterDeployCommands, ";")})
 	publishTraceModel.Ext = string(ext)
 
-	// 执行ssh脚本
-	var session *ssh.Session
+	variables := sync.scriptVariables(projectServer, project.LastPublishToken)
+	command, stdin := exportCommand(variables, strings.Join(afterDeployCommands, ";"))
+	output, err := runRemoteScriptStdin(sync.ctx, projectServer, command, stdin)
+	if err != nil {
+		return err
+	}
+	publishTraceModel.Detail = output
+	return nil
+}
+
+// runRemoteScript run the script over ssh, retry three times
+func runRemoteScript(ctx context.Context, projectServer model.ProjectServer, script string) (string, error) {
+	return runRemoteScriptStdin(ctx, projectServer, script, "")
+}
+
+// runRemoteScriptStdin run the script with the stdin, the secrets go through the stdin instead of the command line
+func runRemoteScriptStdin(ctx context.Context, projectServer model.ProjectServer, script, stdin string) (string, error) {
 	var connectError error
 	var scriptError error
-	for attempt := 0; attempt < 3; attempt++ {
-		session, connectError = utils.ConnectSSH(projectServer.ServerOwner, "", projectServer.ServerIP, int(projectServer.ServerPort))
+	for attempt := 0; attempt < 3 && ctx.Err() == nil; attempt++ {
+		session, closeSession, err := openRemoteSession(projectServer, len(stdin) != 0)
+		connectError = err
 		if connectError != nil {
 			core.Log(core.ERROR, connectError.Error())
 		} else {
 			var sshOutbuf, sshErrbuf bytes.Buffer
 			session.Stdout = &sshOutbuf
 			session.Stderr = &sshErrbuf
-			sshOutbuf.Reset()
-			if scriptError = session.Run(strings.Join(afterDeployCommands, ";")); scriptError != nil {
+			if len(stdin) != 0 {
+				session.Stdin = strings.NewReader(stdin)
+			}
+			scriptError = runSession(ctx, session, script)
+			closeSession()
+			if scriptError != nil {
 				core.Log(core.ERROR, scriptError.Error())
 			} else {
-				publishTraceModel.Detail = sshOutbuf.String()
-				publishTraceModel.State = model.Success
-				publishTraceModel.AddRow()
-				break
+				return sshOutbuf.String(), nil
 			}
 		}
 	}
-	if session != nil {
-		defer session.Close()
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
 	if connectError != nil {
-		publishTraceModel.Detail = connectError.Error()
-		publishTraceModel.State = model.Fail
-		publishTraceModel.AddRow()
-		chInput <- syncMessage{
-			serverName: projectServer.ServerName,
-			projectID:  project.ID,
-			detail:     connectError.Error(),
-			state:      model.ProjectFail,
-		}
-		return
-	} else if scriptError != nil {
-		publishTraceModel.Detail = scriptError.Error()
-		publishTraceModel.State = model.Fail
-		publishTraceModel.AddRow()
-		chInput <- syncMessage{
-			serverName: projectServer.ServerName,
-			projectID:  project.ID,
-			detail:     scriptError.Error(),
-			state:      model.ProjectFail,
+		return "", connectError
+	}
+	return "", scriptError
+}
+
+// openRemoteSession open a session on the server, the session reading the stdin has no pty,
+// because the pty never passes the eof of the stdin and cuts the line longer than 4095 bytes
+func openRemoteSession(projectServer model.ProjectServer, withStdin bool) (*ssh.Session, func(), error) {
+	if !withStdin {
+		session, err := utils.ConnectSSH(projectServer.ServerOwner, "", projectServer.ServerIP, int(projectServer.ServerPort))
+		if err != nil {
+			return nil, nil, err
 		}
-		return
+		return session, func() { session.Close() }, nil
 	}
-	chInput <- syncMessage{
-		serverName: projectServer.ServerName,
-		projectID:  project.ID,
-		state:      model.ProjectSuccess,
+	client, err := utils.DialSSH(projectServer.ServerOwner, "", projectServer.ServerIP, int(projectServer.ServerPort))
+	if err != nil {
+		return nil, nil, err
 	}
-	return
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return session, func() {
+		session.Close()
+		client.Close()
+	}, nil
+}
+
+// runSession run the script in the session, the session is closed when ctx is done
+func runSession(ctx context.Context, session *ssh.Session, script string) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		case <-done:
+		}
+	}()
+	return session.Run(script)
 }
 
 func notify(project model.Project, deployState int, detail string) {
 	if project.NotifyType == 0 {
 		return
-	} else if project.NotifyType == model.NotifyWeiXin {
+	}
+	// the detail may contain the script output
+	detail = utils.Mask(detail)
+	if project.NotifyType == model.NotifyWeiXin {
 		type markdown struct {
 			Content string `json:"content"`
 		}
@@ -587,32 +1145,22 @@ func notify(project model.Project, deployState int, detail string) {
 	}
 }
 
-//clean the expired backup
-func clean(project model.Project, projectServers model.ProjectServers) {
+// cleanStage remove the expired backup, keep the latest 10 project
+func cleanStage(sync Sync, projectServer model.ProjectServer, publishTraceModel *model.PublishTrace) error {
+	project := sync.Project
 	if len(project.SymlinkPath) == 0 {
-		return
-	}
-	for _, projectServer := range projectServers {
-		go removeExpiredBackup(project, projectServer)
+		return errSkipStage
 	}
-}
-
-//keep the latest 10 project
-func removeExpiredBackup(project model.Project, projectServer model.ProjectServer) {
-	var session *ssh.Session
-	var connectError error
-	var scriptError error
-	session, connectError = utils.ConnectSSH(projectServer.ServerOwner, "", projectServer.ServerIP, int(projectServer.ServerPort))
-	if connectError != nil {
-		core.Log(core.ERROR, connectError.Error())
-		return
+	ws.GetHub().Data <- &ws.Data{
+		Type:    ws.TypeProject,
+		Message: ws.ProjectMessage{ProjectID: project.ID, ProjectName: project.Name, State: ws.Clean, Message: "Clean " + projectServer.ServerName},
 	}
-	defer session.Close()
-	var sshOutbuf, sshErrbuf bytes.Buffer
-	session.Stdout = &sshOutbuf
-	session.Stderr = &sshErrbuf
-	destDir := path.Join(project.SymlinkPath, project.Name)
-	if scriptError = session.Run("cd " + destDir + ";ls -t | awk 'NR>10' | xargs rm -rf"); scriptError != nil {
-		core.Log(core.ERROR, scriptError.Error())
+	removed, script, err := pruneReleases(sync.ctx, project, projectServer)
+	publishTraceModel.Ext = pruneExt(projectServer, removed, script)
+	if err != nil {
+		core.Log(core.ERROR, err.Error())
+		return err
 	}
+	publishTraceModel.Detail = "Remove " + strconv.Itoa(len(removed)) + " releases"
+	return nil
 }
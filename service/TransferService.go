@@ -0,0 +1,336 @@
+package service
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// transfer ship the files of srcPath to destDir of the server
+type transfer interface {
+	// describe the transfer for the publish trace
+	describe(projectServer model.ProjectServer, srcPath, destDir string) string
+	run(ctx context.Context, projectServer model.ProjectServer, srcPath, destDir string) (string, error)
+}
+
+func newTransfer(sync Sync) transfer {
+	if sync.Project.ArtifactMode == model.Enable {
+		return artifactTransfer{project: sync.Project, artifact: *sync.artifact}
+	}
+	if sync.Project.TransferMode == model.TransferSSH {
+		return sshTransfer{project: sync.Project}
+	}
+	return rsyncTransfer{project: sync.Project}
+}
+
+// rsyncTransfer shell out to the local rsync with the rsync option of the project
+type rsyncTransfer struct {
+	project model.Project
+}
+
+func (rt rsyncTransfer) options(projectServer model.ProjectServer, srcPath, destDir string) []string {
+	rsyncOption, _ := utils.ParseCommandLine(rt.project.RsyncOption)
+	rsyncOption = append(rsyncOption, "-e", "ssh -p "+strconv.Itoa(int(projectServer.ServerPort))+" -o StrictHostKeyChecking=no")
+	if len(rt.project.SymlinkPath) != 0 {
+		rsyncOption = append(rsyncOption, "--rsync-path=mkdir -p "+destDir+" && rsync")
+	}
+	remoteMachine := projectServer.ServerOwner + "@" + projectServer.ServerIP
+	return append(rsyncOption, srcPath, remoteMachine+":"+destDir)
+}
+
+func (rt rsyncTransfer) describe(projectServer model.ProjectServer, srcPath, destDir string) string {
+	return "rsync " + strings.Join(rt.options(projectServer, srcPath, destDir), " ")
+}
+
+func (rt rsyncTransfer) run(ctx context.Context, projectServer model.ProjectServer, srcPath, destDir string) (string, error) {
+	var outbuf, errbuf bytes.Buffer
+	cmd := exec.CommandContext(ctx, "rsync", rt.options(projectServer, srcPath, destDir)...)
+	cmd.Stdout = &outbuf
+	cmd.Stderr = &errbuf
+	if err := cmd.Run(); err != nil {
+		return "", errors.New(errbuf.String())
+	}
+	return outbuf.String(), nil
+}
+
+// sshTransfer upload the changed files over ssh with the key of SSHKEY_PATH,
+// the files are compared by md5, --delete and --exclude of the rsync option are honored
+type sshTransfer struct {
+	project model.Project
+}
+
+func (st sshTransfer) describe(projectServer model.ProjectServer, srcPath, destDir string) string {
+	return "ssh transfer " + srcPath + " " + projectServer.ServerOwner + "@" + projectServer.ServerIP + ":" + destDir
+}
+
+func (st sshTransfer) run(ctx context.Context, projectServer model.ProjectServer, srcPath, destDir string) (string, error) {
+	filter := newTransferFilter(st.project.RsyncOption)
+	localFiles, localLinks, err := filter.localManifest(srcPath)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := utils.DialSSH(projectServer.ServerOwner, "", projectServer.ServerIP, int(projectServer.ServerPort))
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-done:
+		}
+	}()
+
+	prepare := "mkdir -p " + destDir
+	if len(st.project.SymlinkPath) != 0 {
+		// seed the new release with the active one, so only the changed files are uploaded
+		prepare += " && if [ -z \"$(ls -A " + destDir + ")\" ] && [ -d " + st.project.Path + " ]; then cp -a " + st.project.Path + "/. " + destDir + "/; fi"
+	}
+	if _, err := runClientCommand(client, prepare, nil); err != nil {
+		return "", err
+	}
+
+	output, err := runClientCommand(client, "cd "+destDir+" && find . -type f -print0 | xargs -0 -r md5sum", nil)
+	if err != nil {
+		return "", err
+	}
+	remoteFiles := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// md5sum escapes the special file name with a leading backslash
+		if len(line) < 35 || line[0] == '\\' {
+			continue
+		}
+		remoteFiles[strings.TrimPrefix(line[34:], "./")] = line[:32]
+	}
+
+	var uploads []string
+	for name, hash := range localFiles {
+		if remoteFiles[name] != hash {
+			uploads = append(uploads, name)
+		}
+	}
+	uploads = append(uploads, localLinks...)
+
+	var deletes []string
+	if filter.delete {
+		for name := range remoteFiles {
+			if _, ok := localFiles[name]; !ok && !filter.excluded(name, false) {
+				deletes = append(deletes, name)
+			}
+		}
+	}
+
+	if len(uploads) != 0 {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(writeTarGz(writer, srcPath, uploads))
+		}()
+		if _, err := runClientCommand(client, "tar -xzf - -C "+destDir, reader); err != nil {
+			reader.Close()
+			return "", err
+		}
+	}
+
+	if len(deletes) != 0 {
+		stdin := strings.NewReader(strings.Join(deletes, "\x00"))
+		if _, err := runClientCommand(client, "cd "+destDir+" && xargs -0 rm -f --", stdin); err != nil {
+			return "", err
+		}
+	}
+
+	var detail strings.Builder
+	detail.WriteString("upload " + strconv.Itoa(len(uploads)) + " files, delete " + strconv.Itoa(len(deletes)) + " files\n")
+	for _, name := range uploads {
+		detail.WriteString(name + "\n")
+	}
+	for _, name := range deletes {
+		detail.WriteString("deleting " + name + "\n")
+	}
+	return detail.String(), nil
+}
+
+// runClientCommand run the command in a new session without pty, so the stdin can carry binary data
+func runClientCommand(client *ssh.Client, command string, stdin io.Reader) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	var outbuf, errbuf bytes.Buffer
+	session.Stdout = &outbuf
+	session.Stderr = &errbuf
+	session.Stdin = stdin
+	if err := session.Run(command); err != nil {
+		return "", errors.New(err.Error() + ", detail: " + errbuf.String())
+	}
+	return outbuf.String(), nil
+}
+
+func writeTarGz(w io.Writer, srcPath string, names []string) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, name := range names {
+		fullName := filepath.Join(srcPath, filepath.FromSlash(name))
+		info, err := os.Lstat(fullName)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(fullName); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		file, err := os.Open(fullName)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tarWriter, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// transferFilter the delete and exclude semantics parsed from the rsync option
+type transferFilter struct {
+	delete   bool
+	excludes []string
+}
+
+func newTransferFilter(rsyncOption string) transferFilter {
+	var filter transferFilter
+	options, _ := utils.ParseCommandLine(rsyncOption)
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		switch {
+		case strings.HasPrefix(option, "--delete"):
+			filter.delete = true
+		case strings.HasPrefix(option, "--exclude="):
+			filter.excludes = append(filter.excludes, strings.TrimPrefix(option, "--exclude="))
+		case option == "--exclude" && i+1 < len(options):
+			i++
+			filter.excludes = append(filter.excludes, options[i])
+		}
+	}
+	return filter
+}
+
+// excluded report whether the slash separated relative path or one of its parents matches an exclude pattern
+func (tf transferFilter) excluded(name string, isDir bool) bool {
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		if tf.match(strings.Join(parts[:i+1], "/"), isDir || i < len(parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// match follow the rsync pattern rules: a leading slash anchors the pattern to the root,
+// a trailing slash matches only the directory, a pattern without slash matches the base name
+func (tf transferFilter) match(name string, isDir bool) bool {
+	for _, pattern := range tf.excludes {
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		var matched bool
+		if strings.HasPrefix(pattern, "/") {
+			matched, _ = path.Match(pattern[1:], name)
+		} else if strings.Contains(pattern, "/") {
+			// the unanchored pattern matches the end of the path at any depth
+			parts := strings.Split(name, "/")
+			for i := 0; i < len(parts) && !matched; i++ {
+				matched, _ = path.Match(pattern, strings.Join(parts[i:], "/"))
+			}
+		} else {
+			matched, _ = path.Match(pattern, path.Base(name))
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// localManifest return the md5 of the regular files and the symlinks under srcPath
+func (tf transferFilter) localManifest(srcPath string) (map[string]string, []string, error) {
+	files := map[string]string{}
+	var links []string
+	err := filepath.Walk(srcPath, func(fullName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(srcPath, fullName)
+		if err != nil || name == "." {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if tf.match(name, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			links = append(links, name)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(fullName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash := md5.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return err
+		}
+		files[name] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	})
+	return files, links, err
+}
@@ -0,0 +1,69 @@
+package service
+
+import "testing"
+
+func TestTransferFilterMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		isDir   bool
+		want    bool
+	}{
+		// the pattern without slash matches the base name at any depth
+		{pattern: "*.log", name: "app.log", want: true},
+		{pattern: "*.log", name: "logs/app.log", want: true},
+		{pattern: "*.log", name: "logs/app.log.1", want: false},
+		{pattern: "node_modules", name: "web/node_modules", isDir: true, want: true},
+		{pattern: "node_modules", name: "web/node_modules", want: true},
+		// the leading slash anchors the pattern to the root
+		{pattern: "/config.yml", name: "config.yml", want: true},
+		{pattern: "/config.yml", name: "conf/config.yml", want: false},
+		{pattern: "/conf/*.yml", name: "conf/app.yml", want: true},
+		{pattern: "/conf/*.yml", name: "web/conf/app.yml", want: false},
+		// the pattern with slash matches the end of the path
+		{pattern: "conf/*.yml", name: "conf/app.yml", want: true},
+		{pattern: "conf/*.yml", name: "web/conf/app.yml", want: true},
+		{pattern: "conf/*.yml", name: "a/b/conf/app.yml", want: true},
+		{pattern: "conf/*.yml", name: "myconf/app.yml", want: false},
+		{pattern: "conf/*.yml", name: "conf/sub/app.yml", want: false},
+		// the trailing slash matches only the directory
+		{pattern: "cache/", name: "cache", isDir: true, want: true},
+		{pattern: "cache/", name: "cache", want: false},
+		{pattern: "cache/", name: "tmp/cache", isDir: true, want: true},
+		{pattern: "/cache/", name: "tmp/cache", isDir: true, want: false},
+		{pattern: "tmp/cache/", name: "web/tmp/cache", isDir: true, want: true},
+		{pattern: "tmp/cache/", name: "web/tmp/cache", want: false},
+	}
+	for _, tt := range tests {
+		filter := transferFilter{excludes: []string{tt.pattern}}
+		if got := filter.match(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("pattern %q match(%q, %v) = %v, want %v", tt.pattern, tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestTransferFilterExcluded(t *testing.T) {
+	filter := newTransferFilter("-rtv --delete --exclude=.git --exclude '/runtime/' --exclude=*.log")
+	if !filter.delete {
+		t.Errorf("--delete is not parsed")
+	}
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{name: ".git", isDir: true, want: true},
+		{name: ".git/HEAD", want: true},
+		{name: "vendor/lib/.git/config", want: true},
+		{name: "runtime", isDir: true, want: true},
+		{name: "runtime/cache/data", want: true},
+		{name: "web/runtime/data", want: false},
+		{name: "logs/app.log", want: true},
+		{name: "src/main.go", want: false},
+	}
+	for _, tt := range tests {
+		if got := filter.excluded(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("excluded(%q, %v) = %v, want %v", tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+)
+
+const (
+	twoFactorIssuer = "Goploy"
+	// recoveryCodeCount the number of the recovery codes generated each time
+	recoveryCodeCount = 10
+	// preAuthExpire the time limit to enter the code after the password is verified
+	preAuthExpire = 5 * time.Minute
+	// preAuthMaxAttempts the pre-auth token is dropped after too many wrong codes
+	preAuthMaxAttempts = 5
+	// twoFactorMaxFailures the user is locked out of the two-factor verification after too many wrong codes,
+	// no matter how many pre-auth tokens are used
+	twoFactorMaxFailures = 10
+	// twoFactorLockout the time the wrong codes of the user are counted in
+	twoFactorLockout = 15 * time.Minute
+)
+
+// PreAuth the user who has passed the password and waits for the two-factor code
+type PreAuth struct {
+	UserID int64
+}
+
+// TwoFactorRequired report whether any namespace of the user requires the two-factor authentication for the role
+func TwoFactorRequired(userID int64) (bool, error) {
+	namespaceList, err := model.Namespace{UserID: userID}.GetAllByUserID()
+	if err != nil {
+		return false, err
+	}
+	for _, namespace := range namespaceList {
+		if namespace.TwoFactorRole != "" && roleRank(namespace.Role) <= roleRank(namespace.TwoFactorRole) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TwoFactorEnabled -
+func TwoFactorEnabled(userID int64) (bool, error) {
+	userTwoFactor, err := model.UserTwoFactor{UserID: userID}.GetDataByUserID()
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return userTwoFactor.Enabled == model.Enable, nil
+}
+
+// EnrollTwoFactor generate the TOTP secret waiting for the first code,
+// return the secret and the otpauth uri for the QR code
+func EnrollTwoFactor(userData model.User) (string, string, error) {
+	if enabled, err := TwoFactorEnabled(userData.ID); err != nil {
+		return "", "", err
+	} else if enabled {
+		return "", "", errors.New("Two-factor authentication is already enabled")
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: twoFactorIssuer, AccountName: userData.Account})
+	if err != nil {
+		return "", "", err
+	}
+	encrypted, err := EncryptSecret(key.Secret())
+	if err != nil {
+		return "", "", err
+	}
+	if err := (model.UserTwoFactor{UserID: userData.ID, Secret: encrypted}).AddOrUpdate(); err != nil {
+		return "", "", err
+	}
+	return key.Secret(), key.URL(), nil
+}
+
+// EnableTwoFactor verify the first code of the enrolled secret, return the recovery codes
+func EnableTwoFactor(userID int64, code string) ([]string, error) {
+	userTwoFactor, err := model.UserTwoFactor{UserID: userID}.GetDataByUserID()
+	if err == sql.ErrNoRows {
+		return nil, errors.New("Two-factor authentication is not enrolled")
+	} else if err != nil {
+		return nil, err
+	}
+	if userTwoFactor.Enabled == model.Enable {
+		return nil, errors.New("Two-factor authentication is already enabled")
+	}
+	if err := validateTOTP(userTwoFactor, code); err != nil {
+		return nil, err
+	}
+	if err := (model.UserTwoFactor{UserID: userID}).Enable(); err != nil {
+		return nil, err
+	}
+	return GenerateRecoveryCodes(userID)
+}
+
+// VerifyTwoFactor accept the TOTP code or an unused recovery code,
+// the user is locked out for a while after too many wrong codes
+func VerifyTwoFactor(userID int64, code string) error {
+	userTwoFactor, err := model.UserTwoFactor{UserID: userID}.GetDataByUserID()
+	if err == sql.ErrNoRows || (err == nil && userTwoFactor.Enabled != model.Enable) {
+		return errors.New("Two-factor authentication is not enabled")
+	} else if err != nil {
+		return err
+	}
+
+	failureKey := "twoFactorFailure:" + strconv.FormatInt(userID, 10)
+	if failures, found := core.Cache.Get(failureKey); found && failures.(int) >= twoFactorMaxFailures {
+		return errors.New("Too many wrong two-factor codes, please try again later")
+	}
+	if err := verifyTwoFactorCode(userTwoFactor, code); err != nil {
+		if increaseCounter(failureKey, twoFactorLockout) == twoFactorMaxFailures {
+			core.Log(core.WARNING, "user "+strconv.FormatInt(userID, 10)+" is locked out of the two-factor authentication")
+		}
+		return err
+	}
+	core.Cache.Delete(failureKey)
+	return nil
+}
+
+// verifyTwoFactorCode the code of 6 characters is the TOTP code, the others are the recovery codes
+func verifyTwoFactorCode(userTwoFactor model.UserTwoFactor, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == 6 {
+		return validateTOTP(userTwoFactor, code)
+	}
+
+	used, err := model.UserRecoveryCode{UserID: userTwoFactor.UserID, CodeHash: hashRecoveryCode(code)}.Use()
+	if err != nil {
+		return err
+	} else if !used {
+		return errors.New("Invalid recovery code")
+	}
+	core.Log(core.WARNING, "user "+strconv.FormatInt(userTwoFactor.UserID, 10)+" passed the two-factor authentication with a recovery code")
+	return nil
+}
+
+// increaseCounter increase the counter in the cache atomically and return the new value,
+// the counter expires in expire after the first increase
+func increaseCounter(key string, expire time.Duration) int {
+	if err := core.Cache.Add(key, 1, expire); err == nil {
+		return 1
+	}
+	n, err := core.Cache.IncrementInt(key, 1)
+	if err != nil {
+		// the counter expired between Add and IncrementInt
+		core.Cache.Set(key, 1, expire)
+		return 1
+	}
+	return n
+}
+
+// validateTOTP check the code against the secret, the code is accepted only once in its time step
+func validateTOTP(userTwoFactor model.UserTwoFactor, code string) error {
+	secret, err := decryptSecret(userTwoFactor.Secret)
+	if err != nil {
+		return err
+	}
+	if !totp.Validate(code, secret) {
+		return errors.New("Invalid two-factor code")
+	}
+	// totp.Validate accepts the previous and the next step, keep the code for all of them
+	if err := core.Cache.Add("totp:"+strconv.FormatInt(userTwoFactor.UserID, 10)+":"+code, true, 90*time.Second); err != nil {
+		return errors.New("The two-factor code has been used, please wait for the next one")
+	}
+	return nil
+}
+
+// GenerateRecoveryCodes replace the recovery codes of the user, only the hashes are stored
+func GenerateRecoveryCodes(userID int64) ([]string, error) {
+	var codes, codeHashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		codeHashes = append(codeHashes, hashRecoveryCode(code))
+	}
+	if err := (model.UserRecoveryCode{UserID: userID}).ReplaceByUserID(codeHashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode ignore the case and the separator the user typed
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// ResetTwoFactor remove the secret and the recovery codes, the user enrolls again on next login if required
+func ResetTwoFactor(userID int64) error {
+	if err := (model.UserTwoFactor{UserID: userID}).DeleteByUserID(); err != nil {
+		return err
+	}
+	return model.UserRecoveryCode{UserID: userID}.DeleteByUserID()
+}
+
+// CreatePreAuthToken return the short-lived token to finish the login with the two-factor code,
+// it is not a jwt so it never passes the login check of the router
+func CreatePreAuthToken(userID int64) (string, error) {
+	token, err := randomURLString(32)
+	if err != nil {
+		return "", err
+	}
+	core.Cache.Set("preAuth:"+token, PreAuth{UserID: userID}, preAuthExpire)
+	return token, nil
+}
+
+// GetPreAuth -
+func GetPreAuth(token string) (PreAuth, error) {
+	if x, found := core.Cache.Get("preAuth:" + token); found && token != "" {
+		return x.(PreAuth), nil
+	}
+	return PreAuth{}, errors.New("Login expired, please login again")
+}
+
+// FailPreAuth count the wrong code of the pre-auth token, the token is dropped when the attempts run out
+func FailPreAuth(token string) {
+	if increaseCounter("preAuthFailure:"+token, preAuthExpire) >= preAuthMaxAttempts {
+		DeletePreAuthToken(token)
+	}
+}
+
+// DeletePreAuthToken -
+func DeletePreAuthToken(token string) {
+	core.Cache.Delete("preAuth:" + token)
+	core.Cache.Delete("preAuthFailure:" + token)
+}
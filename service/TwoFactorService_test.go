@@ -0,0 +1,96 @@
+package service
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/zhenorzz/goploy/model"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	os.Setenv("SECRET_KEY", "two-factor-test")
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: twoFactorIssuer, AccountName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptSecret(key.Secret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := func(t time.Time) string {
+		c, _ := totp.GenerateCode(key.Secret(), t)
+		return c
+	}
+	now := time.Now()
+	tests := []struct {
+		name    string
+		code    string
+		wantErr bool
+	}{
+		{name: "current step", code: code(now)},
+		{name: "previous step", code: code(now.Add(-30 * time.Second))},
+		{name: "next step", code: code(now.Add(30 * time.Second))},
+		{name: "expired step", code: code(now.Add(-5 * time.Minute)), wantErr: true},
+		{name: "not numeric", code: "abcdef", wantErr: true},
+		{name: "too short", code: "12345", wantErr: true},
+	}
+	for i, tt := range tests {
+		// each case uses its own user, so the code is not rejected as used
+		userTwoFactor := model.UserTwoFactor{UserID: int64(1000 + i), Secret: encrypted}
+		if err := validateTOTP(userTwoFactor, tt.code); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateTOTP() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	userTwoFactor := model.UserTwoFactor{UserID: 2000, Secret: encrypted}
+	if err := validateTOTP(userTwoFactor, code(now)); err != nil {
+		t.Fatalf("validateTOTP() error = %v", err)
+	}
+	if err := validateTOTP(userTwoFactor, code(now)); err == nil {
+		t.Errorf("the used code is accepted again")
+	}
+	if err := validateTOTP(model.UserTwoFactor{UserID: 2001, Secret: encrypted}, code(now)); err != nil {
+		t.Errorf("the code used by the other user is rejected, error = %v", err)
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	hash := hashRecoveryCode("abcde-12345")
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{code: "abcde-12345", want: true},
+		{code: "ABCDE-12345", want: true},
+		{code: "abcde12345", want: true},
+		{code: " abcde 12345 ", want: true},
+		{code: "abc-de-123-45", want: true},
+		{code: "abcde-12346", want: false},
+		{code: "abcde-1234", want: false},
+		{code: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := hashRecoveryCode(tt.code) == hash; got != tt.want {
+			t.Errorf("hashRecoveryCode(%q) equal = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestIncreaseCounter(t *testing.T) {
+	key := "test:counter"
+	for want := 1; want <= twoFactorMaxFailures; want++ {
+		if got := increaseCounter(key, time.Minute); got != want {
+			t.Fatalf("increaseCounter() = %d, want %d", got, want)
+		}
+	}
+
+	key = "test:counter:expire"
+	increaseCounter(key, 10*time.Millisecond)
+	increaseCounter(key, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if got := increaseCounter(key, 10*time.Millisecond); got != 1 {
+		t.Errorf("increaseCounter() after expired = %d, want 1", got)
+	}
+}
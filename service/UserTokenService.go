@@ -0,0 +1,33 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhenorzz/goploy/model"
+)
+
+// CheckUserToken check the projects are in the namespace and the expire time is in the future,
+// return the project allow-list to store
+func CheckUserToken(namespaceID int64, projectIDs []int64, expireTime string) (string, error) {
+	if len(expireTime) != 0 {
+		expire, err := time.ParseInLocation("2006-01-02 15:04:05", expireTime, time.Local)
+		if err != nil {
+			return "", errors.New("Invalid expire time, " + err.Error())
+		}
+		if expire.Before(time.Now()) {
+			return "", errors.New("Expire time must be in the future")
+		}
+	}
+	var ids []string
+	for _, projectID := range projectIDs {
+		project, err := model.Project{ID: projectID}.GetData()
+		if err != nil || project.NamespaceID != namespaceID {
+			return "", errors.New("Project " + strconv.FormatInt(projectID, 10) + " is not in the namespace")
+		}
+		ids = append(ids, strconv.FormatInt(projectID, 10))
+	}
+	return strings.Join(ids, ","), nil
+}
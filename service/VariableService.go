@@ -0,0 +1,148 @@
+package service
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/zhenorzz/goploy/model"
+)
+
+// builtinVariablePrefix is reserved for the variables injected by goploy
+const builtinVariablePrefix = "GOPLOY_"
+
+var variableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// deniedVariableNames change how the shell or git runs the deploy scripts, so do the LD_* of the dynamic loader
+var deniedVariableNames = map[string]struct{}{
+	"BASH_ENV":        {},
+	"ENV":             {},
+	"IFS":             {},
+	"PATH":            {},
+	"HOME":            {},
+	"SHELL":           {},
+	"SHELLOPTS":       {},
+	"BASHOPTS":        {},
+	"PS4":             {},
+	"PROMPT_COMMAND":  {},
+	"CDPATH":          {},
+	"GLOBIGNORE":      {},
+	"GIT_SSH":         {},
+	"GIT_SSH_COMMAND": {},
+	"GIT_DIR":         {},
+	"GIT_WORK_TREE":   {},
+}
+
+// deniedVariableName -
+func deniedVariableName(name string) bool {
+	_, ok := deniedVariableNames[name]
+	return ok || strings.HasPrefix(name, "LD_")
+}
+
+// variable is a KEY=VALUE pair passed to the deploy scripts as environment variable
+type variable struct {
+	name  string
+	value string
+}
+
+// parseVariables parse one KEY=VALUE per line, the empty line and the line starts with # are ignored
+func parseVariables(variables string) ([]variable, error) {
+	var result []variable
+	for i, line := range strings.Split(variables, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || !variableNameRegexp.MatchString(name) {
+			return nil, errors.New("Variable line " + strconv.Itoa(i+1) + " must be KEY=VALUE")
+		}
+		if strings.HasPrefix(name, builtinVariablePrefix) {
+			return nil, errors.New("Variable " + name + " uses the reserved prefix " + builtinVariablePrefix)
+		}
+		if deniedVariableName(name) {
+			return nil, errors.New("Variable " + name + " is not allowed, it changes how the script runs")
+		}
+		result = append(result, variable{name: name, value: strings.TrimSpace(kv[1])})
+	}
+	return result, nil
+}
+
+// CheckVariables check the variables of the project or the project server
+func CheckVariables(variables string) error {
+	_, err := parseVariables(variables)
+	return err
+}
+
+// releaseDir return the directory which the release of the token is transferred to
+func releaseDir(project model.Project, token string) string {
+	if len(project.SymlinkPath) != 0 {
+		return path.Join(project.SymlinkPath, project.Name, token)
+	}
+	return project.Path
+}
+
+// scriptVariables return the built-in variables, the project variables, the server variables and the secrets,
+// the latter overrides the former of the same name
+func (sync Sync) scriptVariables(projectServer model.ProjectServer, token string) []variable {
+	commit := ""
+	if sync.artifact != nil {
+		commit = sync.artifact.Commit.Commit
+	}
+	variables := []variable{
+		{builtinVariablePrefix + "PROJECT_NAME", sync.Project.Name},
+		{builtinVariablePrefix + "COMMIT", commit},
+		{builtinVariablePrefix + "BRANCH", sync.Project.Branch},
+		{builtinVariablePrefix + "REF", sync.ref()},
+		{builtinVariablePrefix + "TOKEN", token},
+		{builtinVariablePrefix + "SERVER_NAME", projectServer.ServerName},
+		{builtinVariablePrefix + "SERVER_IP", projectServer.ServerIP},
+		{builtinVariablePrefix + "RELEASE_DIR", releaseDir(sync.Project, token)},
+		{builtinVariablePrefix + "PUBLISHER", sync.UserInfo.Name},
+	}
+	// the variables have been checked when the project is saved, the invalid ones saved before the denylist are all ignored
+	projectVariables, _ := parseVariables(sync.Project.Variables)
+	serverVariables, _ := parseVariables(projectServer.Variables)
+	variables = append(variables, projectVariables...)
+	variables = append(variables, serverVariables...)
+	return append(variables, sync.secrets...)
+}
+
+// environ return the variables in the form of os.Environ
+func environ(variables []variable) []string {
+	env := make([]string, 0, len(variables))
+	for _, v := range variables {
+		env = append(env, v.name+"="+v.value)
+	}
+	return env
+}
+
+// stdinEvalCommand run the commands sent over the stdin of the ssh session,
+// they are kept out of the command line which every user on the server can see with ps
+const stdinEvalCommand = `eval "$(cat)"`
+
+// exportCommand return the command which exports the variables before the script and the stdin to send,
+// the values of the variables are sent over the stdin
+func exportCommand(variables []variable, script string) (string, string) {
+	if len(variables) == 0 {
+		return script, ""
+	}
+	exports := make([]string, 0, len(variables))
+	for _, v := range variables {
+		exports = append(exports, v.name+"="+shellQuote(v.value))
+	}
+	return stdinEvalCommand + ";" + script, "export " + strings.Join(exports, " ") + "\n"
+}
+
+// localEnviron return the variables of the scripts run in the repository, there is no server yet
+func (sync Sync) localEnviron() []string {
+	return environ(sync.scriptVariables(model.ProjectServer{}, sync.Project.LastPublishToken))
+}
+
+// shellQuote quote s with single quotes for the shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParseVariables(t *testing.T) {
+	tests := []struct {
+		variables string
+		want      int
+		wantErr   bool
+	}{
+		{variables: "", want: 0},
+		{variables: "# comment\n\nA=1\n B = 2 \nC=", want: 3},
+		{variables: "URL=http://example.com/?a=b", want: 1},
+		{variables: "A", wantErr: true},
+		{variables: "1A=1", wantErr: true},
+		{variables: "A-B=1", wantErr: true},
+		{variables: "GOPLOY_TOKEN=1", wantErr: true},
+		{variables: "PATH=/tmp", wantErr: true},
+		{variables: "BASH_ENV=/tmp/x", wantErr: true},
+		{variables: "IFS=x", wantErr: true},
+		{variables: "LD_PRELOAD=/tmp/x.so", wantErr: true},
+		{variables: "LD_ANYTHING=1", wantErr: true},
+		{variables: "GIT_SSH_COMMAND=x", wantErr: true},
+		{variables: "path=/tmp", want: 1},
+	}
+	for _, tt := range tests {
+		got, err := parseVariables(tt.variables)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseVariables(%q) error = %v, wantErr %v", tt.variables, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && len(got) != tt.want {
+			t.Errorf("parseVariables(%q) = %v, want %d variables", tt.variables, got, tt.want)
+		}
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	if command, stdin := exportCommand(nil, "echo ok"); command != "echo ok" || stdin != "" {
+		t.Errorf("exportCommand() without variables = %q, %q", command, stdin)
+	}
+
+	secret := `it's a "secret" $HOME`
+	command, stdin := exportCommand([]variable{{"A", "1"}, {"SECRET", secret}, {"MULTI", "line1\nline2"}}, `printf '%s|%s|%s' "$A" "$SECRET" "$MULTI"`)
+	if strings.Contains(command, "secret") {
+		t.Errorf("the command line contains the value %q", command)
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = strings.NewReader(stdin)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("run %q error = %v", command, err)
+	}
+	if want := "1|" + secret + "|line1\nline2"; string(output) != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+)
+
+// GenerateWebhookSecret return 32 random bytes in hex
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WebhookURL the url of the project webhook seen by the browser, the proxy should forward X-Forwarded-Proto
+func WebhookURL(r *http.Request, projectID int64) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); len(proto) != 0 {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + "/deploy/webhook?project_id=" + strconv.FormatInt(projectID, 10)
+}
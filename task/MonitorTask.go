@@ -7,7 +7,7 @@ import (
 	"github.com/patrickmn/go-cache"
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
-	"net"
+	"github.com/zhenorzz/goploy/utils"
 	"net/http"
 	"strconv"
 	"time"
@@ -27,16 +27,13 @@ func monitorTask() {
 
 		if int(now-monitorCache["time"]) > monitor.Second {
 			monitorCache["time"] = now
-			conn, err := net.DialTimeout("tcp", monitor.Domain+":"+strconv.Itoa(monitor.Port), 5*time.Second)
-			if err != nil {
+			if err := utils.DialTCP(monitor.Domain+":"+strconv.Itoa(monitor.Port), 5*time.Second); err != nil {
 				monitorCache["errorTimes"]++
 				core.Log(core.ERROR, "monitor "+monitor.Name+" encounter error, "+err.Error())
 				if monitor.Times == uint16(monitorCache["errorTimes"]) {
 					monitorCache["errorTimes"] = 0
 					notice(monitor, err)
 				}
-			} else {
-				conn.Close()
 			}
 			core.Cache.Set("monitor:"+strconv.Itoa(int(monitor.ID)), monitorCache, cache.DefaultExpiration)
 		}
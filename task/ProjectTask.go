@@ -2,10 +2,10 @@ package task
 
 import (
 	"database/sql"
-	"github.com/google/uuid"
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
 	"github.com/zhenorzz/goploy/service"
+	"strconv"
 	"time"
 )
 
@@ -23,43 +23,54 @@ func projectTask() {
 			continue
 		}
 
-		if project.DeployState == model.ProjectDeploying {
-			core.Log(core.ERROR, "The project in publish is being build by other")
-			continue
-		}
-
 		if err := projectTask.SetRun(); err != nil {
 			core.Log(core.ERROR, "publish task set run fail, detail:"+err.Error())
 			continue
 		}
 
-		projectServers, err := model.ProjectServer{ProjectID: projectTask.ProjectID}.GetBindServerListByProjectID()
-
-		if err != nil {
-			core.Log(core.ERROR, "publish task has no server, detail:"+err.Error())
-			continue
-		}
-
 		userInfo, err := model.User{ID: 1}.GetData()
 		if err != nil {
 			core.Log(core.ERROR, "publish task has no user, detail:"+err.Error())
 			continue
 		}
 
-		project.PublisherID = userInfo.ID
-		project.PublisherName = userInfo.Name
-		project.DeployState = model.ProjectDeploying
-		project.LastPublishToken = uuid.New().String()
-		err = project.Publish()
-		if err != nil {
-			core.Log(core.ERROR, "publish task change state error, detail:"+err.Error())
+		if _, err := service.Enqueue(model.DeployQueue{
+			ProjectID:     project.ID,
+			ProjectName:   project.Name,
+			CommitID:      projectTask.CommitID,
+			Branch:        project.Branch,
+			Source:        model.QueueSourceTask,
+			PublisherID:   userInfo.ID,
+			PublisherName: userInfo.Name,
+		}); err != nil {
+			core.Log(core.ERROR, "publish task enqueue fail, detail:"+err.Error())
 			continue
 		}
-		go service.Sync{
-			UserInfo:       userInfo,
-			Project:        project,
-			ProjectServers: projectServers,
-			CommitID:       projectTask.CommitID,
-		}.Exec()
+
+		if _, err := service.DispatchQueue(project.ID); err != nil {
+			core.Log(core.ERROR, "publish task dispatch fail, detail:"+err.Error())
+		}
+
+		if freezeWindow, frozen, err := service.GetActiveFreeze(project); err != nil {
+			core.Log(core.ERROR, "publish task check freeze fail, detail:"+err.Error())
+		} else if frozen {
+			core.Log(core.WARNING, "projectID:"+strconv.FormatInt(project.ID, 10)+" publish task is queued, "+service.FreezeMessage(freezeWindow))
+		}
+	}
+}
+
+// deployQueueTask dispatch the waiting deploys, the queue is kept in database so it survives a restart,
+// the deploys which are not approved in time expire
+func deployQueueTask() {
+	service.ExpireApproving()
+	projectIDs, err := model.DeployQueue{}.GetWaitingProjectIDs()
+	if err != nil {
+		core.Log(core.ERROR, "get deploy queue error, detail:"+err.Error())
+		return
+	}
+	for _, projectID := range projectIDs {
+		if _, err := service.DispatchQueue(projectID); err != nil {
+			core.Log(core.ERROR, "dispatch deploy queue error, detail:"+err.Error())
+		}
 	}
 }
@@ -0,0 +1,13 @@
+package task
+
+import (
+	"github.com/zhenorzz/goploy/core"
+	"github.com/zhenorzz/goploy/model"
+)
+
+// sessionTask clean the expired login sessions
+func sessionTask() {
+	if err := (model.UserSession{}).DeleteExpired(); err != nil {
+		core.Log(core.ERROR, "delete expired session error, detail:"+err.Error())
+	}
+}
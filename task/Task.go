@@ -1,8 +1,13 @@
 package task
 
-import "time"
+import (
+	"time"
+
+	"github.com/zhenorzz/goploy/service"
+)
 
 func Init() {
+	go deployQueueTask()
 	go ticker()
 }
 
@@ -16,6 +21,9 @@ func ticker() {
 			monitorTask()
 		case <-minute:
 			projectTask()
+			service.ReconcileDeploys()
+			deployQueueTask()
+			sessionTask()
 		}
 	}
 }
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is the parsed "minute hour day-of-month month day-of-week" expression,
+// each field is a bit set of the matched values
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// the day matches either dom or dow when both of them are restricted
+	domStar, dowStar bool
+}
+
+// ParseCron support *, 1, 1-5, 1,3,5, */15 and 1-30/5 in each field, 7 of the day-of-week is sunday
+func ParseCron(expr string) (Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return Cron{}, errors.New("Cron expression must have 5 fields, minute hour day-of-month month day-of-week")
+	}
+	var cron Cron
+	var err error
+	if cron.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return Cron{}, err
+	}
+	if cron.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return Cron{}, err
+	}
+	if cron.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return Cron{}, err
+	}
+	if cron.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return Cron{}, err
+	}
+	if cron.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return Cron{}, err
+	}
+	if cron.dow&(1<<7) != 0 {
+		cron.dow |= 1
+	}
+	// as vixie cron, the field starts with * such as */2 is unrestricted
+	cron.domStar = strings.HasPrefix(fields[2], "*")
+	cron.dowStar = strings.HasPrefix(fields[4], "*")
+	return cron, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		hasStep := false
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.New("Invalid cron step " + part)
+			}
+			part = part[:i]
+			hasStep = true
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.New("Invalid cron value " + part)
+			}
+			// N/step runs from N to the max like the crontab, N alone is the single value
+			end = start
+			if hasStep {
+				end = max
+			}
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.New("Invalid cron value " + part)
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, errors.New("Cron value " + part + " out of range " + strconv.Itoa(min) + "-" + strconv.Itoa(max))
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Match report whether the minute of t is matched
+func (c Cron) Match(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "* * * * *"},
+		{expr: "0 2 * * 1-5"},
+		{expr: "*/15 0-23/2 1,15 1-12 0,7"},
+		{expr: "30 18 * * 7"},
+		{expr: "* * * *", wantErr: true},
+		{expr: "* * * * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "* 24 * * *", wantErr: true},
+		{expr: "* * 0 * *", wantErr: true},
+		{expr: "* * 32 * *", wantErr: true},
+		{expr: "* * * 13 *", wantErr: true},
+		{expr: "* * * * 8", wantErr: true},
+		{expr: "5-1 * * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "*/a * * * *", wantErr: true},
+		{expr: "60/5 * * * *", wantErr: true},
+		{expr: "a * * * *", wantErr: true},
+		{expr: "1-a * * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseCron(tt.expr); (err != nil) != tt.wantErr {
+			t.Errorf("ParseCron(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCronMatch(t *testing.T) {
+	// 2024-01-01 is monday, 2024-01-07 is sunday
+	date := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{expr: "* * * * *", t: date(1, 1, 0, 0), want: true},
+		{expr: "30 18 * * *", t: date(1, 1, 18, 30), want: true},
+		{expr: "30 18 * * *", t: date(1, 1, 18, 31), want: false},
+		{expr: "30 18 * * *", t: date(1, 1, 19, 30), want: false},
+		{expr: "*/15 * * * *", t: date(1, 1, 3, 45), want: true},
+		{expr: "*/15 * * * *", t: date(1, 1, 3, 46), want: false},
+		{expr: "10-30/10 * * * *", t: date(1, 1, 3, 20), want: true},
+		{expr: "10-30/10 * * * *", t: date(1, 1, 3, 40), want: false},
+		// N/step runs from N to the max
+		{expr: "5/10 * * * *", t: date(1, 1, 3, 5), want: true},
+		{expr: "5/10 * * * *", t: date(1, 1, 3, 55), want: true},
+		{expr: "5/10 * * * *", t: date(1, 1, 3, 10), want: false},
+		{expr: "0 0 * 2 *", t: date(2, 10, 0, 0), want: true},
+		{expr: "0 0 * 2 *", t: date(3, 10, 0, 0), want: false},
+		// the day-of-week only
+		{expr: "0 0 * * 1-5", t: date(1, 5, 0, 0), want: true},
+		{expr: "0 0 * * 1-5", t: date(1, 6, 0, 0), want: false},
+		// both 0 and 7 are sunday
+		{expr: "0 0 * * 0", t: date(1, 7, 0, 0), want: true},
+		{expr: "0 0 * * 7", t: date(1, 7, 0, 0), want: true},
+		{expr: "0 0 * * 7", t: date(1, 6, 0, 0), want: false},
+		{expr: "0 0 * * 5-7", t: date(1, 7, 0, 0), want: true},
+		// the day-of-month only
+		{expr: "0 0 15 * *", t: date(1, 15, 0, 0), want: true},
+		{expr: "0 0 15 * *", t: date(1, 16, 0, 0), want: false},
+		// the day matches either the day-of-month or the day-of-week when both are restricted
+		{expr: "0 0 15 * 1", t: date(1, 15, 0, 0), want: true},
+		{expr: "0 0 15 * 1", t: date(1, 22, 0, 0), want: true},
+		{expr: "0 0 15 * 1", t: date(1, 16, 0, 0), want: false},
+		// the field starts with * is unrestricted, so both of them must match
+		{expr: "0 0 */2 * 1", t: date(1, 15, 0, 0), want: true},
+		{expr: "0 0 */2 * 1", t: date(1, 22, 0, 0), want: false},
+		{expr: "0 0 */2 * 1", t: date(1, 17, 0, 0), want: false},
+	}
+	for _, tt := range tests {
+		cron, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) error = %v", tt.expr, err)
+		}
+		if got := cron.Match(tt.t); got != tt.want {
+			t.Errorf("ParseCron(%q).Match(%s) = %v, want %v", tt.expr, tt.t.Format("2006-01-02 15:04 Mon"), got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -9,6 +10,8 @@ import (
 
 type GIT struct {
 	Dir string
+	// Ctx kill the git process when it is done
+	Ctx context.Context
 	Output bytes.Buffer
 	Err  bytes.Buffer
 }
@@ -16,7 +19,11 @@ type GIT struct {
 func (git *GIT) Run(operator string,options []string) error {
 	git.Output.Reset()
 	git.Err.Reset()
-	cmd := exec.Command("git", append([]string{operator}, options...)...)
+	ctx := git.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := exec.CommandContext(ctx, "git", append([]string{operator}, options...)...)
 	if len(git.Dir) != 0 {
 		cmd.Dir = git.Dir
 	}
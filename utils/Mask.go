@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecretMask replaces the secret values in the log, the trace and the websocket message
+const SecretMask = "******"
+
+var masks = struct {
+	sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}{values: map[string]struct{}{}}
+
+// AddMask register the secret values which should never be shown
+func AddMask(values ...string) {
+	masks.Lock()
+	defer masks.Unlock()
+	added := false
+	for _, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		// the value may be escaped in the json of the trace ext and the websocket message
+		escaped, _ := json.Marshal(value)
+		for _, v := range []string{value, strings.Trim(string(escaped), `"`)} {
+			if _, ok := masks.values[v]; !ok {
+				masks.values[v] = struct{}{}
+				added = true
+			}
+		}
+	}
+	if !added {
+		return
+	}
+	// the longer value goes first, so the secret which contains another secret is masked entirely
+	sorted := make([]string, 0, len(masks.values))
+	for value := range masks.values {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	oldnew := make([]string, 0, len(sorted)*2)
+	for _, value := range sorted {
+		oldnew = append(oldnew, value, SecretMask)
+	}
+	masks.replacer = strings.NewReplacer(oldnew...)
+}
+
+// Mask return s with the registered secret values replaced
+func Mask(s string) string {
+	masks.RLock()
+	replacer := masks.replacer
+	masks.RUnlock()
+	if replacer == nil {
+		return s
+	}
+	return replacer.Replace(s)
+}
@@ -118,13 +118,40 @@ func ParseCommandLine(command string) ([]string, error) {
 
 // ConnectSSH connect ssh
 func ConnectSSH(user, password, host string, port int) (*ssh.Session, error) {
+	var (
+		client  *ssh.Client
+		session *ssh.Session
+		err     error
+	)
+	if client, err = DialSSH(user, password, host, port); err != nil {
+		return nil, err
+	}
+
+	// create session
+	if session, err = client.NewSession(); err != nil {
+		return nil, err
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,     // disable echoing
+		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+	}
+
+	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// DialSSH dial ssh with the key of SSHKEY_PATH, the caller opens the sessions and closes the client
+func DialSSH(user, password, host string, port int) (*ssh.Client, error) {
 	var (
 		auth         []ssh.AuthMethod
 		addr         string
 		clientConfig *ssh.ClientConfig
-		client       *ssh.Client
 		config       ssh.Config
-		session      *ssh.Session
 		err          error
 	)
 	// get auth method
@@ -163,26 +190,16 @@ func ConnectSSH(user, password, host string, port int) (*ssh.Session, error) {
 	// connect to ssh
 	addr = fmt.Sprintf("%s:%d", host, port)
 
-	if client, err = ssh.Dial("tcp", addr, clientConfig); err != nil {
-		return nil, err
-	}
-
-	// create session
-	if session, err = client.NewSession(); err != nil {
-		return nil, err
-	}
-
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          0,     // disable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-	}
+	return ssh.Dial("tcp", addr, clientConfig)
+}
 
-	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
-		return nil, err
+// DialTCP check the address accepts the tcp connection in timeout
+func DialTCP(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
 	}
-
-	return session, nil
+	return conn.Close()
 }
 
 func ClearNewline(str string) string {
@@ -0,0 +1,252 @@
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `pipeline` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '部署流程，逗号分隔' AFTER `rsync_option`;
+
+ALTER TABLE `goploy`.`publish_trace`
+MODIFY COLUMN `type` tinyint(3) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1拉代码前脚本，2.git获取代码，3拉代码后脚本，4部署前脚本，5部署日志，6部署后脚本，7清理，8回滚，9切换版本，10打包制品，11健康检查';
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `before_pull_script_mode` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '脚本类型' AFTER `branch`,
+ADD COLUMN `before_pull_script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '拉代码前脚本' AFTER `before_pull_script_mode`,
+ADD COLUMN `before_deploy_script_mode` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '脚本类型' AFTER `after_pull_script`,
+ADD COLUMN `before_deploy_script` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '部署前脚本' AFTER `before_deploy_script_mode`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `deploy_strategy` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '0=>全量 1=>滚动 2=>金丝雀' AFTER `pipeline`,
+ADD COLUMN `batch_size` smallint(5) UNSIGNED NOT NULL DEFAULT 0 COMMENT '每批服务器数量，0=>剩余全部' AFTER `deploy_strategy`,
+ADD COLUMN `canary_confirm` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '金丝雀成功后 0=>自动继续 1=>人工确认' AFTER `batch_size`;
+
+ALTER TABLE `goploy`.`publish_trace`
+ADD COLUMN `batch` smallint(5) UNSIGNED NOT NULL DEFAULT '0' COMMENT '部署批次，0=>非服务器步骤' AFTER `type`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `auto_rollback` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '部署失败时 0=>不回滚 1=>已切换的服务器回滚到上一版本' AFTER `canary_confirm`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `retain_count` smallint(5) UNSIGNED NOT NULL DEFAULT 10 COMMENT '保留最新的版本数量，0=>不按数量保留' AFTER `auto_rollback`,
+ADD COLUMN `retain_days` smallint(5) UNSIGNED NOT NULL DEFAULT 0 COMMENT '保留最近天数内的版本，0=>不按天数保留' AFTER `retain_count`,
+ADD COLUMN `pinned_releases` varchar(2000) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '固定保留的版本token，逗号分隔' AFTER `retain_days`;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`deploy_queue` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `project_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `project_name` varchar(255) NOT NULL DEFAULT '',
+  `commit_id` varchar(255) NOT NULL DEFAULT '',
+  `branch` varchar(255) NOT NULL DEFAULT '',
+  `source` tinyint(4) unsigned NOT NULL DEFAULT '1' COMMENT '1手动，2webhook，3定时任务',
+  `state` tinyint(4) unsigned NOT NULL DEFAULT '0' COMMENT '0等待，1已部署，2已取消',
+  `token` char(36) CHARACTER SET utf8mb4 NOT NULL DEFAULT '' COMMENT '出队后的部署token',
+  `publisher_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `publisher_name` varchar(255) NOT NULL DEFAULT '',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `index_project_state` (`project_id`,`state`) USING BTREE COMMENT 'project_id,state'
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `requeue_interrupted` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '服务重启中断的部署 0=>标记失败 1=>重新排队' AFTER `pinned_releases`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `deploy_owner` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '执行部署的实例' AFTER `last_publish_token`,
+ADD COLUMN `deploy_heartbeat` int(10) UNSIGNED NOT NULL DEFAULT 0 COMMENT '部署心跳时间戳' AFTER `deploy_owner`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `stage_timeout` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '部署步骤超时秒数，格式 git:300,transfer:600' AFTER `pipeline`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `transfer_mode` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '文件传输方式 0=>rsync 1=>ssh' AFTER `stage_timeout`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `artifact_mode` tinyint(4) UNSIGNED NOT NULL DEFAULT 0 COMMENT '0=>同步代码目录 1=>打包制品部署' AFTER `transfer_mode`,
+ADD COLUMN `artifact_path` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '制品输出目录，相对代码目录，空=>整个代码目录' AFTER `artifact_mode`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `variables` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '脚本变量，每行一个 KEY=VALUE' AFTER `rsync_option`;
+
+ALTER TABLE `goploy`.`project_server`
+ADD COLUMN `variables` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL COMMENT '服务器脚本变量，每行一个 KEY=VALUE' AFTER `server_id`;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`secret` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `namespace_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `project_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '0=>空间内所有项目',
+  `name` varchar(255) NOT NULL DEFAULT '' COMMENT '注入脚本的变量名',
+  `value` text NOT NULL COMMENT 'AES-GCM 加密后的值',
+  `description` varchar(255) NOT NULL DEFAULT '',
+  `creator_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `creator` varchar(255) NOT NULL DEFAULT '',
+  `editor_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `editor` varchar(255) NOT NULL DEFAULT '',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `uk_namespace_project_name` (`namespace_id`,`project_id`,`name`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`project_config_template` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `project_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `path` varchar(255) NOT NULL DEFAULT '' COMMENT '相对发布目录的文件路径',
+  `content` text NOT NULL COMMENT 'text/template 模板内容',
+  `creator_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `creator` varchar(255) NOT NULL DEFAULT '',
+  `editor_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `editor` varchar(255) NOT NULL DEFAULT '',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_project_id` (`project_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`project_health_check` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `project_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `type` tinyint(4) unsigned NOT NULL DEFAULT '1' COMMENT '1=>http 2=>tcp',
+  `target` varchar(255) NOT NULL DEFAULT '' COMMENT 'url 或 host:port 模板',
+  `expect_status` smallint(5) unsigned NOT NULL DEFAULT '0' COMMENT '期望状态码，0=>任意2xx',
+  `expect_body` varchar(255) NOT NULL DEFAULT '' COMMENT '响应内容需包含的字符串',
+  `retries` smallint(5) unsigned NOT NULL DEFAULT '3' COMMENT '失败重试次数',
+  `interval` smallint(5) unsigned NOT NULL DEFAULT '5' COMMENT '重试间隔秒数',
+  `timeout` smallint(5) unsigned NOT NULL DEFAULT '5' COMMENT '单次检查超时秒数',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_project_id` (`project_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `approval_count` smallint(5) UNSIGNED NOT NULL DEFAULT 0 COMMENT '部署需要的审批人数 0=>使用环境配置' AFTER `requeue_interrupted`,
+ADD COLUMN `approval_role` varchar(20) NOT NULL DEFAULT '' COMMENT '审批人最低角色，空=>manager' AFTER `approval_count`;
+
+ALTER TABLE `goploy`.`deploy_queue`
+MODIFY COLUMN `state` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '0等待，1已部署，2已取消，3待审批，4已驳回，5审批超时',
+ADD COLUMN `approval_count` smallint(5) UNSIGNED NOT NULL DEFAULT '0' COMMENT '需要的审批人数' AFTER `publisher_name`,
+ADD COLUMN `approval_role` varchar(20) NOT NULL DEFAULT '' COMMENT '审批人最低角色' AFTER `approval_count`,
+ADD COLUMN `summary` text COMMENT '提交及变更文件摘要' AFTER `approval_role`,
+ADD COLUMN `expire_time` datetime DEFAULT NULL COMMENT '审批截止时间' AFTER `summary`;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`environment_approval` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `namespace_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `environment` varchar(255) NOT NULL DEFAULT '' COMMENT '对应project.environment',
+  `approval_count` smallint(5) unsigned NOT NULL DEFAULT '0' COMMENT '部署需要的审批人数',
+  `approval_role` varchar(20) NOT NULL DEFAULT '' COMMENT '审批人最低角色，空=>manager',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `uk_namespace_environment` (`namespace_id`,`environment`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`deploy_approval` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `queue_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `user_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `user_name` varchar(255) NOT NULL DEFAULT '',
+  `decision` tinyint(4) unsigned NOT NULL DEFAULT '1' COMMENT '1同意，2驳回',
+  `comment` varchar(255) NOT NULL DEFAULT '',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `uk_queue_user` (`queue_id`,`user_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+ALTER TABLE `goploy`.`deploy_queue`
+ADD COLUMN `freeze_override` tinyint(4) UNSIGNED NOT NULL DEFAULT '0' COMMENT '1=>管理员强制在冻结期部署' AFTER `expire_time`;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`freeze_window` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `namespace_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `project_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '0=>空间内所有项目',
+  `type` tinyint(4) unsigned NOT NULL DEFAULT '1' COMMENT '1=>周期规则 2=>时间段',
+  `cron` varchar(255) NOT NULL DEFAULT '' COMMENT '分 时 日 月 周，匹配的每一分钟都冻结',
+  `start_time` datetime DEFAULT NULL,
+  `end_time` datetime DEFAULT NULL,
+  `reason` varchar(255) NOT NULL DEFAULT '',
+  `creator_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `creator` varchar(255) NOT NULL DEFAULT '',
+  `editor_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `editor` varchar(255) NOT NULL DEFAULT '',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_namespace_project` (`namespace_id`,`project_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`freeze_override` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `project_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `queue_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `window_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `user_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `user_name` varchar(255) NOT NULL DEFAULT '',
+  `justification` varchar(255) NOT NULL DEFAULT '' COMMENT '冻结期强制部署的理由',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_project_id` (`project_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `tag_pattern` varchar(255) NOT NULL DEFAULT '' COMMENT 'webhook 推送tag时触发部署的匹配规则，空=>忽略tag' AFTER `approval_role`;
+
+ALTER TABLE `goploy`.`deploy_queue`
+ADD COLUMN `ref` varchar(255) NOT NULL DEFAULT '' COMMENT '部署的分支、tag或commit，空=>项目分支' AFTER `branch`;
+
+ALTER TABLE `goploy`.`project`
+ADD COLUMN `webhook_secret` varchar(64) NOT NULL DEFAULT '' COMMENT 'webhook 签名密钥' AFTER `tag_pattern`;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`user_token` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `namespace_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `name` varchar(255) NOT NULL DEFAULT '',
+  `token_hash` char(64) NOT NULL DEFAULT '' COMMENT 'token的sha256',
+  `token_prefix` varchar(16) NOT NULL DEFAULT '' COMMENT '用于识别token的前缀',
+  `scope` tinyint(4) unsigned NOT NULL DEFAULT '1' COMMENT '1=>只读 2=>发布',
+  `project_ids` varchar(1000) NOT NULL DEFAULT '' COMMENT '允许的项目id，逗号分隔，空=>空间内所有项目',
+  `expire_time` datetime DEFAULT NULL COMMENT '空=>永不过期',
+  `last_used_time` datetime DEFAULT NULL,
+  `last_used_ip` varchar(64) NOT NULL DEFAULT '',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `uk_token_hash` (`token_hash`) USING BTREE,
+  KEY `idx_user_id` (`user_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+ALTER TABLE `goploy`.`namespace`
+ADD COLUMN `two_factor_role` varchar(20) NOT NULL DEFAULT '' COMMENT '该角色及以上需要两步验证，空=>不需要' AFTER `name`;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`user_two_factor` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `secret` varchar(255) NOT NULL DEFAULT '' COMMENT 'AES-GCM 加密后的TOTP密钥',
+  `enabled` tinyint(4) unsigned NOT NULL DEFAULT '0' COMMENT '0=>待验证 1=>已启用',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `uk_user_id` (`user_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`user_recovery_code` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `code_hash` char(64) NOT NULL DEFAULT '' COMMENT '恢复码的sha256',
+  `used_time` datetime DEFAULT NULL COMMENT '空=>未使用',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_user_id` (`user_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
+
+CREATE TABLE IF NOT EXISTS `goploy`.`user_session` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` int(10) unsigned NOT NULL DEFAULT '0',
+  `session_id` char(32) NOT NULL DEFAULT '' COMMENT 'jwt中的sid',
+  `ip` varchar(64) NOT NULL DEFAULT '',
+  `user_agent` varchar(255) NOT NULL DEFAULT '',
+  `last_active_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `expire_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '超过该时间未活动=>失效',
+  `insert_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `uk_session_id` (`session_id`) USING BTREE,
+  KEY `idx_user_id` (`user_id`) USING BTREE,
+  KEY `idx_expire_time` (`expire_time`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
@@ -87,10 +87,10 @@ export function remove(id) {
   })
 }
 
-export function install(serverId, templateId) {
+export function install(serverId, templateId, secretNames = []) {
   return request({
     url: '/server/install',
     method: 'post',
-    data: { serverId, templateId }
+    data: { serverId, templateId, secretNames }
   })
 }
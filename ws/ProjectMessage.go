@@ -14,16 +14,27 @@ type ProjectMessage struct {
 }
 
 const (
-	ProjectFail     = 0
-	GitClone        = 1
-	GitReset        = 1
-	GitSwitchBranch = 2
-	GitClean        = 3
-	GitCheckout     = 4
-	GitPull         = 5
-	AfterPullScript = 6
-	Rsync           = 7
-	ProjectSuccess  = 8
+	ProjectFail        = 0
+	BeforePullScript   = 1
+	GitClone           = 1
+	GitReset           = 1
+	GitSwitchBranch    = 2
+	GitClean           = 3
+	GitCheckout        = 4
+	GitPull            = 5
+	AfterPullScript    = 6
+	PackArtifact       = 6
+	DeployBatch        = 7
+	CanaryConfirm      = 7
+	BeforeDeployScript = 7
+	Rsync              = 7
+	AfterDeployScript  = 7
+	Clean              = 7
+	Rollback           = 7
+	SwitchRelease      = 7
+	HealthCheck        = 7
+	ProjectSuccess     = 8
+	ProjectCancel      = 9
 )
 
 func (projectMessage ProjectMessage) canSendTo(client *Client) error {
@@ -1,9 +1,11 @@
 package ws
 
 import (
+	"encoding/json"
 	"github.com/gorilla/websocket"
 	"github.com/zhenorzz/goploy/core"
 	"github.com/zhenorzz/goploy/model"
+	"github.com/zhenorzz/goploy/utils"
 	"net/http"
 	"strings"
 	"time"
@@ -149,6 +151,19 @@ func (hub *Hub) run() {
 				client.Conn.Close()
 			}
 		case data := <-hub.Data:
+			message, err := json.Marshal(struct {
+				Type    int         `json:"type"`
+				Message interface{} `json:"message"`
+			}{
+				Type:    data.Type,
+				Message: data.Message,
+			})
+			if err != nil {
+				core.Log(core.ERROR, err.Error())
+				continue
+			}
+			// the script output may contain the secrets
+			message = []byte(utils.Mask(string(message)))
 			for client := range hub.clients {
 				if data.Message.canSendTo(client) != nil {
 					continue
@@ -159,14 +174,7 @@ func (hub *Hub) run() {
 						continue
 					}
 				}
-				if err := client.Conn.WriteJSON(
-					struct {
-						Type    int         `json:"type"`
-						Message interface{} `json:"message"`
-					}{
-						Type:    data.Type,
-						Message: data.Message,
-					}); websocket.IsCloseError(err) {
+				if err := client.Conn.WriteMessage(websocket.TextMessage, message); websocket.IsCloseError(err) {
 					hub.Unregister <- client
 				}
 			}